@@ -0,0 +1,58 @@
+// Command fake-mailcow serves an in-memory Mailcow API for manual testing of the bridge.
+//
+// Point MAILCOW_ADMIN_API_URL at it and use the --dev auth of the bridge, or any IMAP server.
+// Faults are injected at runtime through the /fake endpoints, see internal/mailcow/mailcowtest.
+package main
+
+import (
+	"flag"
+	"net/http"
+	"os"
+	"strings"
+
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/logger"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/mailcow/mailcowtest"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:8081", "address to listen on")
+	apiKey := flag.String("api-key", "fake-key", "API key clients must send, any key if empty")
+	domains := flag.String("domains", "example.com", "comma-separated mail domains")
+	mailboxes := flag.String("mailboxes", "alice@example.com,bob@example.com", "comma-separated mailboxes")
+	latency := flag.Duration("latency", 0, "delay of every API response")
+	logLevel := flag.String("log-level", "INFO", "log level")
+	flag.Parse()
+
+	logger.SetupGlobal(*logLevel, true)
+	log := logger.WithComponent("FakeMailcow")
+
+	fake := mailcowtest.New(*apiKey)
+	for _, domain := range splitList(*domains) {
+		fake.AddDomain(domain, true)
+	}
+	for _, mailbox := range splitList(*mailboxes) {
+		if !strings.Contains(mailbox, "@") {
+			log.Error("Invalid mailbox %q, expected an address", mailbox)
+			os.Exit(1)
+		}
+		fake.AddMailbox(mailcowtest.Mailbox{Username: mailbox, Name: mailbox[:strings.Index(mailbox, "@")], Active: true})
+	}
+	fake.SetLatency(*latency)
+
+	log.Info("Fake Mailcow API listening on http://%s with API key %q", *addr, *apiKey)
+	if err := http.ListenAndServe(*addr, fake); err != nil {
+		log.Error("Server stopped: %v", err)
+		os.Exit(1)
+	}
+}
+
+// splitList splits a comma-separated list, skipping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,175 @@
+package activity
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/backend"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/logger"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/mailcow"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/store"
+)
+
+// Tracker aggregates deliveries and rejections from the Mailcow logs into the store,
+// so alias statistics survive the log retention
+type Tracker struct {
+	backends *backend.Set
+	store    *store.Store
+	logLines int
+	mu       sync.Mutex // one sync at a time
+	logger   *logger.Logger
+}
+
+// NewTracker creates a tracker reading logLines entries of each log per sync
+func NewTracker(backends *backend.Set, st *store.Store, logLines int) *Tracker {
+	return &Tracker{
+		backends: backends,
+		store:    st,
+		logLines: logLines,
+		logger:   logger.WithComponent("Activity"),
+	}
+}
+
+// Sync counts the log entries of all backends newer than the last sync and returns the number of
+// activities recorded. A failing backend does not keep the others from being synced.
+func (t *Tracker) Sync() (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	total := 0
+	var errs []error
+	for _, b := range t.backends.All() {
+		// Only Mailcow offers its logs
+		if b.Mailcow == nil {
+			continue
+		}
+		recorded, err := t.syncBackend(b)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("backend %s: %w", b.Name, err))
+		}
+		total += recorded
+	}
+	return total, errors.Join(errs...)
+}
+
+// syncBackend counts the log entries of a backend newer than the last sync.
+// Entries are only counted once as the store remembers the newest entry seen per backend; entries
+// logged within the same second after a sync are missed, which is acceptable for statistics.
+func (t *Tracker) syncBackend(b *backend.Backend) (int, error) {
+	entries, err := b.Mailcow.GetPostfixLogs(t.logLines)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read postfix logs: %w", err)
+	}
+	history, err := b.Mailcow.GetRspamdHistory(t.logLines)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read rspamd history: %w", err)
+	}
+
+	cursor := t.store.ActivityCursor(b.Name)
+	newest := cursor
+
+	// Without an earlier read, or if a full page of entries does not reach back to the last one,
+	// deliveries may have been missed and the observation starts anew
+	if _, observed := t.store.ActivityObservedSince(b.Name); !observed || t.truncated(len(entries), oldestLogEntry(entries), cursor) ||
+		t.truncated(len(history), oldestRspamdEntry(history), cursor) {
+		if observed {
+			t.logger.Warn("More than %d log entries of %s since the last sync, activity may have been missed", t.logLines, b.Name)
+		}
+		if err := t.store.RestartActivityObservation(b.Name, time.Now()); err != nil {
+			return 0, fmt.Errorf("failed to store activity observation: %w", err)
+		}
+	}
+	activities := make(map[string][]store.Activity)
+
+	// The sender is logged by the queue manager, the delivery in a separate line
+	senders := make(map[string]string)
+	for _, entry := range entries {
+		if sender := entry.Sender(); sender != "" {
+			senders[entry.QueueID()] = sender
+		}
+	}
+
+	for _, entry := range entries {
+		if !entry.At().After(cursor) || !entry.IsDelivery() {
+			continue
+		}
+		if entry.At().After(newest) {
+			newest = entry.At()
+		}
+		for _, recipient := range unique(entry.Recipients()) {
+			activities[recipient] = append(activities[recipient], store.Activity{
+				Action:    store.ActivityForward,
+				From:      senders[entry.QueueID()],
+				Timestamp: entry.At(),
+			})
+		}
+	}
+
+	for _, entry := range history {
+		if !entry.At().After(cursor) || !entry.IsRejected() {
+			continue
+		}
+		if entry.At().After(newest) {
+			newest = entry.At()
+		}
+		for _, recipient := range unique(entry.Recipients) {
+			activities[recipient] = append(activities[recipient], store.Activity{
+				Action:    store.ActivityBlock,
+				From:      strings.ToLower(entry.Sender),
+				Timestamp: entry.At(),
+			})
+		}
+	}
+
+	recorded, err := t.store.AddActivities(activities, b.Name, newest)
+	if err != nil {
+		return 0, fmt.Errorf("failed to store activities: %w", err)
+	}
+	t.logger.Debug("Recorded %d activities of %s up to %s", recorded, b.Name, newest.Format(time.RFC3339))
+	return recorded, nil
+}
+
+// truncated reports whether a read of n entries, the oldest logged at oldest, may have missed entries
+// logged after cursor because the log had more entries than read
+func (t *Tracker) truncated(n int, oldest, cursor time.Time) bool {
+	return n >= t.logLines && oldest.After(cursor)
+}
+
+// oldestLogEntry returns the time of the oldest postfix log entry
+func oldestLogEntry(entries []mailcow.LogEntry) time.Time {
+	var oldest time.Time
+	for i, entry := range entries {
+		if i == 0 || entry.At().Before(oldest) {
+			oldest = entry.At()
+		}
+	}
+	return oldest
+}
+
+// oldestRspamdEntry returns the time of the oldest rspamd history entry
+func oldestRspamdEntry(history []mailcow.RspamdEntry) time.Time {
+	var oldest time.Time
+	for i, entry := range history {
+		if i == 0 || entry.At().Before(oldest) {
+			oldest = entry.At()
+		}
+	}
+	return oldest
+}
+
+// unique returns the lowercased addresses without duplicates
+func unique(addresses []string) []string {
+	seen := make(map[string]bool, len(addresses))
+	var result []string
+	for _, address := range addresses {
+		address = strings.ToLower(address)
+		if !seen[address] {
+			seen[address] = true
+			result = append(result, address)
+		}
+	}
+	return result
+}
@@ -0,0 +1,123 @@
+package activity
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/backend"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/mailcow"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/store"
+)
+
+func TestTrackerSync(t *testing.T) {
+	delivered := time.Now().Add(-2 * time.Hour).Unix()
+	rejected := time.Now().Add(-time.Hour).Unix()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/get/logs/postfix/100":
+			fmt.Fprintf(w, `[
+				{"time": "%[1]d", "program": "postfix/qmgr", "message": "4ABC: from=<Shop@example.org>, size=1234, nrcpt=1 (queue active)"},
+				{"time": "%[1]d", "program": "postfix/lmtp", "message": "4ABC: to=<alice@example.com>, orig_to=<site@example.com>, relay=dovecot, status=sent (250 2.0.0 Saved)"}
+			]`, delivered)
+		case "/api/v1/get/logs/rspamd-history/100":
+			fmt.Fprintf(w, `[{"unix_time": %d.25, "action": "reject", "sender_smtp": "spam@example.net", "rcpt_smtp": ["Site@example.com"]},
+				{"unix_time": %d, "action": "no action", "sender_smtp": "shop@example.org", "rcpt_smtp": ["site@example.com"]}]`, rejected, rejected)
+		default:
+			w.Write([]byte(`[]`))
+		}
+	}))
+	defer server.Close()
+
+	client, err := mailcow.NewMailcowClient(server.URL, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := store.Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.AddAlias(store.AliasRecord{Address: "site@example.com", Owner: "alice@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tracker := NewTracker(backend.Single(client, nil), st, 100)
+	recorded, err := tracker.Sync()
+	if err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if recorded != 2 {
+		t.Errorf("expected 2 activities, got %d", recorded)
+	}
+
+	record, _ := st.AliasByID(1)
+	if record.NbForward != 1 || record.NbBlock != 1 {
+		t.Errorf("unexpected counters: forward %d, block %d", record.NbForward, record.NbBlock)
+	}
+	if len(record.Activities) != 2 || record.Activities[1].From != "shop@example.org" || record.Activities[0].From != "spam@example.net" {
+		t.Errorf("unexpected activities: %+v", record.Activities)
+	}
+
+	// The same log entries are not counted twice
+	if recorded, err := tracker.Sync(); err != nil || recorded != 0 {
+		t.Errorf("second sync recorded %d activities, %v", recorded, err)
+	}
+}
+
+func TestTrackerObservation(t *testing.T) {
+	// The postfix log always returns a full page of two deliveries, the newest at logged
+	logged := time.Now().Add(-time.Hour).Unix()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/get/logs/postfix/2":
+			fmt.Fprintf(w, `[
+				{"time": "%d", "program": "postfix/lmtp", "message": "B: to=<alice@example.com>, orig_to=<site@example.com>, status=sent"},
+				{"time": "%d", "program": "postfix/lmtp", "message": "A: to=<alice@example.com>, orig_to=<site@example.com>, status=sent"}
+			]`, logged, logged-60)
+		default:
+			w.Write([]byte(`[]`))
+		}
+	}))
+	defer server.Close()
+
+	client, err := mailcow.NewMailcowClient(server.URL, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := store.Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker := NewTracker(backend.Single(client, nil), st, 2)
+
+	if _, ok := st.ActivityObservedSince("default"); ok {
+		t.Fatal("observation started before the first sync")
+	}
+	if _, err := tracker.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	first, ok := st.ActivityObservedSince("default")
+	if !ok {
+		t.Fatal("first sync did not start the observation")
+	}
+
+	// The page overlaps the last sync, nothing was missed
+	if _, err := tracker.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if since, _ := st.ActivityObservedSince("default"); !since.Equal(first) {
+		t.Errorf("observation restarted without a gap: %s, was %s", since, first)
+	}
+
+	// A full page entirely newer than the last sync may have missed entries in between
+	logged += 3600
+	if _, err := tracker.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if since, _ := st.ActivityObservedSince("default"); !since.After(first) {
+		t.Errorf("observation not restarted after a gap: %s", since)
+	}
+}
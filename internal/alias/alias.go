@@ -27,9 +27,22 @@ const (
 	TypeNames     = "names"
 )
 
+// Alias types
+const (
+	TypePermanent  = "permanent"  // regular Mailcow alias
+	TypeTemporary  = "temporary"  // Mailcow time-limited alias, expiring on its own
+	TypeSubaddress = "subaddress" // user+tag@domain, delivered by Mailcow without an alias
+)
+
+// ValidType reports whether t is a known alias type
+func ValidType(t string) bool {
+	return t == TypePermanent || t == TypeTemporary || t == TypeSubaddress
+}
+
 // Template placeholders
 const (
-	DomainPlaceholder = "%d"
+	DomainPlaceholder   = "%d"
+	HostnamePlaceholder = "{hostname}"
 )
 
 // Generator patterns
@@ -140,7 +153,15 @@ func GenerateAlias(email, pattern string) (string, error) {
 	if len(parts) != 2 {
 		return "", fmt.Errorf("invalid email format")
 	}
-	domain := parts[1]
+
+	return GenerateAliasWithDomain(parts[1], pattern)
+}
+
+// GenerateAliasWithDomain generates a new email alias from a pattern, substituting the domain placeholder with domain.
+func GenerateAliasWithDomain(domain, pattern string) (string, error) {
+	if domain == "" || pattern == "" {
+		return "", fmt.Errorf("domain and pattern must be set")
+	}
 
 	// Process template
 	processed := pattern
@@ -160,6 +181,45 @@ func GenerateAlias(email, pattern string) (string, error) {
 	return processed, nil
 }
 
+// PatternDomain returns the domain a pattern always generates aliases on,
+// or an empty string if the domain depends on the mailbox or the template variables.
+func PatternDomain(pattern string) string {
+	at := strings.LastIndex(pattern, "@")
+	if at < 0 {
+		return ""
+	}
+	domain := pattern[at+1:]
+	if domain == "" || strings.Contains(domain, DomainPlaceholder) || strings.ContainsAny(domain, "{}") {
+		return ""
+	}
+	return strings.ToLower(domain)
+}
+
+// tagRegex matches characters not allowed in subaddress tags
+var tagRegex = regexp.MustCompile(`[^a-z0-9._-]+`)
+
+// GenerateSubaddress generates a subaddress user+tag@domain of an email address. The tag is built
+// from a pattern, which may contain the hostname placeholder besides the usual template variables.
+func GenerateSubaddress(email, pattern, hostname string) (string, error) {
+	at := strings.LastIndex(email, "@")
+	if at <= 0 || pattern == "" {
+		return "", fmt.Errorf("valid email and pattern must be set")
+	}
+
+	// Strip subdomains most sites share, e.g. www.github.com becomes github.com
+	hostname = strings.TrimPrefix(strings.ToLower(hostname), "www.")
+	processed := strings.Replace(pattern, HostnamePlaceholder, hostname, -1)
+	processed = replaceTemplateVariables(processed, generateCoordinatedNames(identifyNameTypes(processed)))
+
+	tag := strings.Trim(tagRegex.ReplaceAllString(strings.ToLower(processed), "-"), ".-_")
+	if tag == "" {
+		// No hostname given and nothing else in the pattern
+		tag = generateWordChars(8)
+	}
+
+	return email[:at] + "+" + tag + email[at:], nil
+}
+
 // identifyNameTypes identifies all name type patterns in a template
 func identifyNameTypes(pattern string) map[string]bool {
 	nameTypes := make(map[string]bool)
@@ -676,3 +736,17 @@ func randBetween(min, max int) int {
 	}
 	return randSource.Intn(max-min+1) + min
 }
+
+// prefixRegex matches local parts users may choose for custom aliases
+var prefixRegex = regexp.MustCompile(`^[a-z0-9]([a-z0-9._-]{0,62}[a-z0-9])?$`)
+
+// ValidatePrefix checks a user chosen alias prefix (local part)
+func ValidatePrefix(prefix string) error {
+	if !prefixRegex.MatchString(prefix) {
+		return fmt.Errorf("prefix must be 1-64 lowercase letters, digits, '.', '-' or '_' and start and end with a letter or digit")
+	}
+	if strings.Contains(prefix, "..") {
+		return fmt.Errorf("prefix must not contain consecutive dots")
+	}
+	return nil
+}
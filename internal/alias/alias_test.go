@@ -2,6 +2,7 @@ package alias
 
 import (
 	"fmt"
+	"regexp"
 	"testing"
 )
 
@@ -144,3 +145,34 @@ func TestNameGenerator(t *testing.T) {
 		fmt.Printf("  %.2f: %s\n", ratio, generateNameVariation(baseName, ratio))
 	}
 }
+
+func TestGenerateSubaddress(t *testing.T) {
+	address, err := GenerateSubaddress("john@example.com", "{hostname}", "www.GitHub.com")
+	if err != nil {
+		t.Fatalf("GenerateSubaddress: %v", err)
+	}
+	if address != "john+github.com@example.com" {
+		t.Errorf("unexpected subaddress: %s", address)
+	}
+
+	address, err = GenerateSubaddress("john@example.com", "{hostname}-{word-chars:4}", "")
+	if err != nil {
+		t.Fatalf("GenerateSubaddress: %v", err)
+	}
+	if !regexp.MustCompile(`^john\+[a-z][a-z0-9]{3}@example\.com$`).MatchString(address) {
+		t.Errorf("unexpected subaddress without hostname: %s", address)
+	}
+}
+
+func TestPatternDomain(t *testing.T) {
+	for pattern, expected := range map[string]string{
+		"{firstname}.{lastname}@Relay.example.net": "relay.example.net",
+		"{firstname}.{lastname}@%d":                "",
+		"{word-chars:6}@{firstname}.example.net":   "",
+		"{firstname}.{lastname}":                   "",
+	} {
+		if domain := PatternDomain(pattern); domain != expected {
+			t.Errorf("PatternDomain(%q) = %q, expected %q", pattern, domain, expected)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/alias"
+)
+
+// addyAliasRequest is the body of addy.io's alias creation endpoint
+type addyAliasRequest struct {
+	Domain      string `json:"domain"`
+	Description string `json:"description"`
+	Format      string `json:"format"`
+	LocalPart   string `json:"local_part"`
+}
+
+// addyAlias is the alias representation of the addy.io API
+type addyAlias struct {
+	ID          int       `json:"id"`
+	Email       string    `json:"email"`
+	LocalPart   string    `json:"local_part"`
+	Domain      string    `json:"domain"`
+	Description string    `json:"description"`
+	Active      bool      `json:"active"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// handleAddyNewAlias creates an alias through the addy.io compatible API, as used by Bitwarden's addy.io forwarder
+func (a *API) handleAddyNewAlias(w http.ResponseWriter, r *http.Request) {
+	requestID := fmt.Sprintf("%d", time.Now().UnixNano())
+	log := a.logger.WithRequestID(requestID)
+
+	log.Info("Processing new addy.io alias request")
+
+	username, ok := a.authenticateRequest(w, r, log)
+	if !ok {
+		return
+	}
+
+	mailbox, ok := a.resolveMailbox(w, username, log)
+	if !ok {
+		return
+	}
+
+	decision, ok := a.authorize(w, r, mailbox, log)
+	if !ok {
+		return
+	}
+
+	var request addyAliasRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Warn("Invalid addy.io alias request body: %v", err)
+		http.Error(w, "Bad request: invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	// Without a domain the preferred alias domain of the mailbox is used
+	domain := strings.ToLower(strings.TrimSpace(request.Domain))
+	if domain == "" {
+		domain = decision.AliasDomains[0]
+	}
+
+	var address string
+	if request.Format == "custom" {
+		if !decision.AllowCustomPrefix {
+			log.Warn("Policy denied custom prefix for %s (group %s)", maskUsername(mailbox.Username), decision.Group)
+			a.audit(r, auditPolicyDenied, mailbox.Username, fmt.Sprintf("custom prefix not permitted for group %s", decision.Group))
+			http.Error(w, "Forbidden: custom alias prefixes are not permitted", http.StatusForbidden)
+			return
+		}
+
+		prefix := strings.ToLower(strings.TrimSpace(request.LocalPart))
+		if err := alias.ValidatePrefix(prefix); err != nil {
+			log.Warn("Invalid alias prefix: %v", err)
+			http.Error(w, fmt.Sprintf("Bad request: %v", err), http.StatusBadRequest)
+			return
+		}
+		address = prefix + "@" + domain
+	} else {
+		generatedAlias, err := alias.GenerateAliasWithDomain(domain, a.config.AliasGenerationPattern)
+		if err != nil {
+			errorMsg := fmt.Sprintf("Failed to generate alias: %v", err)
+			log.Error("%s", errorMsg)
+			http.Error(w, errorMsg, http.StatusInternalServerError)
+			return
+		}
+		address = generatedAlias
+	}
+	log.Info("Generated alias: %s", address)
+
+	record, ok := a.createAlias(w, r, log, address[strings.LastIndex(address, "@")+1:], mailbox, decision, a.permanentAlias(address, []string{mailbox.Username}))
+	if !ok {
+		return
+	}
+
+	at := strings.LastIndex(record.Address, "@")
+	response := map[string]addyAlias{
+		"data": {
+			ID:          record.ID,
+			Email:       record.Address,
+			LocalPart:   record.Address[:at],
+			Domain:      record.Address[at+1:],
+			Description: request.Description,
+			Active:      true,
+			CreatedAt:   record.CreatedAt,
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Error("Failed to encode response: %v", err)
+		return
+	}
+
+	log.Info("Successfully completed new addy.io alias request")
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// requireAdmin protects admin endpoints with the configured admin API key,
+// passed as "Authorization: Bearer <key>"
+func (a *API) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(key), []byte(a.config.AdminAPIKey)) != 1 {
+			a.logger.Warn("Rejected admin request from %s", clientIP(r))
+			http.Error(w, "Unauthorized: invalid admin API key", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// handleInvalidateAuth drops cached authentications of a user, e.g. after a password change in Mailcow
+func (a *API) handleInvalidateAuth(w http.ResponseWriter, r *http.Request) {
+	requestID := fmt.Sprintf("%d", time.Now().UnixNano())
+	log := a.logger.WithRequestID(requestID)
+
+	var request struct {
+		Username string `json:"username"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Username == "" {
+		log.Warn("Invalid auth invalidation request")
+		http.Error(w, "Bad request: body must be JSON with a username", http.StatusBadRequest)
+		return
+	}
+
+	removed := 0
+	if b, err := a.backends.ForUser(request.Username); err == nil {
+		removed = b.Auth.InvalidateUser(request.Username)
+	}
+	log.Info("Admin invalidated %d cached authentications for user %s", removed, maskUsername(request.Username))
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]int{"invalidated": removed}); err != nil {
+		log.Error("Failed to encode response: %v", err)
+	}
+}
+
+// handleAliasGC runs the alias garbage collection, only reporting candidates with ?dry_run=true
+func (a *API) handleAliasGC(w http.ResponseWriter, r *http.Request) {
+	requestID := fmt.Sprintf("%d", time.Now().UnixNano())
+	log := a.logger.WithRequestID(requestID)
+
+	if a.collector == nil {
+		http.Error(w, "Not found: alias garbage collection is disabled", http.StatusNotFound)
+		return
+	}
+
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+	report, err := a.collector.Run(dryRun)
+	if err != nil {
+		errorMsg := fmt.Sprintf("Alias garbage collection failed: %v", err)
+		log.Error("%s", errorMsg)
+		http.Error(w, errorMsg, http.StatusInternalServerError)
+		return
+	}
+	log.Info("Admin ran alias garbage collection: %d candidates (dry run: %v)", len(report.Candidates), dryRun)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Error("Failed to encode response: %v", err)
+	}
+}
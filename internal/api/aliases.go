@@ -0,0 +1,258 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/alias"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/backend"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/logger"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/mailcow"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/store"
+)
+
+// activitiesPerPage matches SimpleLogin's page size
+const activitiesPerPage = 20
+
+// simpleLoginDate is the date format of SimpleLogin alias objects
+const simpleLoginDate = "2006-01-02 15:04:05+00:00"
+
+// aliasContact is the other party of an activity
+type aliasContact struct {
+	Email        string `json:"email"`
+	Name         string `json:"name"`
+	ReverseAlias string `json:"reverse_alias"`
+}
+
+// latestActivity is the last activity of an alias object
+type latestActivity struct {
+	Action    string       `json:"action"`
+	Contact   aliasContact `json:"contact"`
+	Timestamp int64        `json:"timestamp"`
+}
+
+// aliasObject follows SimpleLogin's alias object
+type aliasObject struct {
+	ID                int             `json:"id"`
+	Email             string          `json:"email"`
+	CreationDate      string          `json:"creation_date"`
+	CreationTimestamp int64           `json:"creation_timestamp"`
+	Enabled           bool            `json:"enabled"`
+	NbForward         int             `json:"nb_forward"`
+	NbBlock           int             `json:"nb_block"`
+	NbReply           int             `json:"nb_reply"`
+	Note              *string         `json:"note"`
+	LatestActivity    *latestActivity `json:"latest_activity"`
+	Mailbox           *mailboxRef     `json:"mailbox,omitempty"`
+	Mailboxes         []mailboxRef    `json:"mailboxes,omitempty"`
+	// Links are signed URLs to disable, delete or re-enable the alias without logging in
+	Links map[string]string `json:"links,omitempty"`
+}
+
+// newAliasObject converts a store record to a SimpleLogin alias object
+func newAliasObject(record *store.AliasRecord) aliasObject {
+	created := record.CreatedAt.UTC()
+	object := aliasObject{
+		ID:                record.ID,
+		Email:             record.Address,
+		CreationDate:      created.Format(simpleLoginDate),
+		CreationTimestamp: created.Unix(),
+		Enabled:           !record.Disabled,
+		NbForward:         record.NbForward,
+		NbBlock:           record.NbBlock,
+	}
+	if record.Hostname != "" {
+		note := record.Hostname
+		object.Note = &note
+	}
+	if len(record.Activities) > 0 {
+		latest := record.Activities[0]
+		object.LatestActivity = &latestActivity{
+			Action:    latest.Action,
+			Contact:   aliasContact{Email: latest.From},
+			Timestamp: latest.Timestamp.Unix(),
+		}
+	}
+	return object
+}
+
+// aliasObjectFor returns the alias object of a record with its mailboxes and links
+func (a *API) aliasObjectFor(record *store.AliasRecord) aliasObject {
+	object := newAliasObject(record)
+	object.Mailboxes = a.aliasMailboxes(record)
+	object.Mailbox = &object.Mailboxes[0]
+	object.Links = a.burnLinksFor(record)
+	return object
+}
+
+// activityObject follows SimpleLogin's alias activity
+type activityObject struct {
+	Action       string `json:"action"`
+	From         string `json:"from"`
+	To           string `json:"to"`
+	Timestamp    int64  `json:"timestamp"`
+	ReverseAlias string `json:"reverse_alias"`
+}
+
+// ownedAlias authenticates the request and returns the alias named by the id path variable
+// if it belongs to the caller, together with the caller's mailbox. Aliases of others are reported as not found.
+func (a *API) ownedAlias(w http.ResponseWriter, r *http.Request, log *logger.Logger) (*store.AliasRecord, *mailcow.Mailbox, bool) {
+	username, ok := a.authenticateRequest(w, r, log)
+	if !ok {
+		return nil, nil, false
+	}
+
+	mailbox, ok := a.resolveMailbox(w, username, log)
+	if !ok {
+		return nil, nil, false
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Bad request: invalid alias id", http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	record, err := a.store.AliasByID(id)
+	if errors.Is(err, store.ErrNotFound) || (err == nil && !strings.EqualFold(record.Owner, mailbox.Username)) {
+		log.Warn("Alias %d not found for %s", id, mailbox.Username)
+		http.Error(w, "Not found: unknown alias", http.StatusNotFound)
+		return nil, nil, false
+	}
+	if err != nil {
+		log.Error("Failed to look up alias %d: %v", id, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+
+	return record, mailbox, true
+}
+
+// handleGetAlias returns an alias with its activity statistics
+func (a *API) handleGetAlias(w http.ResponseWriter, r *http.Request) {
+	requestID := fmt.Sprintf("%d", time.Now().UnixNano())
+	log := a.logger.WithRequestID(requestID)
+
+	record, _, ok := a.ownedAlias(w, r, log)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(a.aliasObjectFor(record)); err != nil {
+		log.Error("Failed to encode response: %v", err)
+	}
+}
+
+// aliasUpdateRequest is the body of SimpleLogin's alias update endpoint
+type aliasUpdateRequest struct {
+	MailboxID  int   `json:"mailbox_id"`
+	MailboxIDs []int `json:"mailbox_ids"`
+}
+
+// handleUpdateAlias changes the mailboxes an alias forwards to
+func (a *API) handleUpdateAlias(w http.ResponseWriter, r *http.Request) {
+	requestID := fmt.Sprintf("%d", time.Now().UnixNano())
+	log := a.logger.WithRequestID(requestID)
+
+	record, mailbox, ok := a.ownedAlias(w, r, log)
+	if !ok {
+		return
+	}
+
+	var request aliasUpdateRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Warn("Invalid alias update body: %v", err)
+		http.Error(w, "Bad request: invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	ids := request.MailboxIDs
+	if request.MailboxID != 0 {
+		ids = append(ids, request.MailboxID)
+	}
+	if len(ids) == 0 {
+		http.Error(w, "Bad request: mailbox_id or mailbox_ids required", http.StatusBadRequest)
+		return
+	}
+
+	mailboxes, ok := a.destinations(w, log, mailbox, ids)
+	if !ok {
+		return
+	}
+	if record.Type == alias.TypeSubaddress || record.Type == alias.TypeTemporary {
+		if !ownMailboxOnly(w, log, record.Type, mailboxes, mailbox.Username) {
+			return
+		}
+	}
+
+	gotoAddresses := strings.Join(mailboxes, ",")
+	if record.Type != alias.TypeSubaddress && record.Type != alias.TypeTemporary {
+		err := a.backendFor(mailbox.Username).Aliases.UpdateAlias(record.Address, backend.AliasUpdate{Goto: mailboxes})
+		if isUnavailable(err) {
+			a.writeUnavailable(w, log, err)
+			return
+		}
+		if errors.Is(err, backend.ErrInvalidAlias) {
+			log.Warn("Mail server cannot store the alias: %v", err)
+			http.Error(w, fmt.Sprintf("Bad request: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			writeInternalError(w, log, "update alias", err)
+			return
+		}
+	}
+	if err := a.store.UpdateAlias(record.Address, func(stored *store.AliasRecord) { stored.Mailboxes = mailboxes }); err != nil {
+		log.Error("Failed to record mailboxes of alias %s: %v", record.Address, err)
+	}
+	log.Info("Alias %s now forwards to %s", record.Address, gotoAddresses)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]bool{"ok": true}); err != nil {
+		log.Error("Failed to encode response: %v", err)
+	}
+}
+
+// handleAliasActivities returns a page of the recorded activities of an alias, newest first
+func (a *API) handleAliasActivities(w http.ResponseWriter, r *http.Request) {
+	requestID := fmt.Sprintf("%d", time.Now().UnixNano())
+	log := a.logger.WithRequestID(requestID)
+
+	record, _, ok := a.ownedAlias(w, r, log)
+	if !ok {
+		return
+	}
+
+	page := 0
+	if value := r.URL.Query().Get("page_id"); value != "" {
+		var err error
+		page, err = strconv.Atoi(value)
+		if err != nil || page < 0 {
+			http.Error(w, "Bad request: invalid page_id", http.StatusBadRequest)
+			return
+		}
+	}
+
+	activities := []activityObject{}
+	for i := page * activitiesPerPage; i < len(record.Activities) && i < (page+1)*activitiesPerPage; i++ {
+		activity := record.Activities[i]
+		activities = append(activities, activityObject{
+			Action:    activity.Action,
+			From:      activity.From,
+			To:        record.Address,
+			Timestamp: activity.Timestamp.Unix(),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string][]activityObject{"activities": activities}); err != nil {
+		log.Error("Failed to encode response: %v", err)
+	}
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/store"
+)
+
+func TestNewAliasObject(t *testing.T) {
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	blocked := created.Add(time.Hour)
+	record := &store.AliasRecord{
+		ID:        7,
+		Address:   "site@example.com",
+		Hostname:  "github.com",
+		CreatedAt: created,
+		NbForward: 3,
+		NbBlock:   1,
+		Activities: []store.Activity{
+			{Action: store.ActivityBlock, From: "spam@example.net", Timestamp: blocked},
+			{Action: store.ActivityForward, From: "shop@example.org", Timestamp: created},
+		},
+	}
+
+	object := newAliasObject(record)
+	if object.ID != 7 || object.Email != "site@example.com" || !object.Enabled || object.NbForward != 3 || object.NbBlock != 1 {
+		t.Errorf("unexpected alias object: %+v", object)
+	}
+	if object.CreationDate != "2024-03-01 12:00:00+00:00" || object.CreationTimestamp != created.Unix() {
+		t.Errorf("unexpected creation date %q (%d)", object.CreationDate, object.CreationTimestamp)
+	}
+	if object.Note == nil || *object.Note != "github.com" {
+		t.Errorf("expected hostname as note, got %v", object.Note)
+	}
+	if object.LatestActivity == nil || object.LatestActivity.Action != store.ActivityBlock ||
+		object.LatestActivity.Contact.Email != "spam@example.net" || object.LatestActivity.Timestamp != blocked.Unix() {
+		t.Errorf("unexpected latest activity: %+v", object.LatestActivity)
+	}
+
+	record.Disabled = true
+	record.Activities = nil
+	if object := newAliasObject(record); object.Enabled || object.LatestActivity != nil {
+		t.Errorf("unexpected alias object without activity: %+v", object)
+	}
+}
@@ -2,8 +2,12 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -11,36 +15,51 @@ import (
 
 	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/alias"
 	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/auth"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/backend"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/burnlink"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/clientip"
 	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/config"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/gc"
 	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/logger"
 	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/mailcow"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/policy"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/store"
 )
 
 // API is the API handler
 type API struct {
-	config        *config.Config
-	mailcowClient *mailcow.MailcowClient
-	authModule    *auth.AuthModule
-	router        *mux.Router
-	logger        *logger.Logger
+	config     *config.Config
+	backends   *backend.Set
+	policy     *policy.Policy
+	store      *store.Store
+	collector  *gc.Collector
+	burnLinks  *burnlink.Signer // nil if disabled
+	ownerLocks keyedMutex
+	router     *mux.Router
+	logger     *logger.Logger
 }
 
 // NewAPI creates a new API handler
-func NewAPI(cfg *config.Config, mailcowClient *mailcow.MailcowClient, authModule *auth.AuthModule) *API {
+func NewAPI(cfg *config.Config, backends *backend.Set, pol *policy.Policy, st *store.Store) *API {
 	api := &API{
-		config:        cfg,
-		mailcowClient: mailcowClient,
-		authModule:    authModule,
-		router:        mux.NewRouter(),
-		logger:        logger.WithComponent("API"),
+		config:   cfg,
+		backends: backends,
+		policy:   pol,
+		store:    st,
+		router:   mux.NewRouter(),
+		logger:   logger.WithComponent("API"),
+	}
+
+	if cfg.BurnLinkSecret != "" {
+		api.burnLinks = burnlink.NewSigner(cfg.BurnLinkSecret, cfg.PublicURL, time.Duration(cfg.BurnLinkValidity)*24*time.Hour)
 	}
 
 	if cfg.CORSAllowOrigin != "" {
 		api.router.Use(func(next http.Handler) http.Handler {
 			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				w.Header().Set("Access-Control-Allow-Origin", cfg.CORSAllowOrigin)
-				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-				w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authentication")
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PATCH, OPTIONS")
+				w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authentication, Authorization")
 				if r.Method == http.MethodOptions {
 					w.WriteHeader(http.StatusOK)
 					return
@@ -54,6 +73,11 @@ func NewAPI(cfg *config.Config, mailcowClient *mailcow.MailcowClient, authModule
 	return api
 }
 
+// SetCollector enables the admin endpoint of the alias garbage collection
+func (a *API) SetCollector(collector *gc.Collector) {
+	a.collector = collector
+}
+
 // Router returns the router
 func (a *API) Router() http.Handler {
 	return a.router
@@ -63,23 +87,136 @@ func (a *API) routes() {
 	a.router.PathPrefix("/").Methods("OPTIONS").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	})
+	a.router.HandleFunc("/healthz", a.handleHealthz).Methods("GET")
+	a.router.HandleFunc("/readyz", a.handleReadyz).Methods("GET")
+	a.logger.Debug("Registered routes: GET /healthz, GET /readyz")
 	a.router.HandleFunc("/api/alias/random/new", a.handleNewAlias).Methods("POST")
 	a.logger.Debug("Registered route: POST /api/alias/random/new")
+	a.router.HandleFunc("/api/v3/alias/custom/new", a.handleNewCustomAlias).Methods("POST")
+	a.logger.Debug("Registered route: POST /api/v3/alias/custom/new")
+	a.router.HandleFunc("/api/user_info", a.handleUserInfo).Methods("GET")
+	a.logger.Debug("Registered route: GET /api/user_info")
+	a.router.HandleFunc("/api/v5/alias/options", a.handleAliasOptions).Methods("GET")
+	a.logger.Debug("Registered route: GET /api/v5/alias/options")
+	a.router.HandleFunc("/api/v2/mailboxes", a.handleMailboxes).Methods("GET")
+	a.logger.Debug("Registered route: GET /api/v2/mailboxes")
+	a.router.HandleFunc("/api/aliases/{id:[0-9]+}", a.handleGetAlias).Methods("GET")
+	a.logger.Debug("Registered route: GET /api/aliases/{id}")
+	a.router.HandleFunc("/api/aliases/{id:[0-9]+}", a.handleUpdateAlias).Methods("PATCH")
+	a.logger.Debug("Registered route: PATCH /api/aliases/{id}")
+	a.router.HandleFunc("/api/aliases/{id:[0-9]+}/activities", a.handleAliasActivities).Methods("GET")
+	a.logger.Debug("Registered route: GET /api/aliases/{id}/activities")
+	a.router.HandleFunc("/api/v1/aliases", a.handleAddyNewAlias).Methods("POST")
+	a.logger.Debug("Registered route: POST /api/v1/aliases")
+
+	if a.config.AdminAPIKey != "" {
+		a.router.HandleFunc("/admin/auth/invalidate", a.requireAdmin(a.handleInvalidateAuth)).Methods("POST")
+		a.logger.Debug("Registered route: POST /admin/auth/invalidate")
+		a.router.HandleFunc("/admin/aliases/gc", a.requireAdmin(a.handleAliasGC)).Methods("POST")
+		a.logger.Debug("Registered route: POST /admin/aliases/gc")
+		if a.burnLinks != nil {
+			a.router.HandleFunc("/admin/aliases/links", a.requireAdmin(a.handleBurnLinks)).Methods("GET")
+			a.logger.Debug("Registered route: GET /admin/aliases/links")
+		}
+	}
+
+	if a.burnLinks != nil {
+		a.router.HandleFunc("/burn/{action:disable|delete|enable}", a.handleBurnLink).Methods("GET", "POST")
+		a.logger.Debug("Registered routes: GET, POST /burn/{action}")
+	}
+
+	if a.config.OAuthClientID != "" {
+		a.router.HandleFunc("/oauth/login", a.handleOAuthLogin).Methods("GET")
+		a.router.HandleFunc("/oauth/callback", a.handleOAuthCallback).Methods("GET")
+		a.logger.Debug("Registered routes: GET /oauth/login, GET /oauth/callback")
+	}
 }
 
-func (a *API) handleNewAlias(w http.ResponseWriter, r *http.Request) {
-	requestID := fmt.Sprintf("%d", time.Now().UnixNano())
-	log := a.logger.WithRequestID(requestID)
+// backendFor returns the backend serving a mailbox. Only pass mailboxes returned by
+// resolveMailbox, whose backend has been checked.
+func (a *API) backendFor(username string) *backend.Backend {
+	b, err := a.backends.ForUser(username)
+	if err != nil {
+		return nil
+	}
+	return b
+}
 
-	log.Info("Processing new alias request")
+// maskUsername shortens a username for logging
+func maskUsername(username string) string {
+	if len(username) > 3 {
+		return username[:3] + "***"
+	}
+	return username
+}
+
+// clientIP returns the IP address of the client of a request, as resolved behind trusted proxies
+func clientIP(r *http.Request) string {
+	return clientip.FromRequest(r)
+}
+
+// writeInternalError reports a failed action. The error is only logged, errors of the mail
+// server may reveal internal details.
+func writeInternalError(w http.ResponseWriter, log *logger.Logger, action string, err error) {
+	log.Error("Failed to %s: %v", action, err)
+	http.Error(w, "Internal server error: failed to "+action, http.StatusInternalServerError)
+}
+
+// writeAuthError writes the error response matching a failed authentication
+func (a *API) writeAuthError(w http.ResponseWriter, err error, log *logger.Logger) {
+	var lockedErr *auth.LockedError
+	switch {
+	case errors.As(err, &lockedErr):
+		retryAfter := int(math.Ceil(lockedErr.RetryAfter.Seconds()))
+		log.Warn("Authentication rejected: %v", err)
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		http.Error(w, fmt.Sprintf("Too many requests: %v", err), http.StatusTooManyRequests)
+	case errors.Is(err, auth.ErrBusy):
+		log.Warn("Authentication rejected: %v", err)
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, fmt.Sprintf("Service unavailable: %v", err), http.StatusServiceUnavailable)
+	case errors.Is(err, auth.ErrUnavailable):
+		a.writeUnavailable(w, log, err)
+	case errors.Is(err, auth.ErrForbidden):
+		log.Warn("Authentication rejected: %v", err)
+		http.Error(w, fmt.Sprintf("Forbidden: %v", err), http.StatusForbidden)
+	default:
+		errorMsg := fmt.Sprintf("Authentication failed: %v", err)
+		log.Warn("%s", errorMsg)
+		http.Error(w, errorMsg, http.StatusUnauthorized)
+	}
+}
 
-	// Get username and password from the Authentication header
-	// Format: "Authentication: username:password"
+// authenticateRequest verifies the credentials of the Authentication header and returns the
+// authenticated username. On failure the error response has already been written.
+func (a *API) authenticateRequest(w http.ResponseWriter, r *http.Request, log *logger.Logger) (string, bool) {
+	// Get credentials from the Authentication header
+	// Format: "Authentication: username:password", or an access token for OAUTH2.
+	// Clients of the addy.io API send the same as "Authorization: Bearer <api key>".
 	authHeader := r.Header.Get("Authentication")
+	if authHeader == "" && strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		authHeader = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
 	if authHeader == "" {
 		log.Warn("Authentication failed: No Authentication header provided")
 		http.Error(w, "Unauthorized: Authentication header required", http.StatusUnauthorized)
-		return
+		return "", false
+	}
+
+	// With OAuth2 the header may carry a bare access token
+	// Tokens carry no domain, so they are checked by the default backend
+	if defaultBackend := a.backends.Default(); defaultBackend != nil && strings.EqualFold(defaultBackend.Auth.Method(), "OAUTH2") && !strings.Contains(authHeader, ":") {
+		if !a.requireMailServer(w, defaultBackend, log) {
+			return "", false
+		}
+		token := strings.TrimSpace(strings.TrimPrefix(authHeader, "Bearer "))
+		username, err := defaultBackend.Auth.AuthenticateToken(token, clientIP(r))
+		if err != nil {
+			a.writeAuthError(w, err, log)
+			return "", false
+		}
+		log.Info("User %s authenticated successfully with access token", maskUsername(username))
+		return username, true
 	}
 
 	// Split the header value to get username and password
@@ -87,64 +224,419 @@ func (a *API) handleNewAlias(w http.ResponseWriter, r *http.Request) {
 	if len(credentials) != 2 {
 		log.Warn("Authentication failed: Invalid Authentication header format")
 		http.Error(w, "Unauthorized: Authentication header must be in the format 'username:password'", http.StatusUnauthorized)
-		return
+		return "", false
 	}
 
 	username := credentials[0]
 	password := credentials[1]
 
-	// Mask username for logging
-	maskedUser := username
-	if len(maskedUser) > 3 {
-		maskedUser = maskedUser[:3] + "***"
-	}
+	maskedUser := maskUsername(username)
 	log.Info("Authenticating user: %s", maskedUser)
 
-	// Authenticate user against Mailcow
-	if err := a.authModule.Authenticate(username, password); err != nil {
-		errorMsg := fmt.Sprintf("Authentication failed: %v", err)
-		log.Warn("%s", errorMsg)
-		http.Error(w, errorMsg, http.StatusUnauthorized)
-		return
+	// Authenticate user against the Mailcow serving the login domain
+	b, err := a.backends.ForUser(username)
+	if err != nil {
+		log.Warn("Rejecting login %s: %v", maskedUser, err)
+		http.Error(w, "Forbidden: login domain is not served by this bridge", http.StatusForbidden)
+		return "", false
+	}
+	// Without the mail server nothing can be done, so the auth server is not asked either
+	if !a.requireMailServer(w, b, log) {
+		return "", false
+	}
+	if err := b.Auth.Authenticate(username, password, clientIP(r)); err != nil {
+		a.writeAuthError(w, err, log)
+		return "", false
 	}
 	log.Info("User %s authenticated successfully", maskedUser)
 
-	// Generate alias
-	log.Info("Generating alias using pattern: %s", a.config.AliasGenerationPattern)
-	generatedAlias, err := alias.GenerateAlias(username, a.config.AliasGenerationPattern)
+	return username, true
+}
+
+// resolveMailbox maps an authenticated login to an active Mailcow mailbox. Backends without
+// Mailcow have no mailbox directory, their logins are taken as mailboxes of their own.
+// On failure the error response has already been written.
+func (a *API) resolveMailbox(w http.ResponseWriter, username string, log *logger.Logger) (*mailcow.Mailbox, bool) {
+	maskedUser := maskUsername(username)
+
+	// Dovecot master-user logins (user*master) must not act on behalf of the user
+	if strings.Contains(username, "*") {
+		log.Warn("Rejecting master-user login %s", maskedUser)
+		http.Error(w, "Forbidden: master-user logins are not supported", http.StatusForbidden)
+		return nil, false
+	}
+
+	b, err := a.backends.ForUser(username)
+	if err != nil {
+		log.Warn("Rejecting login %s: %v", maskedUser, err)
+		http.Error(w, "Forbidden: login domain is not served by this bridge", http.StatusForbidden)
+		return nil, false
+	}
+
+	if b.Mailcow == nil {
+		if !strings.Contains(username, "@") {
+			log.Warn("Login %s is no email address", maskedUser)
+			http.Error(w, "Forbidden: login does not belong to a mailbox", http.StatusForbidden)
+			return nil, false
+		}
+		username = strings.ToLower(username)
+		return &mailcow.Mailbox{Username: username, Domain: username[strings.LastIndex(username, "@")+1:], Active: true}, true
+	}
+
+	mailbox, err := b.Mailcow.GetMailbox(username)
+	if errors.Is(err, mailcow.ErrNotFound) {
+		log.Warn("Login %s does not belong to a Mailcow mailbox", maskedUser)
+		http.Error(w, "Forbidden: login does not belong to a mailbox", http.StatusForbidden)
+		return nil, false
+	}
+	if isUnavailable(err) {
+		a.writeUnavailable(w, log, err)
+		return nil, false
+	}
 	if err != nil {
-		errorMsg := fmt.Sprintf("Failed to generate alias: %v", err)
-		log.Error("%s", errorMsg)
-		http.Error(w, errorMsg, http.StatusInternalServerError)
+		writeInternalError(w, log, "look up mailbox", err)
+		return nil, false
+	}
+
+	if !mailbox.IsActive() {
+		log.Warn("Mailbox %s is inactive", maskedUser)
+		http.Error(w, "Forbidden: mailbox is inactive", http.StatusForbidden)
+		return nil, false
+	}
+
+	// Later requests for the mailbox are routed by its own domain
+	if mailboxBackend, err := a.backends.ForUser(mailbox.Username); err != nil || mailboxBackend != b {
+		log.Error("Mailbox %s of backend %s is routed to another backend, check its domains", maskUsername(mailbox.Username), b.Name)
+		http.Error(w, "Forbidden: mailbox domain is not served by the backend of the login", http.StatusForbidden)
+		return nil, false
+	}
+
+	if mailbox.Username != username {
+		log.Debug("Resolved login %s to mailbox %s", maskedUser, maskUsername(mailbox.Username))
+	}
+	return mailbox, true
+}
+
+// randomAliasRequest is the optional body of SimpleLogin's random alias endpoint
+type randomAliasRequest struct {
+	Note      string `json:"note"`
+	MailboxID int    `json:"mailbox_id"`
+}
+
+// ownMailboxOnly rejects other destinations for alias types that always deliver to the login mailbox.
+// On failure the error response has already been written.
+func ownMailboxOnly(w http.ResponseWriter, log *logger.Logger, aliasType string, mailboxes []string, username string) bool {
+	if len(mailboxes) == 1 && strings.EqualFold(mailboxes[0], username) {
+		return true
+	}
+	log.Warn("Rejecting other destination mailboxes for %s alias", aliasType)
+	http.Error(w, fmt.Sprintf("Bad request: %s aliases always deliver to the login mailbox", aliasType), http.StatusBadRequest)
+	return false
+}
+
+func (a *API) handleNewAlias(w http.ResponseWriter, r *http.Request) {
+	requestID := fmt.Sprintf("%d", time.Now().UnixNano())
+	log := a.logger.WithRequestID(requestID)
+
+	log.Info("Processing new alias request")
+
+	username, ok := a.authenticateRequest(w, r, log)
+	if !ok {
+		return
+	}
+
+	// Use the canonical mailbox address instead of the login
+	mailbox, ok := a.resolveMailbox(w, username, log)
+	if !ok {
+		return
+	}
+	username = mailbox.Username
+
+	decision, ok := a.authorize(w, r, mailbox, log)
+	if !ok {
 		return
 	}
-	log.Info("Generated alias: %s", generatedAlias)
 
-	// Create alias in Mailcow
-	log.Info("Creating alias in Mailcow: %s -> %s", generatedAlias, maskedUser)
-	if err := a.mailcowClient.CreateAlias(generatedAlias, username); err != nil {
-		errorMsg := fmt.Sprintf("Failed to create alias in Mailcow: %v", err)
-		log.Error("%s", errorMsg)
-		http.Error(w, errorMsg, http.StatusInternalServerError)
+	// The body is optional, SimpleLogin clients send a note
+	var request randomAliasRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && err != io.EOF {
+		log.Warn("Invalid alias request body: %v", err)
+		http.Error(w, "Bad request: invalid JSON body", http.StatusBadRequest)
 		return
 	}
-	log.Info("Alias created successfully in Mailcow")
+	var mailboxIDs []int
+	if request.MailboxID != 0 {
+		mailboxIDs = []int{request.MailboxID}
+	}
+	mailboxes, ok := a.destinations(w, log, mailbox, mailboxIDs)
+	if !ok {
+		return
+	}
+
+	// Return the existing alias for the site unless a new one is forced
+	hostname, ok := hostnameParam(w, r, log)
+	if !ok {
+		return
+	}
+	if a.config.AliasReusePerHostname && hostname != "" && r.URL.Query().Get("force") != "true" {
+		if record := a.findAliasForHostname(username, hostname, log); record != nil {
+			log.Info("Reusing alias %s for %s", record.Address, hostname)
+			a.audit(r, auditAliasReused, username, record.Address)
+			a.writeAliasResponse(w, log, record, http.StatusOK)
+			return
+		}
+	}
+
+	// Create the alias on the preferred alias domain of the mailbox
+	domain := decision.AliasDomains[0]
+
+	var create aliasCreator
+	switch aliasType := a.aliasTypeFor(r, decision); aliasType {
+	case alias.TypePermanent:
+		log.Info("Generating alias on %s using pattern: %s", domain, a.config.AliasGenerationPattern)
+		generatedAlias, err := alias.GenerateAliasWithDomain(domain, a.config.AliasGenerationPattern)
+		if err != nil {
+			errorMsg := fmt.Sprintf("Failed to generate alias: %v", err)
+			log.Error("%s", errorMsg)
+			http.Error(w, errorMsg, http.StatusInternalServerError)
+			return
+		}
+		log.Info("Generated alias: %s", generatedAlias)
+		domain = generatedAlias[strings.LastIndex(generatedAlias, "@")+1:]
+		create = a.permanentAlias(generatedAlias, mailboxes)
+	case alias.TypeSubaddress:
+		if !ownMailboxOnly(w, log, aliasType, mailboxes, username) {
+			return
+		}
+		pattern := a.config.AliasSubaddressPattern
+		log.Info("Generating subaddress using pattern: %s", pattern)
+		address, err := alias.GenerateSubaddress(username, pattern, hostname)
+		if err != nil {
+			errorMsg := fmt.Sprintf("Failed to generate subaddress: %v", err)
+			log.Error("%s", errorMsg)
+			http.Error(w, errorMsg, http.StatusInternalServerError)
+			return
+		}
+		domain = ""
+		create = subaddressAlias(address)
+	case alias.TypeTemporary:
+		if !ownMailboxOnly(w, log, aliasType, mailboxes, username) {
+			return
+		}
+		validity, err := a.temporaryValidity(r)
+		if err != nil {
+			log.Warn("Invalid validity: %v", err)
+			http.Error(w, fmt.Sprintf("Bad request: %v", err), http.StatusBadRequest)
+			return
+		}
+		if a.backendFor(username).Mailcow == nil {
+			log.Warn("Rejecting temporary alias on backend without Mailcow")
+			http.Error(w, "Bad request: temporary aliases are not supported by the mail server", http.StatusBadRequest)
+			return
+		}
+		create = a.temporaryAlias(username, domain, hostname, validity)
+	default:
+		log.Warn("Unknown alias type: %s", aliasType)
+		http.Error(w, fmt.Sprintf("Bad request: unknown alias_type %q", aliasType), http.StatusBadRequest)
+		return
+	}
+
+	if record, ok := a.createAlias(w, r, log, domain, mailbox, decision, create); ok {
+		a.writeAliasResponse(w, log, record, http.StatusOK)
+	}
+}
+
+// ownAliasDomains returns the alias domains mapped to a mailbox domain by ALIAS_DOMAIN_MAP,
+// or nil if the mailbox domain itself is used
+func (a *API) ownAliasDomains(mailboxDomain string) []string {
+	mapped, ok := a.config.AliasDomainMap[strings.ToLower(mailboxDomain)]
+	if !ok {
+		mapped, ok = a.config.AliasDomainMap["*"]
+	}
+	if !ok {
+		return nil
+	}
+
+	domains := make([]string, 0, len(mapped))
+	for _, domain := range mapped {
+		if domain == alias.DomainPlaceholder {
+			domain = strings.ToLower(mailboxDomain)
+		}
+		domains = append(domains, domain)
+	}
+	return domains
+}
+
+// authorize evaluates the policy for a mailbox. On denial the error response has already been written.
+func (a *API) authorize(w http.ResponseWriter, r *http.Request, mailbox *mailcow.Mailbox, log *logger.Logger) (policy.Decision, bool) {
+	decision := a.policy.Evaluate(policy.Subject{
+		Mailbox:    mailbox.Username,
+		Domain:     mailbox.Domain,
+		Tags:       mailbox.Tags,
+		OwnDomains: a.ownAliasDomains(mailbox.Domain),
+	})
+
+	if !decision.Allowed {
+		log.Warn("Policy denied alias creation for %s (%s)", maskUsername(mailbox.Username), decision.Reason)
+		a.audit(r, auditPolicyDenied, mailbox.Username, decision.Reason)
+		http.Error(w, "Forbidden: alias creation is not permitted for this mailbox", http.StatusForbidden)
+		return decision, false
+	}
+
+	log.Debug("Policy allowed alias creation for %s (%s, group %s)", maskUsername(mailbox.Username), decision.Reason, decision.Group)
+	return decision, true
+}
+
+// aliasCreator creates an alias with the given options on the backend of the owner.
+// It returns the record to store, with at least the address set.
+type aliasCreator func(b *backend.Backend, opts backend.AliasOptions) (store.AliasRecord, error)
+
+// permanentAlias returns the creator of a regular alias forwarding to the given mailboxes
+func (a *API) permanentAlias(address string, mailboxes []string) aliasCreator {
+	return func(b *backend.Backend, opts backend.AliasOptions) (store.AliasRecord, error) {
+		record := store.AliasRecord{Address: address, Type: alias.TypePermanent, Mailboxes: mailboxes}
+		return record, b.Aliases.CreateAlias(address, mailboxes, opts)
+	}
+}
+
+// subaddressAlias returns the creator of a subaddress, which the mail server delivers without an alias
+func subaddressAlias(address string) aliasCreator {
+	return func(*backend.Backend, backend.AliasOptions) (store.AliasRecord, error) {
+		return store.AliasRecord{Address: address, Type: alias.TypeSubaddress}, nil
+	}
+}
+
+// createAlias checks the alias domain against the policy decision and the limits of the mailbox
+// and creates the alias. Subaddresses pass an empty domain, they live on the mailbox's own address
+// and are not limited. On failure the error response has already been written.
+func (a *API) createAlias(w http.ResponseWriter, r *http.Request, log *logger.Logger, domain string, mailbox *mailcow.Mailbox, decision policy.Decision, create aliasCreator) (*store.AliasRecord, bool) {
+	username := mailbox.Username
+	maskedUser := maskUsername(username)
+
+	hostname, ok := hostnameParam(w, r, log)
+	if !ok {
+		return nil, false
+	}
+	if domain != "" && !a.checkAliasDomain(w, r, log, domain, username, decision) {
+		return nil, false
+	}
+
+	// Serialize creations per mailbox so concurrent requests cannot overshoot the limits
+	unlock := a.ownerLocks.lock(strings.ToLower(username))
+	defer unlock()
+
+	if domain != "" {
+		if exceeded := a.checkQuota(username, a.limitsFor(decision), time.Now()); exceeded != nil {
+			log.Warn("Alias limit reached for %s: %s", maskedUser, exceeded.Detail)
+			a.audit(r, auditQuotaExceeded, username, exceeded.Detail)
+			if exceeded.RetryAfter > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(exceeded.RetryAfter.Seconds()))))
+			}
+			http.Error(w, fmt.Sprintf("Too many requests: %s", exceeded.Detail), http.StatusTooManyRequests)
+			return nil, false
+		}
+	}
 
-	// Set expiration date
-	expirationDate := time.Now().AddDate(a.config.AliasValidityPeriod, 0, 0).Format(time.RFC3339)
+	// Create alias on the mail server
+	opts := backend.AliasOptions{SenderAllowed: a.config.AliasSenderAllowed}
+	if decision.SenderAllowed != nil {
+		opts.SenderAllowed = decision.SenderAllowed
+	}
+	if hostname != "" {
+		opts.Comment = hostnameComment(hostname)
+	}
+	log.Info("Creating alias for %s", maskedUser)
+	record, err := create(a.backendFor(username), opts)
+	if isUnavailable(err) {
+		a.writeUnavailable(w, log, err)
+		return nil, false
+	}
+	if errors.Is(err, backend.ErrInvalidAlias) {
+		log.Warn("Mail server cannot store the alias: %v", err)
+		http.Error(w, fmt.Sprintf("Bad request: %v", err), http.StatusBadRequest)
+		return nil, false
+	}
+	if err != nil {
+		writeInternalError(w, log, "create alias", err)
+		return nil, false
+	}
+	log.Info("Alias %s created successfully", record.Address)
+	a.audit(r, auditAliasCreated, username, record.Address)
+
+	// The alias exists at this point, so a failing store only costs accuracy of the limits
+	record.Owner = username
+	record.Hostname = strings.ToLower(hostname)
+	stored, err := a.store.AddAlias(record)
+	if err != nil {
+		log.Error("Failed to record alias %s: %v", record.Address, err)
+		record.CreatedAt = time.Now().UTC()
+		stored = &record
+	}
+	return stored, true
+}
+
+// checkAliasDomain checks that aliases may be created on a domain.
+// On failure the error response has already been written.
+func (a *API) checkAliasDomain(w http.ResponseWriter, r *http.Request, log *logger.Logger, domain, username string, decision policy.Decision) bool {
+	if !decision.AllowsDomain(domain) {
+		detail := fmt.Sprintf("alias domain %s not permitted for group %s", domain, decision.Group)
+		log.Warn("Policy denied alias on %s: %s", domain, detail)
+		a.audit(r, auditPolicyDenied, username, detail)
+		http.Error(w, fmt.Sprintf("Forbidden: aliases on domain %s are not permitted", domain), http.StatusForbidden)
+		return false
+	}
+
+	// Catch unknown domains here, the mail server's own error for them is hard to understand
+	checker, ok := a.backendFor(username).Aliases.(backend.DomainChecker)
+	if !ok {
+		return true
+	}
+	if err := checker.CheckDomain(domain); err != nil {
+		if errors.Is(err, backend.ErrUnknownDomain) || errors.Is(err, backend.ErrInactiveDomain) {
+			log.Warn("Rejecting alias on %s: %v", domain, err)
+			http.Error(w, fmt.Sprintf("Bad request: %v", err), http.StatusBadRequest)
+			return false
+		}
+		if isUnavailable(err) {
+			a.writeUnavailable(w, log, err)
+			return false
+		}
+		writeInternalError(w, log, "check alias domain", err)
+		return false
+	}
+
+	return true
+}
+
+// newAliasResponse is the SimpleLogin response for a created alias
+type newAliasResponse struct {
+	aliasObject
+	Alias          string `json:"alias"`
+	ExpirationDate string `json:"expiration_date"`
+}
+
+// writeAliasResponse writes the SimpleLogin response for a created alias
+func (a *API) writeAliasResponse(w http.ResponseWriter, log *logger.Logger, record *store.AliasRecord, status int) {
+	// Set expiration date, aliases expiring on their own report the real one
+	expiration := time.Now().AddDate(a.config.AliasValidityPeriod, 0, 0)
+	if record.ExpiresAt != nil {
+		expiration = *record.ExpiresAt
+	}
+	expirationDate := expiration.Format(time.RFC3339)
 	log.Debug("Setting expiration date: %s", expirationDate)
 
-	// Prepare response
-	response := map[string]string{
-		"alias":           generatedAlias,
-		"expiration_date": expirationDate,
+	// Prepare response, the alias object lets clients show its statistics right away
+	response := newAliasResponse{
+		aliasObject:    a.aliasObjectFor(record),
+		Alias:          record.Address,
+		ExpirationDate: expirationDate,
 	}
 
 	// Return response as JSON
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
 	if err := json.NewEncoder(w).Encode(response); err != nil {
 		log.Error("Failed to encode response: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
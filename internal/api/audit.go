@@ -0,0 +1,35 @@
+package api
+
+import (
+	"net/http"
+
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/burnlink"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/logger"
+)
+
+// Audit events
+const (
+	auditAliasCreated  = "alias_created"
+	auditAliasReused   = "alias_reused"
+	auditAliasDisabled = "alias_disabled"
+	auditAliasEnabled  = "alias_enabled"
+	auditAliasDeleted  = "alias_deleted"
+	auditPolicyDenied  = "policy_denied"
+	auditQuotaExceeded = "quota_exceeded"
+)
+
+// burnAuditEvents are the audit events of the alias link actions
+var burnAuditEvents = map[string]string{
+	burnlink.ActionDisable: auditAliasDisabled,
+	burnlink.ActionEnable:  auditAliasEnabled,
+	burnlink.ActionDelete:  auditAliasDeleted,
+}
+
+// auditLogger records security relevant decisions
+var auditLogger = logger.WithComponent("Audit")
+
+// audit records an event for a user in the audit log
+func (a *API) audit(r *http.Request, event, username, detail string) {
+	auditLogger.Info("event=%s user=%s client=%s path=%s detail=%q",
+		event, username, clientIP(r), r.URL.Path, detail)
+}
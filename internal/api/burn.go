@@ -0,0 +1,194 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/alias"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/backend"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/burnlink"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/logger"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/store"
+)
+
+// burnPage is shown for burn links. Links are only carried out by the form's POST, so mail
+// scanners and link previews fetching the URL do not burn the alias.
+var burnPage = template.Must(template.New("burn").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1"><title>{{.Title}}</title></head>
+<body style="font-family: sans-serif; max-width: 32em; margin: 4em auto; text-align: center">
+<h1>{{.Title}}</h1>
+<p>{{.Message}}</p>
+{{if .Confirm}}<form method="post" action="{{.Action}}"><button type="submit">{{.Confirm}}</button></form>{{end}}
+</body>
+</html>
+`))
+
+// burnPageData fills the burn page
+type burnPageData struct {
+	Title   string
+	Message string
+	Confirm string // label of the confirmation button, no form if empty
+	Action  string
+}
+
+// burnConfirmations are the confirmation labels of the link actions
+var burnConfirmations = map[string]string{
+	burnlink.ActionDisable: "Disable alias",
+	burnlink.ActionDelete:  "Delete alias permanently",
+	burnlink.ActionEnable:  "Enable alias",
+}
+
+// burnResults describe the carried out link actions
+var burnResults = map[string]string{
+	burnlink.ActionDisable: "disabled",
+	burnlink.ActionDelete:  "deleted",
+	burnlink.ActionEnable:  "enabled",
+}
+
+// writeBurnPage renders the burn page
+func writeBurnPage(w http.ResponseWriter, log *logger.Logger, status int, data burnPageData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.WriteHeader(status)
+	if err := burnPage.Execute(w, data); err != nil {
+		log.Error("Failed to render burn page: %v", err)
+	}
+}
+
+// writeBurnUnavailable renders the burn page for an unreachable mail server
+func (a *API) writeBurnUnavailable(w http.ResponseWriter, log *logger.Logger) {
+	w.Header().Set("Retry-After", a.retryAfter())
+	writeBurnPage(w, log, http.StatusServiceUnavailable, burnPageData{Title: "Unavailable", Message: "The mail server is unavailable, please try again later."})
+}
+
+// burnLinksFor returns the signed action links of an alias, or nil if links are disabled
+// or the alias cannot be changed through Mailcow's alias operations
+func (a *API) burnLinksFor(record *store.AliasRecord) map[string]string {
+	if a.burnLinks == nil || record.ID == 0 || record.Type == alias.TypeSubaddress || record.Type == alias.TypeTemporary {
+		return nil
+	}
+	return a.burnLinks.Links(record.Address, time.Now())
+}
+
+// handleBurnLink verifies a signed alias action link. GET asks for confirmation, POST carries it out.
+func (a *API) handleBurnLink(w http.ResponseWriter, r *http.Request) {
+	requestID := fmt.Sprintf("%d", time.Now().UnixNano())
+	log := a.logger.WithRequestID(requestID)
+
+	action := mux.Vars(r)["action"]
+	address, err := a.burnLinks.Verify(action, r.URL.Query(), time.Now())
+	if errors.Is(err, burnlink.ErrExpired) {
+		log.Warn("Rejected expired %s link from %s", action, clientIP(r))
+		writeBurnPage(w, log, http.StatusGone, burnPageData{Title: "Link expired", Message: "This link has expired."})
+		return
+	}
+	if err != nil {
+		log.Warn("Rejected %s link with invalid signature from %s", action, clientIP(r))
+		writeBurnPage(w, log, http.StatusForbidden, burnPageData{Title: "Invalid link", Message: "This link is invalid."})
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		writeBurnPage(w, log, http.StatusOK, burnPageData{
+			Title:   "Confirm",
+			Message: fmt.Sprintf("%s %s?", burnConfirmations[action], address),
+			Confirm: burnConfirmations[action],
+			Action:  r.URL.RequestURI(),
+		})
+		return
+	}
+
+	// Aliases are routed by their owner, or by their own domain if the store does not know them
+	owner := "-"
+	routeBy := address
+	if record, err := a.store.AliasByAddress(address); err == nil {
+		owner = record.Owner
+		routeBy = record.Owner
+	}
+	b, err := a.backends.ForUser(routeBy)
+	if err != nil {
+		log.Warn("No backend for alias %s of %s link: %v", address, action, err)
+		writeBurnPage(w, log, http.StatusNotFound, burnPageData{Title: "Alias not found", Message: fmt.Sprintf("%s is not served by this bridge.", address)})
+		return
+	}
+
+	if !b.MailServerAvailable() {
+		log.Warn("Mail server of backend %s is unavailable for %s link of %s", b.Name, action, address)
+		a.writeBurnUnavailable(w, log)
+		return
+	}
+
+	switch action {
+	case burnlink.ActionDisable, burnlink.ActionEnable:
+		active := action == burnlink.ActionEnable
+		err = b.Aliases.UpdateAlias(address, backend.AliasUpdate{Active: &active})
+		if err == nil {
+			err = a.store.UpdateAlias(address, func(record *store.AliasRecord) { record.Disabled = !active })
+		}
+	case burnlink.ActionDelete:
+		err = b.Aliases.DeleteAlias(address)
+		if err == nil {
+			err = a.store.DeleteAlias(address)
+		}
+	}
+	// Aliases created before the store existed have no record
+	if errors.Is(err, store.ErrNotFound) {
+		err = nil
+	}
+
+	if errors.Is(err, backend.ErrNotFound) {
+		log.Warn("Alias %s of %s link no longer exists", address, action)
+		writeBurnPage(w, log, http.StatusNotFound, burnPageData{Title: "Alias not found", Message: fmt.Sprintf("%s no longer exists.", address)})
+		return
+	}
+	if errors.Is(err, backend.ErrUnsupported) {
+		log.Warn("Cannot %s alias %s: %v", action, address, err)
+		writeBurnPage(w, log, http.StatusUnprocessableEntity, burnPageData{Title: "Not supported", Message: fmt.Sprintf("%s cannot be %s on its mail server, use the delete link instead.", address, burnResults[action])})
+		return
+	}
+	if isUnavailable(err) {
+		log.Warn("Cannot %s alias %s: %v", action, address, err)
+		a.writeBurnUnavailable(w, log)
+		return
+	}
+	if err != nil {
+		log.Error("Failed to %s alias %s: %v", action, address, err)
+		writeBurnPage(w, log, http.StatusInternalServerError, burnPageData{Title: "Failed", Message: "The alias could not be changed, please try again later."})
+		return
+	}
+
+	a.audit(r, burnAuditEvents[action], owner, address)
+	log.Info("Carried out %s link for alias %s", action, address)
+	writeBurnPage(w, log, http.StatusOK, burnPageData{Title: "Done", Message: fmt.Sprintf("%s has been %s.", address, burnResults[action])})
+}
+
+// handleBurnLinks issues the signed action links of an alias, e.g. for notifications or webhooks
+func (a *API) handleBurnLinks(w http.ResponseWriter, r *http.Request) {
+	requestID := fmt.Sprintf("%d", time.Now().UnixNano())
+	log := a.logger.WithRequestID(requestID)
+
+	record, err := a.store.AliasByAddress(r.URL.Query().Get("alias"))
+	if err != nil {
+		http.Error(w, "Not found: unknown alias", http.StatusNotFound)
+		return
+	}
+	links := a.burnLinksFor(record)
+	if links == nil {
+		http.Error(w, "Bad request: the alias type does not support links", http.StatusBadRequest)
+		return
+	}
+	log.Info("Admin issued links for alias %s", record.Address)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(links); err != nil {
+		log.Error("Failed to encode response: %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/alias"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/backend"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/burnlink"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/config"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/mailcow"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/policy"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/store"
+)
+
+func TestBurnLink(t *testing.T) {
+	var mu sync.Mutex
+	var edits []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/get/alias/all":
+			w.Write([]byte(`[{"id": 4, "address": "site@example.com", "goto": "alice@example.com", "active": 1}]`))
+		case "/api/v1/edit/alias":
+			var body struct {
+				Attr map[string]string `json:"attr"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			mu.Lock()
+			edits = append(edits, body.Attr["active"])
+			mu.Unlock()
+			w.Write([]byte(`[{"type": "success"}]`))
+		default:
+			w.Write([]byte(`[]`))
+		}
+	}))
+	defer server.Close()
+
+	client, err := mailcow.NewMailcowClient(server.URL, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := store.Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := st.AddAlias(store.AliasRecord{Address: "site@example.com", Owner: "alice@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{BurnLinkSecret: "secret", PublicURL: "https://bridge.example.com", BurnLinkValidity: 1}
+	a := NewAPI(cfg, backend.Single(client, nil), policy.Default(), st)
+
+	links := a.burnLinksFor(record)
+	link, err := url.Parse(links[burnlink.ActionDisable])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Opening the link only asks for confirmation
+	rec := httptest.NewRecorder()
+	a.Router().ServeHTTP(rec, httptest.NewRequest("GET", link.RequestURI(), nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "<form method=\"post\"") || len(edits) != 0 {
+		t.Fatalf("unexpected confirmation: %d %s, edits %v", rec.Code, rec.Body.String(), edits)
+	}
+
+	rec = httptest.NewRecorder()
+	a.Router().ServeHTTP(rec, httptest.NewRequest("POST", link.RequestURI(), nil))
+	if rec.Code != http.StatusOK || len(edits) != 1 || edits[0] != "0" {
+		t.Fatalf("alias not disabled: %d %s, edits %v", rec.Code, rec.Body.String(), edits)
+	}
+	if stored, _ := st.AliasByID(record.ID); !stored.Disabled {
+		t.Error("store record not marked disabled")
+	}
+
+	// A link signed for one action cannot be used for another
+	forged := strings.Replace(link.RequestURI(), "/burn/disable", "/burn/delete", 1)
+	rec = httptest.NewRecorder()
+	a.Router().ServeHTTP(rec, httptest.NewRequest("POST", forged, nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for forged link, got %d", rec.Code)
+	}
+
+	// Subaddresses cannot be changed in Mailcow
+	if links := a.burnLinksFor(&store.AliasRecord{ID: 2, Address: "alice+x@example.com", Type: alias.TypeSubaddress}); links != nil {
+		t.Errorf("unexpected links for subaddress: %v", links)
+	}
+}
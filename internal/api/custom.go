@@ -0,0 +1,144 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/alias"
+)
+
+// customAliasRequest is the body of SimpleLogin's custom alias endpoint
+type customAliasRequest struct {
+	AliasPrefix  string `json:"alias_prefix"`
+	SignedSuffix string `json:"signed_suffix"`
+	Note         string `json:"note"`
+	MailboxIDs   []int  `json:"mailbox_ids"`
+}
+
+// handleNewCustomAlias creates an alias with a user chosen prefix
+func (a *API) handleNewCustomAlias(w http.ResponseWriter, r *http.Request) {
+	requestID := fmt.Sprintf("%d", time.Now().UnixNano())
+	log := a.logger.WithRequestID(requestID)
+
+	log.Info("Processing new custom alias request")
+
+	username, ok := a.authenticateRequest(w, r, log)
+	if !ok {
+		return
+	}
+
+	mailbox, ok := a.resolveMailbox(w, username, log)
+	if !ok {
+		return
+	}
+
+	decision, ok := a.authorize(w, r, mailbox, log)
+	if !ok {
+		return
+	}
+
+	if !decision.AllowCustomPrefix {
+		log.Warn("Policy denied custom prefix for %s (group %s)", maskUsername(mailbox.Username), decision.Group)
+		a.audit(r, auditPolicyDenied, mailbox.Username, fmt.Sprintf("custom prefix not permitted for group %s", decision.Group))
+		http.Error(w, "Forbidden: custom alias prefixes are not permitted", http.StatusForbidden)
+		return
+	}
+
+	var request customAliasRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Warn("Invalid custom alias request body: %v", err)
+		http.Error(w, "Bad request: invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	prefix := strings.ToLower(strings.TrimSpace(request.AliasPrefix))
+	if err := alias.ValidatePrefix(prefix); err != nil {
+		log.Warn("Invalid alias prefix: %v", err)
+		http.Error(w, fmt.Sprintf("Bad request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	// The suffix is the part after the prefix, e.g. "@example.com"
+	suffix := strings.ToLower(strings.TrimSpace(request.SignedSuffix))
+	if !strings.HasPrefix(suffix, "@") || strings.Count(suffix, "@") != 1 || len(suffix) < 2 {
+		log.Warn("Invalid alias suffix: %s", suffix)
+		http.Error(w, "Bad request: signed_suffix must be of the form @domain", http.StatusBadRequest)
+		return
+	}
+
+	mailboxes, ok := a.destinations(w, log, mailbox, request.MailboxIDs)
+	if !ok {
+		return
+	}
+
+	address := prefix + suffix
+	if record, ok := a.createAlias(w, r, log, suffix[1:], mailbox, decision, a.permanentAlias(address, mailboxes)); ok {
+		a.writeAliasResponse(w, log, record, http.StatusCreated)
+	}
+}
+
+// aliasSuffix is a domain offered for custom aliases, signed suffixes are plain "@domain" here
+type aliasSuffix struct {
+	Suffix       string `json:"suffix"`
+	SignedSuffix string `json:"signed_suffix"`
+	IsCustom     bool   `json:"is_custom"`
+	IsPremium    bool   `json:"is_premium"`
+}
+
+// aliasOptionsResponse follows SimpleLogin's GET /api/v5/alias/options
+type aliasOptionsResponse struct {
+	CanCreate        bool          `json:"can_create"`
+	PrefixSuggestion string        `json:"prefix_suggestion"`
+	Suffixes         []aliasSuffix `json:"suffixes"`
+}
+
+// handleAliasOptions lists the alias domains a user may choose for custom aliases
+func (a *API) handleAliasOptions(w http.ResponseWriter, r *http.Request) {
+	requestID := fmt.Sprintf("%d", time.Now().UnixNano())
+	log := a.logger.WithRequestID(requestID)
+
+	username, ok := a.authenticateRequest(w, r, log)
+	if !ok {
+		return
+	}
+
+	mailbox, ok := a.resolveMailbox(w, username, log)
+	if !ok {
+		return
+	}
+
+	decision, ok := a.authorize(w, r, mailbox, log)
+	if !ok {
+		return
+	}
+
+	response := aliasOptionsResponse{
+		CanCreate:        decision.AllowCustomPrefix,
+		PrefixSuggestion: prefixSuggestion(r.URL.Query().Get("hostname")),
+		Suffixes:         []aliasSuffix{},
+	}
+	for _, domain := range decision.AliasDomains {
+		response.Suffixes = append(response.Suffixes, aliasSuffix{
+			Suffix:       "@" + domain,
+			SignedSuffix: "@" + domain,
+			IsCustom:     true,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Error("Failed to encode response: %v", err)
+	}
+}
+
+// prefixSuggestion derives a prefix from a hostname, e.g. "github" from "www.github.com"
+func prefixSuggestion(hostname string) string {
+	labels := strings.Split(strings.TrimPrefix(strings.ToLower(hostname), "www."), ".")
+	if alias.ValidatePrefix(labels[0]) != nil {
+		return ""
+	}
+	return labels[0]
+}
@@ -0,0 +1,328 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/activity"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/alias"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/auth"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/backend"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/config"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/devmode"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/mailcow"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/mailcow/mailcowtest"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/policy"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/store"
+)
+
+// aliceLogin is the Authentication header of the test mailbox
+const aliceLogin = "alice@example.com:alice"
+
+// testBridge is the API running against a fake Mailcow and an in-process IMAP server
+type testBridge struct {
+	mailcow  *mailcowtest.Server
+	backends *backend.Set
+	store    *store.Store
+	server   *httptest.Server
+}
+
+// newTestBridge starts the API with the mailbox alice@example.com, which may choose custom prefixes,
+// a team mailbox delegated to it and the mailbox bob@example.com with the default policy
+func newTestBridge(t *testing.T) *testBridge {
+	t.Helper()
+
+	cfg := &config.Config{AliasValidityPeriod: 1, AliasGenerationPattern: "{firstname}.{lastname}@%d", AliasType: alias.TypePermanent}
+	pol := &policy.Policy{
+		Default: policy.ActionAllow,
+		Groups: []policy.Group{
+			{Name: "custom", Match: policy.Match{Tags: []string{"custom"}}, AliasDomains: []string{policy.OwnDomain}, AllowCustomPrefix: true},
+		},
+	}
+	return newTestBridgeWith(t, cfg, pol)
+}
+
+// newTestBridgeWith starts the API with the mailboxes of newTestBridge, but the given configuration and policy
+func newTestBridgeWith(t *testing.T, cfg *config.Config, pol *policy.Policy) *testBridge {
+	t.Helper()
+
+	fake := mailcowtest.NewServer("key")
+	t.Cleanup(fake.Close)
+	fake.AddMailbox(mailcowtest.Mailbox{Username: "alice@example.com", Name: "Alice", Active: true, Tags: []string{"custom"}})
+	fake.AddMailbox(mailcowtest.Mailbox{Username: "bob@example.com", Name: "Bob", Active: true})
+	fake.AddMailbox(mailcowtest.Mailbox{Username: "team@example.com", Active: true, Tags: []string{mailcow.DelegateTagPrefix + "alice@example.com"}})
+
+	imapServer, err := devmode.StartIMAPServer(map[string]string{"alice@example.com": "alice", "bob@example.com": "bob", "ghost@example.com": "ghost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { imapServer.Close() })
+	authModule, err := auth.NewAuthModule("IMAP", imapServer.Addr(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authModule.SetTLSConfig(imapServer.ClientTLSConfig())
+
+	client, err := mailcow.NewMailcowClient(fake.URL, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := store.Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backends := backend.Single(client, authModule)
+	server := httptest.NewServer(NewAPI(cfg, backends, pol, st).Router())
+	t.Cleanup(server.Close)
+
+	return &testBridge{mailcow: fake, backends: backends, store: st, server: server}
+}
+
+// do sends a request with the given login and JSON body and returns the status and decoded response
+func (b *testBridge) do(t *testing.T, method, path, login string, body interface{}) (int, map[string]interface{}) {
+	t.Helper()
+
+	status, data := b.doRaw(t, method, path, login, body)
+	var decoded map[string]interface{}
+	json.Unmarshal(data, &decoded)
+	return status, decoded
+}
+
+// doRaw sends a request like do and returns the status and the undecoded response
+func (b *testBridge) doRaw(t *testing.T, method, path, login string, body interface{}) (int, []byte) {
+	t.Helper()
+
+	var requestBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&requestBody).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req, err := http.NewRequest(method, b.server.URL+path, &requestBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if login != "" {
+		req.Header.Set("Authentication", login)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, data
+}
+
+func TestEndToEndRandomAlias(t *testing.T) {
+	b := newTestBridge(t)
+
+	status, response := b.do(t, "POST", "/api/alias/random/new?hostname=shop.example", aliceLogin, map[string]string{"note": "shopping"})
+	if status != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %v", status, response)
+	}
+	address, _ := response["alias"].(string)
+	created, ok := b.mailcow.Alias(address)
+	if !ok || created.Goto != "alice@example.com" || !created.Active || !strings.HasSuffix(address, "@example.com") {
+		t.Fatalf("alias %q not created in Mailcow as expected: %+v", address, created)
+	}
+	if record, err := b.store.AliasByAddress(address); err != nil || record.Owner != "alice@example.com" || record.Hostname != "shop.example" {
+		t.Errorf("unexpected store record: %+v, %v", record, err)
+	}
+
+	// Hostnames end up in mail server comments, anything but a DNS name is refused
+	before := len(b.mailcow.Aliases())
+	if status, _ := b.do(t, "POST", "/api/alias/random/new?hostname=x%0Aceo@example.com+attacker@evil.com", aliceLogin, nil); status != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid hostname, got %d", status)
+	}
+	if aliases := b.mailcow.Aliases(); len(aliases) != before {
+		t.Errorf("alias created for an invalid hostname: %+v", aliases)
+	}
+
+	if status, _ := b.do(t, "POST", "/api/alias/random/new", "alice@example.com:wrong", nil); status != http.StatusUnauthorized {
+		t.Errorf("expected 401 for a wrong password, got %d", status)
+	}
+	// Logins without a Mailcow mailbox may not create aliases
+	if status, _ := b.do(t, "POST", "/api/alias/random/new", "ghost@example.com:ghost", nil); status != http.StatusForbidden {
+		t.Errorf("expected 403 for a login without mailbox, got %d", status)
+	}
+}
+
+func TestEndToEndFixedDomainPattern(t *testing.T) {
+	// Without a policy file the domain of the pattern is permitted next to the own domain
+	pattern := "{firstname}.{lastname}@relay.example.net"
+	cfg := &config.Config{AliasValidityPeriod: 1, AliasGenerationPattern: pattern, AliasType: alias.TypePermanent}
+	b := newTestBridgeWith(t, cfg, policy.Default(alias.PatternDomain(pattern)))
+	b.mailcow.AddDomain("relay.example.net", true)
+
+	status, response := b.do(t, "POST", "/api/alias/random/new", "bob@example.com:bob", nil)
+	if status != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %v", status, response)
+	}
+	address, _ := response["alias"].(string)
+	if created, ok := b.mailcow.Alias(address); !ok || created.Goto != "bob@example.com" || !strings.HasSuffix(address, "@relay.example.net") {
+		t.Errorf("alias %q not created on the pattern's domain: %+v", address, created)
+	}
+}
+
+func TestEndToEndCustomAlias(t *testing.T) {
+	b := newTestBridge(t)
+
+	request := map[string]string{"alias_prefix": "Newsletter", "signed_suffix": "@example.com"}
+	status, response := b.do(t, "POST", "/api/v3/alias/custom/new", aliceLogin, request)
+	if status != http.StatusCreated || response["alias"] != "newsletter@example.com" {
+		t.Fatalf("expected newsletter@example.com to be created, got %d: %v", status, response)
+	}
+	if _, ok := b.mailcow.Alias("newsletter@example.com"); !ok {
+		t.Fatal("alias not created in Mailcow")
+	}
+
+	// Mailcow rejects the existing alias
+	if status, _ := b.do(t, "POST", "/api/v3/alias/custom/new", aliceLogin, request); status < 400 {
+		t.Errorf("expected a duplicate alias to fail, got %d", status)
+	}
+	// Domains unknown to Mailcow are rejected before creating the alias
+	request["signed_suffix"] = "@other.org"
+	if status, _ := b.do(t, "POST", "/api/v3/alias/custom/new", aliceLogin, request); status != http.StatusForbidden && status != http.StatusBadRequest {
+		t.Errorf("expected an unknown domain to be rejected, got %d", status)
+	}
+	// Custom prefixes have to be granted by a policy group
+	request["signed_suffix"] = "@example.com"
+	request["alias_prefix"] = "bobs-newsletter"
+	if status, _ := b.do(t, "POST", "/api/v3/alias/custom/new", "bob@example.com:bob", request); status != http.StatusForbidden {
+		t.Errorf("expected 403 for a mailbox without custom prefixes, got %d", status)
+	}
+	if aliases := b.mailcow.Aliases(); len(aliases) != 1 {
+		t.Errorf("expected one alias in Mailcow, got %+v", aliases)
+	}
+}
+
+func TestEndToEndMailcowFaults(t *testing.T) {
+	b := newTestBridge(t)
+
+	// A failing Mailcow is reported and nothing is stored
+	// Errors of Mailcow are logged but not passed on to clients
+	b.mailcow.FailNext("/api/v1/add/alias", 1)
+	if status, body := b.doRaw(t, "POST", "/api/alias/random/new", aliceLogin, nil); status != http.StatusInternalServerError || strings.Contains(string(body), "injected") {
+		t.Errorf("expected a generic 500 for a failing Mailcow, got %d: %s", status, body)
+	}
+
+	// Mailcow reports rejections with status 200 and a danger message
+	b.mailcow.DangerNext("/api/v1/add/alias", 1, "alias_invalid")
+	if status, body := b.doRaw(t, "POST", "/api/alias/random/new", aliceLogin, nil); status != http.StatusInternalServerError || strings.Contains(string(body), "alias_invalid") {
+		t.Errorf("expected a generic 500 for a rejected alias, got %d: %s", status, body)
+	}
+	if aliases := b.mailcow.Aliases(); len(aliases) != 0 {
+		t.Errorf("expected no aliases in Mailcow, got %+v", aliases)
+	}
+	if records := b.store.AliasesOf("alice@example.com"); len(records) != 0 {
+		t.Errorf("expected no stored aliases, got %+v", records)
+	}
+
+	// Mailbox lookups failing once do not prevent later requests
+	b.mailcow.FailNext("/api/v1/get/mailbox", 1)
+	if status, body := b.doRaw(t, "POST", "/api/alias/random/new", aliceLogin, nil); status != http.StatusInternalServerError ||
+		strings.TrimSpace(string(body)) != "Internal server error: failed to look up mailbox" {
+		t.Errorf("expected a generic 500 for a failing mailbox lookup, got %d: %s", status, body)
+	}
+	b.mailcow.SetLatency(20 * time.Millisecond)
+	if status, response := b.do(t, "POST", "/api/alias/random/new", aliceLogin, nil); status != http.StatusOK {
+		t.Errorf("expected 200 once Mailcow recovered, got %d: %v", status, response)
+	}
+}
+
+func TestEndToEndUpdateAndActivity(t *testing.T) {
+	b := newTestBridge(t)
+
+	status, response := b.do(t, "POST", "/api/alias/random/new", aliceLogin, nil)
+	if status != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %v", status, response)
+	}
+	address := response["alias"].(string)
+	id := int(response["id"].(float64))
+
+	// Forward to the delegated team mailbox as well
+	_, mailboxes := b.do(t, "GET", "/api/v2/mailboxes", aliceLogin, nil)
+	list, _ := mailboxes["mailboxes"].([]interface{})
+	var ids []int
+	for _, mailbox := range list {
+		ids = append(ids, int(mailbox.(map[string]interface{})["id"].(float64)))
+	}
+	if len(ids) != 2 {
+		t.Fatalf("expected the own and the delegated mailbox, got %v", mailboxes)
+	}
+	if status, response := b.do(t, "PATCH", fmt.Sprintf("/api/aliases/%d", id), aliceLogin, map[string][]int{"mailbox_ids": ids}); status != http.StatusOK {
+		t.Fatalf("expected 200 for the update, got %d: %v", status, response)
+	}
+	if updated, _ := b.mailcow.Alias(address); updated.Goto != "alice@example.com,team@example.com" {
+		t.Errorf("unexpected destinations in Mailcow: %q", updated.Goto)
+	}
+
+	// Deliveries in the Mailcow logs show up in the alias statistics
+	b.mailcow.AddDelivery("shop@example.net", "alice@example.com", address, time.Now().Add(-time.Minute))
+	if _, err := activity.NewTracker(b.backends, b.store, 100).Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	status, response = b.do(t, "GET", fmt.Sprintf("/api/aliases/%d", id), aliceLogin, nil)
+	if status != http.StatusOK || response["nb_forward"] != float64(1) {
+		t.Errorf("expected one forward, got %d: %v", status, response)
+	}
+}
+
+func TestEndToEndUnavailableMailcow(t *testing.T) {
+	b := newTestBridge(t)
+
+	readiness := func() (int, map[string]interface{}) {
+		t.Helper()
+		return b.do(t, "GET", "/readyz", "", nil)
+	}
+	if status, response := readiness(); status != http.StatusOK || response["status"] != "ready" {
+		t.Fatalf("expected ready, got %d: %v", status, response)
+	}
+
+	// The health check notices Mailcow going down, requests fail fast until it recovers
+	b.mailcow.SetUnreachable(true)
+	if mailErr, authErr := b.backends.Default().CheckHealth(); mailErr == nil || authErr != nil {
+		t.Fatalf("CheckHealth = %v, %v", mailErr, authErr)
+	}
+	status, response := readiness()
+	backends, _ := response["backends"].([]interface{})
+	if status != http.StatusServiceUnavailable || response["status"] != "degraded" || len(backends) != 1 ||
+		backends[0].(map[string]interface{})["mail_server"] != "unavailable" {
+		t.Errorf("expected degraded readiness, got %d: %v", status, response)
+	}
+	if status, _ := b.do(t, "GET", "/healthz", "", nil); status != http.StatusOK {
+		t.Errorf("expected liveness while degraded, got %d", status)
+	}
+
+	before := len(b.mailcow.Requests())
+	if status, _ := b.do(t, "POST", "/api/alias/random/new", aliceLogin, nil); status != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 while Mailcow is down, got %d", status)
+	}
+	if requests := b.mailcow.Requests(); len(requests) != before {
+		t.Errorf("requests reached Mailcow while it was down: %v", requests[before:])
+	}
+
+	b.mailcow.SetUnreachable(false)
+	if mailErr, _ := b.backends.Default().CheckHealth(); mailErr != nil {
+		t.Fatalf("expected Mailcow to recover, got %v", mailErr)
+	}
+	if status, response := readiness(); status != http.StatusOK {
+		t.Errorf("expected ready after recovery, got %d: %v", status, response)
+	}
+	if status, response := b.do(t, "POST", "/api/alias/random/new", aliceLogin, nil); status != http.StatusOK {
+		t.Errorf("expected 200 after recovery, got %d: %v", status, response)
+	}
+}
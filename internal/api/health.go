@@ -0,0 +1,104 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/auth"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/backend"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/logger"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/mailcow"
+)
+
+// Server states reported by the readiness endpoint
+const (
+	serverAvailable   = "available"
+	serverUnavailable = "unavailable"
+)
+
+// backendStatus is the readiness of a backend
+type backendStatus struct {
+	Name       string `json:"name"`
+	MailServer string `json:"mail_server"`
+	AuthServer string `json:"auth_server"`
+}
+
+// readinessResponse is the body of the readiness endpoint
+type readinessResponse struct {
+	Status   string          `json:"status"` // "ready" or "degraded"
+	Backends []backendStatus `json:"backends"`
+}
+
+// serverState names the state of a server
+func serverState(available bool) string {
+	if available {
+		return serverAvailable
+	}
+	return serverUnavailable
+}
+
+// isUnavailable reports whether an error was caused by an unreachable server
+func isUnavailable(err error) bool {
+	return errors.Is(err, backend.ErrUnavailable) || errors.Is(err, mailcow.ErrUnavailable) || errors.Is(err, auth.ErrUnavailable)
+}
+
+// retryAfter is the Retry-After header of responses for unreachable servers, the time until the next check
+func (a *API) retryAfter() string {
+	if a.config.HealthCheckInterval < 1 {
+		return "1"
+	}
+	return strconv.Itoa(a.config.HealthCheckInterval)
+}
+
+// writeUnavailable rejects a request that needs an unreachable server.
+// The error is only logged, it may reveal addresses of internal servers.
+func (a *API) writeUnavailable(w http.ResponseWriter, log *logger.Logger, err error) {
+	log.Warn("Rejecting request: %v", err)
+	w.Header().Set("Retry-After", a.retryAfter())
+	http.Error(w, "Service unavailable: mail or authentication server unreachable, try again later", http.StatusServiceUnavailable)
+}
+
+// requireMailServer fails fast while the mail server of a backend is unreachable.
+// On failure the error response has already been written.
+func (a *API) requireMailServer(w http.ResponseWriter, b *backend.Backend, log *logger.Logger) bool {
+	if b.MailServerAvailable() {
+		return true
+	}
+	a.writeUnavailable(w, log, fmt.Errorf("%w: backend %s", backend.ErrUnavailable, b.Name))
+	return false
+}
+
+// handleHealthz reports that the bridge is running, regardless of its backends
+func (a *API) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"status":"ok"}` + "\n"))
+}
+
+// handleReadyz reports whether the mail and auth servers of all backends are reachable
+func (a *API) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	response := readinessResponse{Status: "ready", Backends: []backendStatus{}}
+	for _, b := range a.backends.All() {
+		mailAvailable, authAvailable := b.MailServerAvailable(), b.AuthServerAvailable()
+		if !mailAvailable || !authAvailable {
+			response.Status = "degraded"
+		}
+		response.Backends = append(response.Backends, backendStatus{
+			Name:       b.Name,
+			MailServer: serverState(mailAvailable),
+			AuthServer: serverState(authAvailable),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if response.Status != "ready" {
+		w.Header().Set("Retry-After", a.retryAfter())
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		a.logger.Error("Failed to encode readiness response: %v", err)
+	}
+}
@@ -0,0 +1,184 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/logger"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/mailcow"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/store"
+)
+
+// mailboxObject follows SimpleLogin's mailbox object
+type mailboxObject struct {
+	ID                int    `json:"id"`
+	Email             string `json:"email"`
+	Default           bool   `json:"default"`
+	CreationTimestamp int64  `json:"creation_timestamp"`
+	NbAlias           int    `json:"nb_alias"`
+	Verified          bool   `json:"verified"`
+}
+
+// mailboxRef references a mailbox in alias objects
+type mailboxRef struct {
+	ID    int    `json:"id"`
+	Email string `json:"email"`
+}
+
+// availableMailboxes returns the mailbox of the login followed by the active mailboxes
+// delegated to it with a delegate tag. Backends without Mailcow have no delegations.
+func (a *API) availableMailboxes(owner *mailcow.Mailbox) ([]mailcow.Mailbox, error) {
+	client := a.backendFor(owner.Username).Mailcow
+	if client == nil {
+		return []mailcow.Mailbox{*owner}, nil
+	}
+	mailboxes, err := client.GetMailboxes()
+	if err != nil {
+		return nil, err
+	}
+
+	available := []mailcow.Mailbox{*owner}
+	for _, mailbox := range mailboxes {
+		if !strings.EqualFold(mailbox.Username, owner.Username) && mailbox.IsActive() && mailbox.IsDelegatedTo(owner.Username) {
+			available = append(available, mailbox)
+		}
+	}
+	return available, nil
+}
+
+// writeMailboxLookupError reports a failed lookup of the available mailboxes
+func (a *API) writeMailboxLookupError(w http.ResponseWriter, log *logger.Logger, err error) {
+	if isUnavailable(err) {
+		a.writeUnavailable(w, log, err)
+		return
+	}
+	writeInternalError(w, log, "look up mailboxes", err)
+}
+
+// aliasDestinations returns the mailboxes an alias forwards to
+func aliasDestinations(record *store.AliasRecord) []string {
+	if len(record.Mailboxes) == 0 {
+		return []string{record.Owner}
+	}
+	return record.Mailboxes
+}
+
+// aliasMailboxes returns references to the mailboxes an alias forwards to
+func (a *API) aliasMailboxes(record *store.AliasRecord) []mailboxRef {
+	var refs []mailboxRef
+	for _, address := range aliasDestinations(record) {
+		id, err := a.store.MailboxID(address)
+		if err != nil {
+			a.logger.Warn("Failed to assign an ID to mailbox %s: %v", address, err)
+		}
+		refs = append(refs, mailboxRef{ID: id, Email: address})
+	}
+	return refs
+}
+
+// destinations resolves the requested mailbox IDs to the addresses aliases of owner forward to,
+// the owner's own mailbox without IDs. On failure the error response has already been written.
+func (a *API) destinations(w http.ResponseWriter, log *logger.Logger, owner *mailcow.Mailbox, ids []int) ([]string, bool) {
+	if len(ids) == 0 {
+		return []string{owner.Username}, true
+	}
+
+	var available []mailcow.Mailbox
+	var addresses []string
+	for _, id := range ids {
+		address, err := a.store.MailboxAddress(id)
+		if err != nil {
+			log.Warn("Unknown mailbox ID %d", id)
+			http.Error(w, fmt.Sprintf("Bad request: unknown mailbox_id %d", id), http.StatusBadRequest)
+			return nil, false
+		}
+
+		permitted := strings.EqualFold(address, owner.Username)
+		if !permitted {
+			// Delegations are only looked up if another mailbox is requested
+			if available == nil {
+				available, err = a.availableMailboxes(owner)
+				if err != nil {
+					a.writeMailboxLookupError(w, log, err)
+					return nil, false
+				}
+			}
+			for _, mailbox := range available {
+				permitted = permitted || strings.EqualFold(mailbox.Username, address)
+			}
+		}
+		if !permitted {
+			log.Warn("Mailbox %s is not delegated to %s", maskUsername(address), maskUsername(owner.Username))
+			http.Error(w, fmt.Sprintf("Forbidden: mailbox %d is not available", id), http.StatusForbidden)
+			return nil, false
+		}
+
+		duplicate := false
+		for _, existing := range addresses {
+			duplicate = duplicate || existing == address
+		}
+		if !duplicate {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses, true
+}
+
+// handleMailboxes lists the mailboxes the user may choose as alias destination
+func (a *API) handleMailboxes(w http.ResponseWriter, r *http.Request) {
+	requestID := fmt.Sprintf("%d", time.Now().UnixNano())
+	log := a.logger.WithRequestID(requestID)
+
+	username, ok := a.authenticateRequest(w, r, log)
+	if !ok {
+		return
+	}
+
+	owner, ok := a.resolveMailbox(w, username, log)
+	if !ok {
+		return
+	}
+
+	available, err := a.availableMailboxes(owner)
+	if err != nil {
+		a.writeMailboxLookupError(w, log, err)
+		return
+	}
+
+	// Count the user's aliases per destination
+	counts := make(map[string]int)
+	for _, record := range a.store.AliasesOf(owner.Username) {
+		for _, address := range aliasDestinations(&record) {
+			counts[strings.ToLower(address)]++
+		}
+	}
+
+	mailboxes := make([]mailboxObject, 0, len(available))
+	for i, mailbox := range available {
+		id, err := a.store.MailboxID(mailbox.Username)
+		if err != nil {
+			log.Error("Failed to assign an ID to mailbox %s: %v", mailbox.Username, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		object := mailboxObject{
+			ID:       id,
+			Email:    strings.ToLower(mailbox.Username),
+			Default:  i == 0,
+			NbAlias:  counts[strings.ToLower(mailbox.Username)],
+			Verified: true,
+		}
+		if created := mailbox.CreatedAt(); !created.IsZero() {
+			object.CreationTimestamp = created.Unix()
+		}
+		mailboxes = append(mailboxes, object)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string][]mailboxObject{"mailboxes": mailboxes}); err != nil {
+		log.Error("Failed to encode response: %v", err)
+	}
+}
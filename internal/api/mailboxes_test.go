@@ -0,0 +1,131 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/backend"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/config"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/logger"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/mailcow"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/store"
+)
+
+func TestDestinations(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/get/mailbox/all" {
+			w.Write([]byte(`[
+				{"username": "alice@example.com", "active": 1},
+				{"username": "team@example.com", "active": 1, "tags": ["delegate:Alice@example.com"]},
+				{"username": "old-team@example.com", "active": 0, "tags": ["delegate:alice@example.com"]},
+				{"username": "bob@example.com", "active": 1}
+			]`))
+			return
+		}
+		w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	client, err := mailcow.NewMailcowClient(server.URL, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := store.Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &API{backends: backend.Single(client, nil), store: st, logger: logger.WithComponent("Test")}
+	owner := &mailcow.Mailbox{Username: "alice@example.com", Active: true}
+
+	available, err := a.availableMailboxes(owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(available) != 2 || available[0].Username != "alice@example.com" || available[1].Username != "team@example.com" {
+		t.Fatalf("unexpected available mailboxes: %+v", available)
+	}
+
+	ids := make(map[string]int)
+	for _, address := range []string{"alice@example.com", "team@example.com", "bob@example.com"} {
+		if ids[address], err = st.MailboxID(address); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		ids    []int
+		status int
+		want   int
+	}{
+		{nil, 0, 1},
+		{[]int{ids["team@example.com"], ids["alice@example.com"], ids["team@example.com"]}, 0, 2},
+		{[]int{ids["bob@example.com"]}, http.StatusForbidden, 0},
+		{[]int{99}, http.StatusBadRequest, 0},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		mailboxes, ok := a.destinations(rec, a.logger, owner, test.ids)
+		if test.status != 0 {
+			if ok || rec.Code != test.status {
+				t.Errorf("destinations(%v): expected status %d, got %d", test.ids, test.status, rec.Code)
+			}
+			continue
+		}
+		if !ok || len(mailboxes) != test.want {
+			t.Errorf("destinations(%v) = %v, %v", test.ids, mailboxes, ok)
+		}
+	}
+}
+
+func TestDestinationsLookupFailures(t *testing.T) {
+	var drop bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if drop {
+			// Drop the connection like an unreachable server
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"type": "error", "msg": "internal detail"}`))
+	}))
+	defer server.Close()
+
+	client, err := mailcow.NewMailcowClient(server.URL, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := store.Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &API{config: &config.Config{HealthCheckInterval: 15}, backends: backend.Single(client, nil), store: st, logger: logger.WithComponent("Test")}
+	owner := &mailcow.Mailbox{Username: "alice@example.com", Active: true}
+	teamID, err := st.MailboxID("team@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		drop   bool
+		status int
+	}{
+		{false, http.StatusInternalServerError},
+		{true, http.StatusServiceUnavailable},
+	} {
+		drop = test.drop
+		rec := httptest.NewRecorder()
+		if _, ok := a.destinations(rec, a.logger, owner, []int{teamID}); ok || rec.Code != test.status {
+			t.Errorf("expected status %d, got %d", test.status, rec.Code)
+		}
+		// Upstream errors are not passed on to clients
+		if body := rec.Body.String(); strings.Contains(body, "internal detail") || strings.Contains(body, server.Listener.Addr().String()) {
+			t.Errorf("response reveals the upstream error: %s", body)
+		}
+		if test.status == http.StatusServiceUnavailable && rec.Header().Get("Retry-After") != "15" {
+			t.Errorf("expected Retry-After for an unreachable server, got %q", rec.Header().Get("Retry-After"))
+		}
+	}
+}
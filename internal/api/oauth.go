@@ -0,0 +1,103 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// oauthStateCookie holds the state parameter of a pending authorization-code flow
+const oauthStateCookie = "oauth_state"
+
+// handleOAuthLogin starts the OAuth2 authorization-code flow of the default Mailcow backend
+func (a *API) handleOAuthLogin(w http.ResponseWriter, r *http.Request) {
+	requestID := fmt.Sprintf("%d", time.Now().UnixNano())
+	log := a.logger.WithRequestID(requestID)
+
+	stateBytes := make([]byte, 16)
+	if _, err := rand.Read(stateBytes); err != nil {
+		log.Error("Failed to generate OAuth2 state: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	state := hex.EncodeToString(stateBytes)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    state,
+		Path:     "/oauth",
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	log.Info("Redirecting to Mailcow OAuth2 authorization")
+	http.Redirect(w, r, a.backends.Default().Auth.OAuthAuthorizeURL(a.config.OAuthClientID, a.config.OAuthRedirectURL, state), http.StatusFound)
+}
+
+// handleOAuthCallback completes the authorization-code flow and hands out the access token,
+// which is then used as API key
+func (a *API) handleOAuthCallback(w http.ResponseWriter, r *http.Request) {
+	requestID := fmt.Sprintf("%d", time.Now().UnixNano())
+	log := a.logger.WithRequestID(requestID)
+
+	log.Info("Processing OAuth2 callback")
+
+	if errParam := r.URL.Query().Get("error"); errParam != "" {
+		log.Warn("OAuth2 authorization denied: %s", errParam)
+		http.Error(w, "Unauthorized: OAuth2 authorization denied", http.StatusUnauthorized)
+		return
+	}
+
+	cookie, err := r.Cookie(oauthStateCookie)
+	state := r.URL.Query().Get("state")
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		log.Warn("OAuth2 callback with missing or mismatching state")
+		http.Error(w, "Bad request: invalid OAuth2 state", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oauthStateCookie, Path: "/oauth", MaxAge: -1})
+
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		log.Warn("OAuth2 callback without authorization code")
+		http.Error(w, "Bad request: missing authorization code", http.StatusBadRequest)
+		return
+	}
+
+	token, err := a.backends.Default().Auth.ExchangeOAuthCode(code, a.config.OAuthClientID, a.config.OAuthClientSecret, a.config.OAuthRedirectURL)
+	if err != nil {
+		errorMsg := fmt.Sprintf("Authentication failed: %v", err)
+		log.Warn("%s", errorMsg)
+		http.Error(w, errorMsg, http.StatusUnauthorized)
+		return
+	}
+
+	username, err := a.backends.Default().Auth.AuthenticateToken(token.AccessToken, clientIP(r))
+	if err != nil {
+		a.writeAuthError(w, err, log)
+		return
+	}
+	log.Info("User %s logged in through OAuth2", maskUsername(username))
+
+	// Mirror the response of SimpleLogin's login endpoint
+	response := map[string]interface{}{
+		"api_key":     token.AccessToken,
+		"email":       username,
+		"name":        username,
+		"expires_in":  token.ExpiresIn,
+		"mfa_enabled": false,
+		"mfa_key":     nil,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Error("Failed to encode response: %v", err)
+	}
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/alias"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/policy"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/store"
+)
+
+// quotaUsage is the usage of a single alias limit
+type quotaUsage struct {
+	Used  int `json:"used"`
+	Limit int `json:"limit"` // 0 means unlimited
+}
+
+// quotaExceeded describes a hit alias limit
+type quotaExceeded struct {
+	Detail     string
+	RetryAfter time.Duration // 0 if waiting does not help
+}
+
+// limitsFor returns the alias limits applying to a policy decision
+func (a *API) limitsFor(decision policy.Decision) policy.Limits {
+	if decision.Limits != nil {
+		return *decision.Limits
+	}
+	return policy.Limits{
+		MaxAliases: a.config.AliasQuotaTotal,
+		PerHour:    a.config.AliasQuotaHourly,
+		PerDay:     a.config.AliasQuotaDaily,
+	}
+}
+
+// limitedAliases returns the aliases of an owner counting against the limits.
+// Subaddresses do not, nothing is created in Mailcow for them.
+func (a *API) limitedAliases(owner string) []store.AliasRecord {
+	var records []store.AliasRecord
+	for _, record := range a.store.AliasesOf(owner) {
+		if record.Type != alias.TypeSubaddress {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// countSince returns how many records were created after a point in time
+func countSince(records []store.AliasRecord, since time.Time) int {
+	count := 0
+	for _, record := range records {
+		if record.CreatedAt.After(since) {
+			count++
+		}
+	}
+	return count
+}
+
+// quotaUsages returns the current usage of all alias limits of an owner
+func (a *API) quotaUsages(owner string, limits policy.Limits, now time.Time) map[string]quotaUsage {
+	records := a.limitedAliases(owner)
+	return map[string]quotaUsage{
+		"total":  {Used: len(records), Limit: limits.MaxAliases},
+		"hourly": {Used: countSince(records, now.Add(-time.Hour)), Limit: limits.PerHour},
+		"daily":  {Used: countSince(records, now.Add(-24*time.Hour)), Limit: limits.PerDay},
+	}
+}
+
+// checkQuota returns the first alias limit an owner has reached, or nil
+func (a *API) checkQuota(owner string, limits policy.Limits, now time.Time) *quotaExceeded {
+	records := a.limitedAliases(owner)
+
+	if limits.MaxAliases > 0 && len(records) >= limits.MaxAliases {
+		return &quotaExceeded{Detail: fmt.Sprintf("maximum of %d aliases reached", limits.MaxAliases)}
+	}
+
+	windows := []struct {
+		name   string
+		limit  int
+		window time.Duration
+	}{
+		{"hour", limits.PerHour, time.Hour},
+		{"day", limits.PerDay, 24 * time.Hour},
+	}
+	for _, w := range windows {
+		if w.limit <= 0 {
+			continue
+		}
+
+		var created []time.Time
+		for _, record := range records {
+			if record.CreatedAt.After(now.Add(-w.window)) {
+				created = append(created, record.CreatedAt)
+			}
+		}
+		if len(created) < w.limit {
+			continue
+		}
+
+		// The limit frees up once enough creations have left the window
+		sort.Slice(created, func(i, j int) bool { return created[i].Before(created[j]) })
+		retryAfter := created[len(created)-w.limit].Add(w.window).Sub(now)
+		return &quotaExceeded{
+			Detail:     fmt.Sprintf("maximum of %d aliases per %s reached", w.limit, w.name),
+			RetryAfter: retryAfter,
+		}
+	}
+
+	return nil
+}
+
+// keyedMutex serializes operations per key, e.g. quota check and alias creation per owner
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock acquires the lock of a key, the returned function releases it
+func (k *keyedMutex) lock(key string) func() {
+	k.mu.Lock()
+	if k.locks == nil {
+		k.locks = make(map[string]*keyedLock)
+	}
+	l, ok := k.locks[key]
+	if !ok {
+		l = &keyedLock{}
+		k.locks[key] = l
+	}
+	l.refs++
+	k.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		k.mu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
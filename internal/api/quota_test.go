@@ -0,0 +1,55 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/alias"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/config"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/policy"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/store"
+)
+
+func TestCheckQuota(t *testing.T) {
+	st, err := store.Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &API{config: &config.Config{AliasQuotaHourly: 2, AliasQuotaTotal: 3}, store: st}
+
+	now := time.Now()
+	limits := a.limitsFor(policy.Decision{})
+	if exceeded := a.checkQuota("alice@example.com", limits, now); exceeded != nil {
+		t.Fatalf("unexpected limit without aliases: %+v", exceeded)
+	}
+
+	for _, age := range []time.Duration{50 * time.Minute, 20 * time.Minute} {
+		if _, err := st.AddAlias(store.AliasRecord{Address: "a@example.com", Owner: "alice@example.com", CreatedAt: now.Add(-age)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Subaddresses do not count against the limits
+	if _, err := st.AddAlias(store.AliasRecord{Address: "alice+site@example.com", Owner: "alice@example.com", Type: alias.TypeSubaddress, CreatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+
+	exceeded := a.checkQuota("alice@example.com", limits, now)
+	if exceeded == nil || exceeded.RetryAfter != 10*time.Minute {
+		t.Fatalf("expected hourly limit freeing up in 10m, got %+v", exceeded)
+	}
+
+	// A group override replaces the global limits
+	override := a.limitsFor(policy.Decision{Limits: &policy.Limits{PerHour: 5}})
+	if exceeded := a.checkQuota("alice@example.com", override, now); exceeded != nil {
+		t.Errorf("unexpected limit with override: %+v", exceeded)
+	}
+
+	if _, err := st.AddAlias(store.AliasRecord{Address: "b@example.com", Owner: "alice@example.com", CreatedAt: now.Add(-3 * time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	exceeded = a.checkQuota("alice@example.com", limits, now)
+	if exceeded == nil || exceeded.RetryAfter != 0 {
+		t.Errorf("expected total limit without retry, got %+v", exceeded)
+	}
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/alias"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/backend"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/logger"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/store"
+)
+
+// maxHostnameLength bounds the hostname parameter, the maximum length of a DNS name
+const maxHostnameLength = 253
+
+// hostnameCommentPrefix marks the private comment of aliases created for a hostname
+const hostnameCommentPrefix = "simplelogin-bridge hostname="
+
+// hostnameComment returns the private comment recording the hostname an alias was created for
+func hostnameComment(hostname string) string {
+	return hostnameCommentPrefix + strings.ToLower(hostname)
+}
+
+// validHostname reports whether a hostname parameter only has the characters of a DNS name.
+// Hostnames end up in mail server comments and configuration files, so nothing else is accepted.
+func validHostname(hostname string) bool {
+	if len(hostname) > maxHostnameLength {
+		return false
+	}
+	for _, c := range hostname {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// hostnameParam returns the optional hostname parameter of a request.
+// On failure the error response has already been written.
+func hostnameParam(w http.ResponseWriter, r *http.Request, log *logger.Logger) (string, bool) {
+	hostname := r.URL.Query().Get("hostname")
+	if !validHostname(hostname) {
+		log.Warn("Rejecting invalid hostname parameter %q", hostname)
+		http.Error(w, fmt.Sprintf("Bad request: hostname must be a DNS name of at most %d characters", maxHostnameLength), http.StatusBadRequest)
+		return "", false
+	}
+	return hostname, true
+}
+
+// findAliasForHostname returns a usable alias the owner already has for a hostname, or nil.
+// The store is searched first; aliases recorded there must still exist on the mail server.
+// Aliases created without the store are found by their private comment.
+func (a *API) findAliasForHostname(owner, hostname string, log *logger.Logger) *store.AliasRecord {
+	now := time.Now()
+
+	// The mail server's alias list is only fetched if needed
+	var serverAliases []backend.Alias
+	fetched := false
+	fetch := func() []backend.Alias {
+		if !fetched {
+			fetched = true
+			aliases, err := a.backendFor(owner).Aliases.ListAliases()
+			if err != nil {
+				log.Warn("Failed to fetch aliases for reuse, creating a new one: %v", err)
+			}
+			serverAliases = aliases
+		}
+		return serverAliases
+	}
+	activeOnServer := func(address string) bool {
+		for _, existing := range fetch() {
+			if strings.EqualFold(existing.Address, address) && forwardsOnlyTo(existing, owner) {
+				return existing.Active
+			}
+		}
+		return false
+	}
+
+	records := a.store.AliasesOf(owner)
+	for i := len(records) - 1; i >= 0; i-- {
+		record := records[i]
+		if !strings.EqualFold(record.Hostname, hostname) {
+			continue
+		}
+
+		switch {
+		case record.Type == alias.TypeSubaddress:
+			return &record
+		case record.ExpiresAt != nil:
+			if record.ExpiresAt.After(now) {
+				return &record
+			}
+		case activeOnServer(record.Address):
+			return &record
+		}
+	}
+
+	comment := hostnameComment(hostname)
+	for _, existing := range fetch() {
+		if existing.Comment == comment && forwardsOnlyTo(existing, owner) && existing.Active {
+			return &store.AliasRecord{Address: existing.Address, Owner: owner, Type: alias.TypePermanent, Hostname: hostname}
+		}
+	}
+	return nil
+}
+
+// forwardsOnlyTo reports whether an alias forwards to the owner's mailbox alone
+func forwardsOnlyTo(existing backend.Alias, owner string) bool {
+	return len(existing.Goto) == 1 && strings.EqualFold(existing.Goto[0], owner)
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/alias"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/backend"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/logger"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/mailcow"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/store"
+)
+
+func TestFindAliasForHostname(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/get/alias/all" {
+			w.Write([]byte(`[
+				{"id": 1, "address": "kept@example.com", "goto": "alice@example.com", "active": 1},
+				{"id": 2, "address": "legacy@example.com", "goto": "alice@example.com", "active": "1",
+				 "private_comment": "simplelogin-bridge hostname=gitlab.com"}
+			]`))
+			return
+		}
+		w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	client, err := mailcow.NewMailcowClient(server.URL, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := store.Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &API{backends: backend.Single(client, nil), store: st}
+	log := logger.WithComponent("Test")
+
+	expired := time.Now().Add(-time.Hour)
+	for _, record := range []store.AliasRecord{
+		{Address: "kept@example.com", Owner: "alice@example.com", Hostname: "github.com"},
+		{Address: "deleted@example.com", Owner: "alice@example.com", Hostname: "github.com"},
+		{Address: "old-temp@example.com", Owner: "alice@example.com", Hostname: "example.org", Type: alias.TypeTemporary, ExpiresAt: &expired},
+		{Address: "alice+news@example.com", Owner: "alice@example.com", Hostname: "news.example", Type: alias.TypeSubaddress},
+	} {
+		if _, err := st.AddAlias(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := map[string]string{
+		"github.com":   "kept@example.com", // the newer record was deleted in Mailcow
+		"GitLab.com":   "legacy@example.com",
+		"news.example": "alice+news@example.com",
+		"example.org":  "",
+		"unknown.com":  "",
+	}
+	for hostname, want := range tests {
+		got := ""
+		if record := a.findAliasForHostname("alice@example.com", hostname, log); record != nil {
+			got = record.Address
+		}
+		if got != want {
+			t.Errorf("findAliasForHostname(%s) = %q, want %q", hostname, got, want)
+		}
+	}
+}
+
+func TestFindAliasForHostnamePostfix(t *testing.T) {
+	aliases := backend.NewPostfixAliases(filepath.Join(t.TempDir(), "virtual"), "", nil)
+	set, err := backend.NewSet([]*backend.Backend{{Name: "postfix", Domains: []string{backend.DefaultDomain}, Aliases: aliases}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := store.Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &API{backends: set, store: st}
+	log := logger.WithComponent("Test")
+
+	// Without Mailcow the login is the mailbox
+	mailbox, ok := a.resolveMailbox(httptest.NewRecorder(), "Alice@example.com", log)
+	if !ok || mailbox.Username != "alice@example.com" || mailbox.Domain != "example.com" {
+		t.Fatalf("resolveMailbox = %+v, %v", mailbox, ok)
+	}
+
+	create := a.permanentAlias("word.word@example.com", []string{mailbox.Username})
+	if _, err := create(a.backendFor(mailbox.Username), backend.AliasOptions{Comment: hostnameComment("GitHub.com")}); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	record := a.findAliasForHostname(mailbox.Username, "github.com", log)
+	if record == nil || record.Address != "word.word@example.com" {
+		t.Errorf("findAliasForHostname(github.com) = %+v", record)
+	}
+}
+
+func TestValidHostname(t *testing.T) {
+	for hostname, want := range map[string]bool{
+		"":                        true,
+		"shop.example":            true,
+		"WWW.Example-Shop.co.uk":  true,
+		"x\nceo@example.com a@b":  false,
+		"shop example":            false,
+		"shop.example\r":          false,
+		"shop@example.com":        false,
+		strings.Repeat("a", 254):  false,
+		"xn--bcher-kva.example":   true,
+		"shop.example/login?next": false,
+	} {
+		if got := validHostname(hostname); got != want {
+			t.Errorf("validHostname(%q) = %v, expected %v", hostname, got, want)
+		}
+	}
+}
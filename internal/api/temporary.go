@@ -0,0 +1,59 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/alias"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/backend"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/policy"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/store"
+)
+
+// aliasTypeFor returns the alias type of a request: the alias_type parameter if the policy
+// allows choosing, else the policy group's type, else the configured one
+func (a *API) aliasTypeFor(r *http.Request, decision policy.Decision) string {
+	if aliasType := r.URL.Query().Get("alias_type"); aliasType != "" && decision.AllowAliasTypeChoice {
+		return strings.ToLower(aliasType)
+	}
+	if decision.AliasType != "" {
+		return decision.AliasType
+	}
+	return a.config.AliasType
+}
+
+// temporaryValidity returns the validity of a time-limited alias, from the validity
+// parameter in hours or the configured alias validity period
+func (a *API) temporaryValidity(r *http.Request) (time.Duration, error) {
+	value := r.URL.Query().Get("validity")
+	if value == "" {
+		now := time.Now()
+		return now.AddDate(a.config.AliasValidityPeriod, 0, 0).Sub(now), nil
+	}
+
+	hours, err := strconv.Atoi(value)
+	if err != nil || hours < 1 {
+		return 0, fmt.Errorf("validity must be a positive number of hours")
+	}
+	return time.Duration(hours) * time.Hour, nil
+}
+
+// temporaryAlias returns the creator of a Mailcow time-limited alias, whose address Mailcow chooses
+func (a *API) temporaryAlias(username, domain, hostname string, validity time.Duration) aliasCreator {
+	return func(b *backend.Backend, _ backend.AliasOptions) (store.AliasRecord, error) {
+		description := "SimpleLogin bridge"
+		if hostname != "" {
+			description += ": " + hostname
+		}
+
+		created, err := b.Mailcow.CreateTimeLimitedAlias(username, domain, description, validity)
+		if err != nil {
+			return store.AliasRecord{}, err
+		}
+		expiresAt := created.ExpiresAt()
+		return store.AliasRecord{Address: created.Address, Type: alias.TypeTemporary, ExpiresAt: &expiresAt}, nil
+	}
+}
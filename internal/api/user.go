@@ -0,0 +1,53 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// userInfoResponse follows SimpleLogin's GET /api/user_info, extended by the alias limits
+type userInfoResponse struct {
+	Name       string                `json:"name"`
+	Email      string                `json:"email"`
+	IsPremium  bool                  `json:"is_premium"`
+	InTrial    bool                  `json:"in_trial"`
+	MaxAliases int                   `json:"max_alias_free_plan"`
+	Quota      map[string]quotaUsage `json:"quota"`
+}
+
+// handleUserInfo returns the user's profile and the usage of their alias limits
+func (a *API) handleUserInfo(w http.ResponseWriter, r *http.Request) {
+	requestID := fmt.Sprintf("%d", time.Now().UnixNano())
+	log := a.logger.WithRequestID(requestID)
+
+	username, ok := a.authenticateRequest(w, r, log)
+	if !ok {
+		return
+	}
+
+	mailbox, ok := a.resolveMailbox(w, username, log)
+	if !ok {
+		return
+	}
+
+	decision, ok := a.authorize(w, r, mailbox, log)
+	if !ok {
+		return
+	}
+
+	limits := a.limitsFor(decision)
+	response := userInfoResponse{
+		Name:       mailbox.Name,
+		Email:      mailbox.Username,
+		IsPremium:  true,
+		MaxAliases: limits.MaxAliases,
+		Quota:      a.quotaUsages(mailbox.Username, limits, time.Now()),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Error("Failed to encode response: %v", err)
+	}
+}
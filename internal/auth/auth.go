@@ -1,13 +1,16 @@
 package auth
 
 import (
-	"crypto/sha256"
+	"crypto/rand"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
+	"net/http"
 	"net/smtp"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/logger"
@@ -16,7 +19,8 @@ import (
 
 // AuthCache represents a cached authentication
 type AuthCache struct {
-	Expiry time.Time
+	Expiry   time.Time
+	Username string // mailbox resolved for token authentications
 }
 
 // AuthModule is a module for authenticating users against Mailcow
@@ -24,8 +28,19 @@ type AuthModule struct {
 	method        string
 	serverAddress string
 	cacheTTL      time.Duration
-	cache         map[string]AuthCache
-	cacheMutex    sync.RWMutex
+	cache         *authCache
+	cacheKey      []byte
+	persistentKey bool
+	snapshotPath  string     // rewritten when users are invalidated, see SetCacheSnapshotPath
+	snapshotMu    sync.Mutex // serializes snapshot writes
+	httpClient    *http.Client
+	ldapOptions   *LDAPOptions
+	tlsConfig     *tls.Config
+	lockout       *lockoutTracker
+	flights       *flightGroup
+	upstreamSlots chan struct{}
+	upstreamWait  time.Duration
+	unavailable   atomic.Bool // set while the auth server cannot be reached
 	logger        *logger.Logger
 }
 
@@ -43,11 +58,21 @@ func NewAuthModule(method, serverAddress string, cacheTTL int) (*AuthModule, err
 	// Convert TTL from seconds to duration
 	cacheDuration := time.Duration(cacheTTL) * time.Second
 
+	// Random per-process key for cache keys, replaced if a secret is configured
+	cacheKey := make([]byte, 32)
+	if _, err := rand.Read(cacheKey); err != nil {
+		return nil, fmt.Errorf("failed to generate cache key: %w", err)
+	}
+
 	return &AuthModule{
 		method:        method,
 		serverAddress: serverAddress,
 		cacheTTL:      cacheDuration,
-		cache:         make(map[string]AuthCache),
+		cache:         newAuthCache(defaultCacheMaxEntries),
+		cacheKey:      cacheKey,
+		httpClient:    &http.Client{Timeout: 10 * time.Second},
+		lockout:       newLockoutTracker(),
+		flights:       newFlightGroup(),
 		logger:        logger.WithComponent("Auth"),
 	}, nil
 }
@@ -57,32 +82,62 @@ func (a *AuthModule) IsCacheEnabled() bool {
 	return a.cacheTTL > 0
 }
 
-// hashCredentials creates a secure hash for the credential cache
-func hashCredentials(username, password string) string {
-	// Combine username and password, then hash
-	combined := username + ":" + password
-	hash := sha256.Sum256([]byte(combined))
-	return fmt.Sprintf("%x", hash)
+// Method returns the authentication method, e.g. "IMAP" or "OAUTH2"
+func (a *AuthModule) Method() string {
+	return a.method
+}
+
+// SetTLSConfig overrides the TLS configuration of IMAP and SMTP connections, e.g. to trust the
+// certificate of the dev mode IMAP server. LDAP is configured through its own options.
+func (a *AuthModule) SetTLSConfig(config *tls.Config) {
+	a.tlsConfig = config
+}
+
+// clientTLSConfig returns the TLS configuration of IMAP and SMTP connections
+func (a *AuthModule) clientTLSConfig() *tls.Config {
+	if a.tlsConfig != nil {
+		return a.tlsConfig.Clone()
+	}
+	return &tls.Config{InsecureSkipVerify: false}
+}
+
+// maskUsername shortens a username for logging
+func maskUsername(username string) string {
+	if len(username) > 3 {
+		return username[:3] + "***"
+	}
+	return username
 }
 
-// Authenticate authenticates a user against Mailcow
-func (a *AuthModule) Authenticate(username, password string) error {
+// Authenticate authenticates a user against Mailcow.
+// clientIP is used for brute-force protection and may be empty.
+func (a *AuthModule) Authenticate(username, password, clientIP string) error {
 	// Generate a request ID for logging
 	requestID := fmt.Sprintf("AUTH-%d", time.Now().UnixNano())
 	log := a.logger.WithRequestID(requestID)
 
 	// Mask username for logging
-	maskedUser := username
-	if len(maskedUser) > 3 {
-		maskedUser = maskedUser[:3] + "***"
+	maskedUser := maskUsername(username)
+	lockoutUser := strings.ToLower(username)
+	credHash := a.credentialKey(username, password)
+
+	// Locked usernames and clients are rejected before anything else, including the cache,
+	// so guesses cannot be verified against it
+	if err := a.lockout.check(lockoutUser, clientIP, time.Now()); err != nil {
+		log.Warn("Rejecting authentication for user %s from %s: %v", maskedUser, clientIP, err)
+		return err
+	}
+
+	// Credentials that failed recently are rejected without asking the server again
+	if a.lockout.isKnownFailure(credHash, time.Now()) {
+		log.Debug("Rejecting recently failed credentials for user %s", maskedUser)
+		a.recordFailure(lockoutUser, clientIP, credHash, log)
+		return fmt.Errorf("authentication failed: %w", ErrInvalidCredentials)
 	}
 
 	// Check cache if enabled (TTL > 0)
 	if a.IsCacheEnabled() {
-		credHash := hashCredentials(username, password)
-		a.cacheMutex.RLock()
-		cacheEntry, found := a.cache[credHash]
-		a.cacheMutex.RUnlock()
+		cacheEntry, found := a.cache.get(credHash)
 
 		if found && time.Now().Before(cacheEntry.Expiry) {
 			log.Debug("Using cached authentication for user %s (valid until %s)",
@@ -99,36 +154,35 @@ func (a *AuthModule) Authenticate(username, password string) error {
 	log.Info("Starting %s authentication for user %s to server %s",
 		strings.ToUpper(a.method), maskedUser, a.serverAddress)
 
-	var err error
 	startTime := time.Now()
 
-	switch strings.ToUpper(a.method) {
-	case "IMAP":
-		err = a.authenticateIMAP(username, password, requestID)
-	case "SMTP":
-		err = a.authenticateSMTP(username, password, requestID)
-	default:
-		err = fmt.Errorf("unsupported authentication method: %s", a.method)
-		log.Error("%v", err)
-		return err
+	// Concurrent requests with the same credentials share a single upstream authentication
+	_, shared, err := a.flights.do(credHash, func() (string, error) {
+		return "", a.authenticateUpstream(username, password, requestID)
+	})
+	if shared {
+		log.Debug("Joined in-flight authentication for user %s", maskedUser)
 	}
 
 	duration := time.Since(startTime)
 	if err != nil {
 		log.Error("Authentication failed after %s: %v", logger.FormatDuration(duration), err)
+		// Only wrong credentials count, an unreachable server must not lock anyone out.
+		// A failed flight counts once, joined callers made no attempt of their own.
+		if errors.Is(err, ErrInvalidCredentials) && !shared {
+			a.recordFailure(lockoutUser, clientIP, credHash, log)
+		}
 		return err
 	}
+	a.lockout.recordSuccess(lockoutUser)
 
 	// Cache successful authentication if caching is enabled
 	if a.IsCacheEnabled() {
-		credHash := hashCredentials(username, password)
 		expiry := time.Now().Add(a.cacheTTL)
 
-		a.cacheMutex.Lock()
-		a.cache[credHash] = AuthCache{
+		a.cache.set(credHash, a.userKey(username), AuthCache{
 			Expiry: expiry,
-		}
-		a.cacheMutex.Unlock()
+		})
 
 		log.Debug("Cached authentication for user %s (valid until %s)",
 			maskedUser, expiry.Format(time.RFC3339))
@@ -138,25 +192,54 @@ func (a *AuthModule) Authenticate(username, password string) error {
 	return nil
 }
 
-// CleanupCache removes expired entries from the cache
-func (a *AuthModule) CleanupCache() int {
-	if !a.IsCacheEnabled() {
-		return 0
+// authenticateUpstream authenticates a user against the configured server, waiting for a free upstream slot
+func (a *AuthModule) authenticateUpstream(username, password, requestID string) error {
+	log := a.logger.WithRequestID(requestID)
+
+	if err := a.checkAvailable(); err != nil {
+		return err
+	}
+	release, err := a.acquireUpstream()
+	if err != nil {
+		log.Warn("No upstream authentication slot available: %v", err)
+		return err
 	}
+	defer release()
 
-	now := time.Now()
-	removed := 0
+	switch strings.ToUpper(a.method) {
+	case "IMAP":
+		return a.authenticateIMAP(username, password, requestID)
+	case "SMTP":
+		return a.authenticateSMTP(username, password, requestID)
+	case "OAUTH2":
+		return a.authenticateOAuth(username, password, requestID)
+	case "LDAP":
+		return a.authenticateLDAP(username, password, requestID)
+	default:
+		err := fmt.Errorf("unsupported authentication method: %s", a.method)
+		log.Error("%v", err)
+		return err
+	}
+}
 
-	a.cacheMutex.Lock()
-	defer a.cacheMutex.Unlock()
+// recordFailure counts a failed authentication and logs resulting lockouts
+func (a *AuthModule) recordFailure(username, clientIP, credHash string, log *logger.Logger) {
+	userLock, clientLock := a.lockout.recordFailure(username, clientIP, credHash, time.Now())
+	if userLock > 0 {
+		log.Warn("Locking out user %s for %s after repeated failures", maskUsername(username), userLock)
+	}
+	if clientLock > 0 {
+		log.Warn("Locking out client %s for %s after repeated failures", clientIP, clientLock)
+	}
+}
 
-	for key, entry := range a.cache {
-		if now.After(entry.Expiry) {
-			delete(a.cache, key)
-			removed++
-		}
+// CleanupCache removes expired entries from the cache
+func (a *AuthModule) CleanupCache() int {
+	if !a.IsCacheEnabled() {
+		return 0
 	}
 
+	removed := a.cache.cleanup(time.Now())
 	if removed > 0 {
 		a.logger.Debug("Cleaned up %d expired cache entries", removed)
 	}
@@ -170,21 +253,7 @@ func (a *AuthModule) CacheStats() (int, int) {
 		return 0, 0
 	}
 
-	now := time.Now()
-	total := 0
-	valid := 0
-
-	a.cacheMutex.RLock()
-	defer a.cacheMutex.RUnlock()
-
-	for _, entry := range a.cache {
-		total++
-		if now.Before(entry.Expiry) {
-			valid++
-		}
-	}
-
-	return total, valid
+	return a.cache.stats(time.Now())
 }
 
 // authenticateIMAP authenticates a user against the IMAP server
@@ -194,12 +263,10 @@ func (a *AuthModule) authenticateIMAP(username, password, requestID string) erro
 
 	// Connect to server with a timeout
 	dialer := &net.Dialer{Timeout: 30 * time.Second}
-	conn, err := tls.DialWithDialer(dialer, "tcp", a.serverAddress, &tls.Config{
-		InsecureSkipVerify: false,
-	})
+	conn, err := tls.DialWithDialer(dialer, "tcp", a.serverAddress, a.clientTLSConfig())
 	if err != nil {
 		log.Error("Failed to connect to IMAP server: %v", err)
-		return fmt.Errorf("failed to connect to IMAP server: %w", err)
+		return fmt.Errorf("failed to connect to IMAP server: %w", a.connectionFailed(err))
 	}
 	log.Debug("TLS connection established")
 
@@ -215,7 +282,8 @@ func (a *AuthModule) authenticateIMAP(username, password, requestID string) erro
 	log.Debug("Attempting IMAP login")
 	if err := c.Login(username, password); err != nil {
 		log.Error("IMAP login failed: %v", err)
-		return fmt.Errorf("IMAP authentication failed: %w", err)
+		conn.Close()
+		return fmt.Errorf("IMAP authentication failed: %w: %w", ErrInvalidCredentials, err)
 	}
 	log.Debug("IMAP login successful")
 
@@ -245,12 +313,10 @@ func (a *AuthModule) authenticateSMTP(username, password, requestID string) erro
 
 	// Create a TLS connection
 	log.Debug("Establishing TLS connection to SMTP server")
-	conn, err := tls.Dial("tcp", a.serverAddress, &tls.Config{
-		InsecureSkipVerify: false,
-	})
+	conn, err := tls.Dial("tcp", a.serverAddress, a.clientTLSConfig())
 	if err != nil {
 		log.Error("Failed to connect to SMTP server: %v", err)
-		return fmt.Errorf("failed to connect to SMTP server: %w", err)
+		return fmt.Errorf("failed to connect to SMTP server: %w", a.connectionFailed(err))
 	}
 	defer conn.Close()
 	log.Debug("TLS connection established")
@@ -269,7 +335,7 @@ func (a *AuthModule) authenticateSMTP(username, password, requestID string) erro
 	log.Debug("Attempting SMTP authentication")
 	if err := c.Auth(auth); err != nil {
 		log.Error("SMTP authentication failed: %v", err)
-		return fmt.Errorf("SMTP authentication failed: %w", err)
+		return fmt.Errorf("SMTP authentication failed: %w: %w", ErrInvalidCredentials, err)
 	}
 	log.Debug("SMTP authentication successful")
 
@@ -0,0 +1,300 @@
+package auth
+
+import (
+	"container/list"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultCacheMaxEntries bounds the auth cache unless configured otherwise
+const defaultCacheMaxEntries = 10000
+
+// cacheItem is an entry of the LRU auth cache
+type cacheItem struct {
+	key     string
+	userKey string // keyed hash of the username, used for invalidation
+	entry   AuthCache
+}
+
+// authCache is an LRU-bounded cache of successful authentications
+type authCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	items      map[string]*list.Element
+	order      *list.List // front is most recently used
+}
+
+func newAuthCache(maxEntries int) *authCache {
+	return &authCache{
+		maxEntries: maxEntries,
+		items:      make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+// get returns a cache entry and marks it as recently used
+func (c *authCache) get(key string) (AuthCache, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.items[key]
+	if !ok {
+		return AuthCache{}, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*cacheItem).entry, true
+}
+
+// set stores a cache entry, evicting the least recently used entries beyond the limit
+func (c *authCache) set(key, userKey string, entry AuthCache) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.items[key]; ok {
+		element.Value.(*cacheItem).entry = entry
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&cacheItem{key: key, userKey: userKey, entry: entry})
+	for c.maxEntries > 0 && c.order.Len() > c.maxEntries {
+		c.removeElement(c.order.Back())
+	}
+}
+
+func (c *authCache) removeElement(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.items, element.Value.(*cacheItem).key)
+}
+
+// invalidateUser removes all entries of a user
+func (c *authCache) invalidateUser(userKey string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	removed := 0
+	for element := c.order.Front(); element != nil; {
+		next := element.Next()
+		if element.Value.(*cacheItem).userKey == userKey {
+			c.removeElement(element)
+			removed++
+		}
+		element = next
+	}
+	return removed
+}
+
+// cleanup removes expired entries
+func (c *authCache) cleanup(now time.Time) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	removed := 0
+	for element := c.order.Front(); element != nil; {
+		next := element.Next()
+		if now.After(element.Value.(*cacheItem).entry.Expiry) {
+			c.removeElement(element)
+			removed++
+		}
+		element = next
+	}
+	return removed
+}
+
+// stats returns the number of total and still valid entries
+func (c *authCache) stats(now time.Time) (int, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	valid := 0
+	for _, element := range c.items {
+		if now.Before(element.Value.(*cacheItem).entry.Expiry) {
+			valid++
+		}
+	}
+	return len(c.items), valid
+}
+
+// SetCacheLimit bounds the number of cached authentications, 0 means unbounded
+func (a *AuthModule) SetCacheLimit(maxEntries int) {
+	a.cache.mu.Lock()
+	defer a.cache.mu.Unlock()
+
+	a.cache.maxEntries = maxEntries
+	for maxEntries > 0 && a.cache.order.Len() > maxEntries {
+		a.cache.removeElement(a.cache.order.Back())
+	}
+}
+
+// SetCacheSecret sets the key used to derive cache keys from credentials.
+// Without it a random per-process key is used, which prevents persisting the cache.
+func (a *AuthModule) SetCacheSecret(secret string) {
+	key := sha256.Sum256([]byte(secret))
+	a.cacheKey = key[:]
+	a.persistentKey = true
+}
+
+// credentialKey derives the cache key of a set of credentials.
+// Keys are HMACs so a memory dump does not allow offline password guessing.
+func (a *AuthModule) credentialKey(username, password string) string {
+	mac := hmac.New(sha256.New, a.cacheKey)
+	mac.Write([]byte("cred\x00" + username + "\x00" + password))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// userKey derives the key identifying all cache entries of a user
+func (a *AuthModule) userKey(username string) string {
+	mac := hmac.New(sha256.New, a.cacheKey)
+	mac.Write([]byte("user\x00" + strings.ToLower(username)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// InvalidateUser drops all cached authentications of a user, e.g. after a password change.
+// The snapshot is rewritten right away, so a restart cannot bring the entries back.
+func (a *AuthModule) InvalidateUser(username string) int {
+	removed := a.cache.invalidateUser(a.userKey(username))
+	a.logger.Info("Invalidated %d cached authentications for user %s", removed, maskUsername(username))
+
+	if removed > 0 && a.snapshotPath != "" {
+		if _, err := a.SaveCacheSnapshot(a.snapshotPath); err != nil {
+			a.logger.Error("Failed to save auth cache snapshot after invalidation: %v", err)
+		}
+	}
+	return removed
+}
+
+// SetCacheSnapshotPath sets the snapshot to rewrite when cached authentications are invalidated
+func (a *AuthModule) SetCacheSnapshotPath(path string) {
+	a.snapshotPath = path
+}
+
+// snapshotEntry is the persisted form of a cache entry
+type snapshotEntry struct {
+	Key      string    `json:"key"`
+	UserKey  string    `json:"user_key"`
+	Expiry   time.Time `json:"expiry"`
+	Username string    `json:"username,omitempty"`
+}
+
+// snapshotCipher returns the AEAD used to encrypt cache snapshots
+func (a *AuthModule) snapshotCipher() (cipher.AEAD, error) {
+	if !a.persistentKey {
+		return nil, fmt.Errorf("cache snapshots require a configured cache secret")
+	}
+
+	mac := hmac.New(sha256.New, a.cacheKey)
+	mac.Write([]byte("snapshot"))
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// SaveCacheSnapshot writes the valid cache entries encrypted to path
+func (a *AuthModule) SaveCacheSnapshot(path string) (int, error) {
+	aead, err := a.snapshotCipher()
+	if err != nil {
+		return 0, err
+	}
+
+	// Without serializing, a periodic save collecting entries before an invalidation
+	// could replace the snapshot written by the invalidation
+	a.snapshotMu.Lock()
+	defer a.snapshotMu.Unlock()
+
+	now := time.Now()
+	var entries []snapshotEntry
+	a.cache.mu.Lock()
+	for element := a.cache.order.Back(); element != nil; element = element.Prev() {
+		item := element.Value.(*cacheItem)
+		if now.Before(item.entry.Expiry) {
+			entries = append(entries, snapshotEntry{
+				Key:      item.key,
+				UserKey:  item.userKey,
+				Expiry:   item.entry.Expiry,
+				Username: item.entry.Username,
+			})
+		}
+	}
+	a.cache.mu.Unlock()
+
+	plaintext, err := json.Marshal(entries)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode cache snapshot: %w", err)
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return 0, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	data := aead.Seal(nonce, nonce, plaintext, nil)
+
+	// Write atomically so a crash never leaves a truncated snapshot behind
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".auth-cache-*")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create cache snapshot: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return 0, fmt.Errorf("failed to write cache snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, fmt.Errorf("failed to write cache snapshot: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return 0, fmt.Errorf("failed to replace cache snapshot: %w", err)
+	}
+
+	return len(entries), nil
+}
+
+// LoadCacheSnapshot restores the still valid cache entries from an encrypted snapshot
+func (a *AuthModule) LoadCacheSnapshot(path string) (int, error) {
+	aead, err := a.snapshotCipher()
+	if err != nil {
+		return 0, err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	if len(data) < aead.NonceSize() {
+		return 0, fmt.Errorf("cache snapshot is truncated")
+	}
+
+	plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to decrypt cache snapshot (changed secret?): %w", err)
+	}
+
+	var entries []snapshotEntry
+	if err := json.Unmarshal(plaintext, &entries); err != nil {
+		return 0, fmt.Errorf("failed to decode cache snapshot: %w", err)
+	}
+
+	now := time.Now()
+	restored := 0
+	for _, entry := range entries {
+		if now.Before(entry.Expiry) {
+			a.cache.set(entry.Key, entry.UserKey, AuthCache{Expiry: entry.Expiry, Username: entry.Username})
+			restored++
+		}
+	}
+	return restored, nil
+}
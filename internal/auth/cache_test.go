@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAuthCacheLRU(t *testing.T) {
+	cache := newAuthCache(2)
+	expiry := time.Now().Add(time.Minute)
+
+	cache.set("a", "u1", AuthCache{Expiry: expiry})
+	cache.set("b", "u1", AuthCache{Expiry: expiry})
+	cache.get("a") // a is now more recently used than b
+	cache.set("c", "u2", AuthCache{Expiry: expiry})
+
+	if _, ok := cache.get("b"); ok {
+		t.Error("least recently used entry was not evicted")
+	}
+	if _, ok := cache.get("a"); !ok {
+		t.Error("recently used entry was evicted")
+	}
+
+	if removed := cache.invalidateUser("u1"); removed != 1 {
+		t.Errorf("expected 1 invalidated entry, got %d", removed)
+	}
+	if total, _ := cache.stats(time.Now()); total != 1 {
+		t.Errorf("expected 1 remaining entry, got %d", total)
+	}
+}
+
+func TestCredentialKeysDependOnSecret(t *testing.T) {
+	first, _ := NewAuthModule("IMAP", "mail.example.com:993", 60)
+	second, _ := NewAuthModule("IMAP", "mail.example.com:993", 60)
+
+	if first.credentialKey("alice", "secret") == second.credentialKey("alice", "secret") {
+		t.Error("random per-process keys produced identical cache keys")
+	}
+
+	first.SetCacheSecret("shared")
+	second.SetCacheSecret("shared")
+	if first.credentialKey("alice", "secret") != second.credentialKey("alice", "secret") {
+		t.Error("configured secret did not produce stable cache keys")
+	}
+	if first.credentialKey("alice", "secret") == first.credentialKey("alice", "other") {
+		t.Error("different passwords produced identical cache keys")
+	}
+}
+
+func TestCacheSnapshotRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth-cache")
+
+	source, _ := NewAuthModule("IMAP", "mail.example.com:993", 60)
+	if _, err := source.SaveCacheSnapshot(path); err == nil {
+		t.Error("snapshot without configured secret was written")
+	}
+
+	source.SetCacheSecret("shared")
+	source.cache.set(source.credentialKey("alice", "secret"), source.userKey("alice"), AuthCache{Expiry: time.Now().Add(time.Minute)})
+	source.cache.set(source.credentialKey("bob", "secret"), source.userKey("bob"), AuthCache{Expiry: time.Now().Add(-time.Minute)})
+	if saved, err := source.SaveCacheSnapshot(path); err != nil || saved != 1 {
+		t.Fatalf("expected 1 saved entry, got %d: %v", saved, err)
+	}
+
+	restoredModule, _ := NewAuthModule("IMAP", "mail.example.com:993", 60)
+	restoredModule.SetCacheSecret("shared")
+	if restored, err := restoredModule.LoadCacheSnapshot(path); err != nil || restored != 1 {
+		t.Fatalf("expected 1 restored entry, got %d: %v", restored, err)
+	}
+	// Cached credentials are accepted without contacting the (unreachable) server
+	if err := restoredModule.Authenticate("alice", "secret", ""); err != nil {
+		t.Errorf("restored entry not used: %v", err)
+	}
+
+	wrongSecret, _ := NewAuthModule("IMAP", "mail.example.com:993", 60)
+	wrongSecret.SetCacheSecret("other")
+	if _, err := wrongSecret.LoadCacheSnapshot(path); err == nil {
+		t.Error("snapshot decrypted with the wrong secret")
+	}
+}
+
+func TestInvalidateUserRewritesSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth-cache")
+
+	source, _ := NewAuthModule("IMAP", "mail.example.com:993", 60)
+	source.SetCacheSecret("shared")
+	source.SetCacheSnapshotPath(path)
+	source.cache.set(source.credentialKey("alice", "secret"), source.userKey("alice"), AuthCache{Expiry: time.Now().Add(time.Minute)})
+	source.cache.set(source.credentialKey("bob", "secret"), source.userKey("bob"), AuthCache{Expiry: time.Now().Add(time.Minute)})
+	if _, err := source.SaveCacheSnapshot(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if removed := source.InvalidateUser("Alice"); removed != 1 {
+		t.Fatalf("expected 1 invalidated entry, got %d", removed)
+	}
+
+	// A restart before the next periodic save must not restore the invalidated entry
+	restoredModule, _ := NewAuthModule("IMAP", "mail.example.com:993", 60)
+	restoredModule.SetCacheSecret("shared")
+	if restored, err := restoredModule.LoadCacheSnapshot(path); err != nil || restored != 1 {
+		t.Fatalf("expected 1 restored entry, got %d: %v", restored, err)
+	}
+	if _, found := restoredModule.cache.get(restoredModule.credentialKey("alice", "secret")); found {
+		t.Error("invalidated entry restored from snapshot")
+	}
+}
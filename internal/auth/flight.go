@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrBusy is returned when no upstream authentication slot became available in time
+var ErrBusy = errors.New("too many concurrent authentications, try again later")
+
+// flightCall is an upstream authentication in progress
+type flightCall struct {
+	done     chan struct{}
+	username string
+	err      error
+}
+
+// flightGroup deduplicates concurrent upstream authentications of the same credentials,
+// so a burst of identical requests results in a single connection to the server
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{calls: make(map[string]*flightCall)}
+}
+
+// do runs fn once for all concurrent callers with the same key.
+// shared reports whether the result was produced by another caller.
+func (g *flightGroup) do(key string, fn func() (string, error)) (username string, shared bool, err error) {
+	g.mu.Lock()
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		<-call.done
+		return call.username, true, call.err
+	}
+
+	call := &flightCall{done: make(chan struct{})}
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(call.done)
+	}()
+
+	call.username, call.err = fn()
+	return call.username, false, call.err
+}
+
+// inFlight returns the number of distinct upstream authentications in progress
+func (g *flightGroup) inFlight() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.calls)
+}
+
+// SetMaxConcurrent limits the number of concurrent upstream authentications.
+// Callers wait up to wait for a free slot before failing with ErrBusy; 0 disables the limit.
+func (a *AuthModule) SetMaxConcurrent(limit int, wait time.Duration) {
+	if limit <= 0 {
+		a.upstreamSlots = nil
+		return
+	}
+	a.upstreamSlots = make(chan struct{}, limit)
+	a.upstreamWait = wait
+}
+
+// acquireUpstream reserves a slot for an upstream authentication, the returned function releases it
+func (a *AuthModule) acquireUpstream() (func(), error) {
+	if a.upstreamSlots == nil {
+		return func() {}, nil
+	}
+
+	timer := time.NewTimer(a.upstreamWait)
+	defer timer.Stop()
+
+	select {
+	case a.upstreamSlots <- struct{}{}:
+		return func() { <-a.upstreamSlots }, nil
+	case <-timer.C:
+		return nil, ErrBusy
+	}
+}
+
+// ConcurrencyStats returns the number of running upstream authentications and the configured limit
+func (a *AuthModule) ConcurrencyStats() (inFlight, active, limit int) {
+	if a.upstreamSlots != nil {
+		active = len(a.upstreamSlots)
+		limit = cap(a.upstreamSlots)
+	}
+	return a.flights.inFlight(), active, limit
+}
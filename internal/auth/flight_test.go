@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestConcurrentAuthenticationsAreCoalesced(t *testing.T) {
+	var requests int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		<-release
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "email": "alice@example.com", "active": 1})
+	}))
+	defer srv.Close()
+
+	authModule, err := NewAuthModule("OAUTH2", srv.URL, 0)
+	if err != nil {
+		t.Fatalf("NewAuthModule: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- authModule.Authenticate("alice@example.com", "token", "")
+		}()
+	}
+
+	// Wait until all callers joined the flight before letting the server answer
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if inFlight, _, _ := authModule.ConcurrencyStats(); inFlight == 1 && atomic.LoadInt32(&requests) == 1 {
+			break
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("authentication failed: %v", err)
+		}
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("expected a single upstream request, got %d", n)
+	}
+}
+
+func TestFailedFlightCountsOnce(t *testing.T) {
+	var requests int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		<-release
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_token"})
+	}))
+	defer srv.Close()
+
+	authModule, err := NewAuthModule("OAUTH2", srv.URL, 0)
+	if err != nil {
+		t.Fatalf("NewAuthModule: %v", err)
+	}
+	authModule.SetLockoutPolicy(LockoutPolicy{
+		UserThreshold:   2,
+		ClientThreshold: 2,
+		BaseDelay:       time.Minute,
+		MaxDelay:        time.Hour,
+		FailureCacheTTL: time.Minute,
+	})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- authModule.Authenticate("alice@example.com", "wrong", "192.0.2.1")
+		}()
+	}
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if inFlight, _, _ := authModule.ConcurrencyStats(); inFlight == 1 && atomic.LoadInt32(&requests) == 1 {
+			break
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("expected invalid credentials, got %v", err)
+		}
+	}
+	// One wrong password sent in parallel is a single failure, below the thresholds
+	if stats := authModule.LockoutStats(); stats.LockedUsers != 0 || stats.LockedClients != 0 || stats.TotalLockouts != 0 {
+		t.Errorf("parallel requests were counted as separate failures: %+v", stats)
+	}
+}
+
+func TestUpstreamLimit(t *testing.T) {
+	authModule, err := NewAuthModule("IMAP", "127.0.0.1:1", 0)
+	if err != nil {
+		t.Fatalf("NewAuthModule: %v", err)
+	}
+	authModule.SetMaxConcurrent(1, 20*time.Millisecond)
+
+	release, err := authModule.acquireUpstream()
+	if err != nil {
+		t.Fatalf("first slot not granted: %v", err)
+	}
+	if _, err := authModule.acquireUpstream(); !errors.Is(err, ErrBusy) {
+		t.Errorf("expected ErrBusy with all slots taken, got %v", err)
+	}
+	release()
+	if _, err := authModule.acquireUpstream(); err != nil {
+		t.Errorf("slot not granted after release: %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap/client"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// ErrUnavailable is returned when the auth server cannot be reached, and without contacting it
+// until CheckServer succeeds again. Cached authentications keep working.
+var ErrUnavailable = errors.New("authentication server unavailable")
+
+// probeTimeout bounds a check of the auth server
+const probeTimeout = 10 * time.Second
+
+// Available reports whether the auth server was reachable at the last contact
+func (a *AuthModule) Available() bool {
+	return !a.unavailable.Load()
+}
+
+// CheckServer connects to the auth server without logging in and records whether it is reachable
+func (a *AuthModule) CheckServer() error {
+	err := a.probeServer()
+	a.setAvailable(err)
+	return err
+}
+
+// probeServer opens and closes a connection to the auth server
+func (a *AuthModule) probeServer() error {
+	dialer := &net.Dialer{Timeout: probeTimeout}
+
+	switch strings.ToUpper(a.method) {
+	case "IMAP":
+		conn, err := tls.DialWithDialer(dialer, "tcp", a.serverAddress, a.clientTLSConfig())
+		if err != nil {
+			return err
+		}
+		// Wait for the greeting and log out, so the server sees a regular session
+		conn.SetDeadline(time.Now().Add(probeTimeout))
+		c, err := client.New(conn)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		return c.Logout()
+	case "SMTP":
+		conn, err := tls.DialWithDialer(dialer, "tcp", a.serverAddress, a.clientTLSConfig())
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case "LDAP":
+		var tlsConfig *tls.Config
+		if a.ldapOptions != nil {
+			tlsConfig = a.ldapOptions.TLSConfig
+		}
+		conn, err := ldap.DialURL(a.serverAddress, ldap.DialWithDialer(dialer), ldap.DialWithTLSConfig(tlsConfig))
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case "OAUTH2":
+		// Any response shows the server is up
+		resp, err := a.httpClient.Get(a.oauthBaseURL())
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	default:
+		return fmt.Errorf("unsupported authentication method: %s", a.method)
+	}
+}
+
+// setAvailable records whether the auth server could be reached and logs changes
+func (a *AuthModule) setAvailable(err error) {
+	wasUnavailable := a.unavailable.Swap(err != nil)
+	if err != nil && !wasUnavailable {
+		a.logger.Warn("Authentication server %s is unavailable, failing uncached logins until it recovers: %v", a.serverAddress, err)
+	} else if err == nil && wasUnavailable {
+		a.logger.Info("Authentication server %s is available again", a.serverAddress)
+	}
+}
+
+// connectionFailed records an unreachable auth server and returns the error to report
+func (a *AuthModule) connectionFailed(err error) error {
+	a.setAvailable(err)
+	return fmt.Errorf("%w: %w", ErrUnavailable, err)
+}
+
+// checkAvailable fails fast while the auth server is known to be unreachable
+func (a *AuthModule) checkAvailable() error {
+	if !a.Available() {
+		return fmt.Errorf("%w: %s", ErrUnavailable, a.serverAddress)
+	}
+	return nil
+}
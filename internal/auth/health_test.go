@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestUnavailableServerFailsFast(t *testing.T) {
+	var down atomic.Bool
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if down.Load() {
+			// Drop the connection like an unreachable server
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "email": "alice@example.com", "active": 1})
+	}))
+	defer srv.Close()
+
+	authModule, err := NewAuthModule("OAUTH2", srv.URL, 60)
+	if err != nil {
+		t.Fatalf("NewAuthModule: %v", err)
+	}
+	if err := authModule.CheckServer(); err != nil || !authModule.Available() {
+		t.Fatalf("CheckServer = %v", err)
+	}
+	if err := authModule.Authenticate("alice@example.com", "cached", ""); err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+
+	down.Store(true)
+	if err := authModule.Authenticate("alice@example.com", "other", ""); !errors.Is(err, ErrUnavailable) || authModule.Available() {
+		t.Fatalf("expected ErrUnavailable for a dropped connection, got %v", err)
+	}
+	before := requests.Load()
+	if err := authModule.Authenticate("alice@example.com", "third", ""); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("expected uncached logins to fail fast, got %v", err)
+	}
+	if requests.Load() != before {
+		t.Error("authentication reached the server while it was unavailable")
+	}
+	if err := authModule.Authenticate("alice@example.com", "cached", ""); err != nil {
+		t.Errorf("expected the cached login to keep working, got %v", err)
+	}
+	// An unreachable server is no reason to lock anyone out
+	if stats := authModule.LockoutStats(); stats.FailedCreds != 0 {
+		t.Errorf("unavailability counted as failed login: %+v", stats)
+	}
+
+	down.Store(false)
+	if err := authModule.CheckServer(); err != nil || !authModule.Available() {
+		t.Fatalf("expected the server to recover, got %v", err)
+	}
+	if err := authModule.Authenticate("alice@example.com", "other", ""); err != nil {
+		t.Errorf("Authenticate after recovery: %v", err)
+	}
+}
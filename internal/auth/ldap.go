@@ -0,0 +1,218 @@
+package auth
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// ErrForbidden is returned when a user authenticated successfully but may not create aliases
+var ErrForbidden = errors.New("user is not permitted to create aliases")
+
+// LDAPOptions configures the LDAP authentication method.
+//
+// Templates and filters support the placeholders %u (full username), %l (local part)
+// and %d (domain). The group filter additionally supports %D (DN of the user).
+type LDAPOptions struct {
+	StartTLS bool
+	// BindDNTemplate binds directly as the expanded DN, e.g. "uid=%l,ou=people,dc=example,dc=com"
+	BindDNTemplate string
+	// Search-then-bind: the user DN is looked up below BaseDN using UserFilter
+	SearchBindDN       string
+	SearchBindPassword string
+	BaseDN             string
+	UserFilter         string
+	// GroupFilter must match at least one entry below GroupBaseDN for the user to be permitted
+	GroupFilter string
+	GroupBaseDN string
+	// TLSConfig overrides the TLS configuration used for LDAPS and StartTLS
+	TLSConfig *tls.Config
+}
+
+// SetLDAPOptions configures the LDAP authentication method
+func (a *AuthModule) SetLDAPOptions(opts LDAPOptions) error {
+	serverURL, err := url.Parse(a.serverAddress)
+	if err != nil {
+		return fmt.Errorf("invalid LDAP server URL: %w", err)
+	}
+
+	switch strings.ToLower(serverURL.Scheme) {
+	case "ldaps":
+	case "ldap":
+		// Passwords must never be sent in plain text
+		if !opts.StartTLS {
+			return fmt.Errorf("ldap:// server URLs require StartTLS, use ldaps:// otherwise")
+		}
+	default:
+		return fmt.Errorf("LDAP server URL must use the ldaps:// or ldap:// scheme")
+	}
+
+	if opts.BindDNTemplate == "" && opts.BaseDN == "" {
+		return fmt.Errorf("either a bind DN template or a base DN for search-then-bind must be set")
+	}
+	if opts.UserFilter == "" {
+		opts.UserFilter = "(mail=%u)"
+	}
+	if opts.GroupBaseDN == "" {
+		opts.GroupBaseDN = opts.BaseDN
+	}
+	if opts.GroupFilter != "" && opts.GroupBaseDN == "" {
+		return fmt.Errorf("a group filter requires a group base DN")
+	}
+	if opts.TLSConfig == nil {
+		opts.TLSConfig = &tls.Config{ServerName: serverURL.Hostname()}
+	}
+
+	a.ldapOptions = &opts
+	return nil
+}
+
+// expandLDAPTemplate replaces the username placeholders of a template using the given escape function
+func expandLDAPTemplate(template, username, userDN string, escape func(string) string) string {
+	localPart, domain := username, ""
+	if at := strings.LastIndex(username, "@"); at >= 0 {
+		localPart, domain = username[:at], username[at+1:]
+	}
+
+	replacer := strings.NewReplacer(
+		"%u", escape(username),
+		"%l", escape(localPart),
+		"%d", escape(domain),
+		"%D", escape(userDN),
+	)
+	return replacer.Replace(template)
+}
+
+// authenticateLDAP authenticates a user with a simple bind against the LDAP server
+func (a *AuthModule) authenticateLDAP(username, password, requestID string) error {
+	log := a.logger.WithRequestID(requestID)
+
+	opts := a.ldapOptions
+	if opts == nil {
+		return fmt.Errorf("LDAP authentication is not configured")
+	}
+
+	// An empty password would result in an unauthenticated bind, which always succeeds
+	if password == "" {
+		return fmt.Errorf("LDAP authentication failed: %w: empty password", ErrInvalidCredentials)
+	}
+
+	log.Debug("Connecting to LDAP server")
+	conn, err := ldap.DialURL(a.serverAddress,
+		ldap.DialWithDialer(&net.Dialer{Timeout: 30 * time.Second}),
+		ldap.DialWithTLSConfig(opts.TLSConfig),
+	)
+	if err != nil {
+		log.Error("Failed to connect to LDAP server: %v", err)
+		return fmt.Errorf("failed to connect to LDAP server: %w", a.connectionFailed(err))
+	}
+	defer conn.Close()
+	conn.SetTimeout(30 * time.Second)
+
+	if opts.StartTLS {
+		log.Debug("Upgrading LDAP connection with StartTLS")
+		if err := conn.StartTLS(opts.TLSConfig); err != nil {
+			log.Error("LDAP StartTLS failed: %v", err)
+			return fmt.Errorf("LDAP StartTLS failed: %w", err)
+		}
+	}
+
+	var userDN string
+	if opts.BindDNTemplate != "" {
+		userDN = expandLDAPTemplate(opts.BindDNTemplate, username, "", ldap.EscapeDN)
+	} else {
+		userDN, err = a.searchLDAPUser(conn, username, requestID)
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Debug("Attempting LDAP bind as %s", userDN)
+	if err := conn.Bind(userDN, password); err != nil {
+		log.Error("LDAP bind failed: %v", err)
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return fmt.Errorf("LDAP authentication failed: %w: %w", ErrInvalidCredentials, err)
+		}
+		return fmt.Errorf("LDAP authentication failed: %w", err)
+	}
+	log.Debug("LDAP bind successful")
+
+	if opts.GroupFilter != "" {
+		if err := a.checkLDAPGroup(conn, username, userDN, requestID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// searchLDAPUser looks up the DN of a user, binding with the search account if configured
+func (a *AuthModule) searchLDAPUser(conn *ldap.Conn, username, requestID string) (string, error) {
+	log := a.logger.WithRequestID(requestID)
+	opts := a.ldapOptions
+
+	if opts.SearchBindDN != "" {
+		if err := conn.Bind(opts.SearchBindDN, opts.SearchBindPassword); err != nil {
+			log.Error("LDAP search bind failed: %v", err)
+			return "", fmt.Errorf("LDAP search bind failed: %w", err)
+		}
+	}
+
+	filter := expandLDAPTemplate(opts.UserFilter, username, "", ldap.EscapeFilter)
+	log.Debug("Searching LDAP user with filter %s", filter)
+
+	result, err := conn.Search(ldap.NewSearchRequest(
+		opts.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 30, false,
+		filter, []string{"dn"}, nil,
+	))
+	if err != nil {
+		log.Error("LDAP user search failed: %v", err)
+		return "", fmt.Errorf("LDAP user search failed: %w", err)
+	}
+
+	if len(result.Entries) != 1 {
+		log.Warn("LDAP user search returned %d entries", len(result.Entries))
+		return "", fmt.Errorf("LDAP authentication failed: %w: user not found or ambiguous", ErrInvalidCredentials)
+	}
+
+	return result.Entries[0].DN, nil
+}
+
+// checkLDAPGroup verifies the user matches the configured group filter
+func (a *AuthModule) checkLDAPGroup(conn *ldap.Conn, username, userDN, requestID string) error {
+	log := a.logger.WithRequestID(requestID)
+	opts := a.ldapOptions
+
+	// Prefer the search account for the group lookup, users may not be able to read groups
+	if opts.SearchBindDN != "" {
+		if err := conn.Bind(opts.SearchBindDN, opts.SearchBindPassword); err != nil {
+			log.Error("LDAP search bind failed: %v", err)
+			return fmt.Errorf("LDAP search bind failed: %w", err)
+		}
+	}
+
+	filter := expandLDAPTemplate(opts.GroupFilter, username, userDN, ldap.EscapeFilter)
+	log.Debug("Checking LDAP group membership with filter %s", filter)
+
+	result, err := conn.Search(ldap.NewSearchRequest(
+		opts.GroupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 1, 30, false,
+		filter, []string{"dn"}, nil,
+	))
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		log.Error("LDAP group search failed: %v", err)
+		return fmt.Errorf("LDAP group search failed: %w", err)
+	}
+
+	if result == nil || len(result.Entries) == 0 {
+		log.Warn("User %s is not a member of the permitted LDAP group", maskUsername(username))
+		return ErrForbidden
+	}
+
+	return nil
+}
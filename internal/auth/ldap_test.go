@@ -0,0 +1,215 @@
+package auth
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// fakeLDAPServer is a minimal in-process LDAPS server supporting simple binds and searches.
+// Searches are answered from a fixed table of filter -> DNs.
+type fakeLDAPServer struct {
+	listener  net.Listener
+	passwords map[string]string   // DN -> password
+	searches  map[string][]string // filter -> matching DNs
+	tlsConfig *tls.Config         // client configuration trusting the server
+
+	mu      sync.Mutex
+	filters []string
+}
+
+func newFakeLDAPServer(t *testing.T, passwords map[string]string, searches map[string][]string) *fakeLDAPServer {
+	// Borrow a certificate trusted by the httptest client configuration
+	certSrv := httptest.NewTLSServer(http.NotFoundHandler())
+	serverTLS := certSrv.TLS.Clone()
+	clientTLS := certSrv.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	clientTLS.ServerName = "example.com"
+	certSrv.Close()
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", serverTLS)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	srv := &fakeLDAPServer{
+		listener:  listener,
+		passwords: passwords,
+		searches:  searches,
+		tlsConfig: clientTLS,
+	}
+	go srv.serve()
+	t.Cleanup(func() { listener.Close() })
+	return srv
+}
+
+func (s *fakeLDAPServer) url() string {
+	return "ldaps://" + s.listener.Addr().String()
+}
+
+func (s *fakeLDAPServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeLDAPServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		messageID := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			dn := string(op.Children[1].Data.Bytes())
+			password := string(op.Children[2].Data.Bytes())
+			code := int64(ldap.LDAPResultInvalidCredentials)
+			if expected, ok := s.passwords[dn]; ok && expected == password {
+				code = ldap.LDAPResultSuccess
+			}
+			conn.Write(ldapResponse(messageID, ldap.ApplicationBindResponse, code).Bytes())
+		case ldap.ApplicationSearchRequest:
+			filter, _ := ldap.DecompileFilter(op.Children[6])
+			s.mu.Lock()
+			s.filters = append(s.filters, filter)
+			s.mu.Unlock()
+			for _, dn := range s.searches[filter] {
+				entry := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+				entry.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "MessageID"))
+				result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Entry")
+				result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, "DN"))
+				result.AppendChild(ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes"))
+				entry.AppendChild(result)
+				conn.Write(entry.Bytes())
+			}
+			conn.Write(ldapResponse(messageID, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess).Bytes())
+		case ldap.ApplicationUnbindRequest:
+			return
+		default:
+			return
+		}
+	}
+}
+
+// ldapResponse builds an LDAPResult message of the given application type
+func ldapResponse(messageID int64, application ber.Tag, code int64) *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "MessageID"))
+	result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, application, nil, "Result")
+	result.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, "resultCode"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "diagnosticMessage"))
+	packet.AppendChild(result)
+	return packet
+}
+
+func TestLDAPBindDNTemplate(t *testing.T) {
+	srv := newFakeLDAPServer(t,
+		map[string]string{"uid=alice,ou=people,dc=example,dc=com": "secret"},
+		nil,
+	)
+
+	authModule, err := NewAuthModule("LDAP", srv.url(), 0)
+	if err != nil {
+		t.Fatalf("NewAuthModule: %v", err)
+	}
+	err = authModule.SetLDAPOptions(LDAPOptions{
+		BindDNTemplate: "uid=%l,ou=people,dc=example,dc=com",
+		TLSConfig:      srv.tlsConfig,
+	})
+	if err != nil {
+		t.Fatalf("SetLDAPOptions: %v", err)
+	}
+
+	if err := authModule.Authenticate("alice@example.com", "secret", ""); err != nil {
+		t.Errorf("valid credentials rejected: %v", err)
+	}
+	if err := authModule.Authenticate("alice@example.com", "wrong", ""); err == nil {
+		t.Error("wrong password accepted")
+	}
+	if err := authModule.Authenticate("alice@example.com", "", ""); err == nil {
+		t.Error("empty password accepted")
+	}
+}
+
+func TestLDAPSearchThenBindWithGroup(t *testing.T) {
+	srv := newFakeLDAPServer(t,
+		map[string]string{
+			"cn=bridge,dc=example,dc=com":           "service",
+			"uid=alice,ou=people,dc=example,dc=com": "secret",
+			"uid=bob,ou=people,dc=example,dc=com":   "secret",
+		},
+		map[string][]string{
+			"(mail=alice@example.com)": {"uid=alice,ou=people,dc=example,dc=com"},
+			"(mail=bob@example.com)":   {"uid=bob,ou=people,dc=example,dc=com"},
+			"(&(cn=aliases)(member=uid=alice,ou=people,dc=example,dc=com))": {"cn=aliases,ou=groups,dc=example,dc=com"},
+		},
+	)
+
+	authModule, err := NewAuthModule("LDAP", srv.url(), 0)
+	if err != nil {
+		t.Fatalf("NewAuthModule: %v", err)
+	}
+	err = authModule.SetLDAPOptions(LDAPOptions{
+		SearchBindDN:       "cn=bridge,dc=example,dc=com",
+		SearchBindPassword: "service",
+		BaseDN:             "dc=example,dc=com",
+		GroupFilter:        "(&(cn=aliases)(member=%D))",
+		TLSConfig:          srv.tlsConfig,
+	})
+	if err != nil {
+		t.Fatalf("SetLDAPOptions: %v", err)
+	}
+
+	if err := authModule.Authenticate("alice@example.com", "secret", ""); err != nil {
+		t.Errorf("group member rejected: %v", err)
+	}
+	if err := authModule.Authenticate("bob@example.com", "secret", ""); err != ErrForbidden {
+		t.Errorf("expected ErrForbidden for non-member, got %v", err)
+	}
+	if err := authModule.Authenticate("carol@example.com", "secret", ""); err == nil {
+		t.Error("unknown user accepted")
+	}
+
+	// Filter values must be escaped
+	authModule.Authenticate("*)(uid=*", "secret", "")
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	for _, filter := range srv.filters {
+		if strings.Contains(filter, "(uid=*") {
+			t.Errorf("unescaped filter sent to server: %s", filter)
+		}
+	}
+}
+
+func TestLDAPOptionsValidation(t *testing.T) {
+	authModule, err := NewAuthModule("LDAP", "ldap://ldap.example.com:389", 0)
+	if err != nil {
+		t.Fatalf("NewAuthModule: %v", err)
+	}
+
+	if err := authModule.SetLDAPOptions(LDAPOptions{BaseDN: "dc=example,dc=com"}); err == nil {
+		t.Error("plain ldap:// without StartTLS accepted")
+	}
+	if err := authModule.SetLDAPOptions(LDAPOptions{StartTLS: true}); err == nil {
+		t.Error("options without bind DN template or base DN accepted")
+	}
+	if err := authModule.SetLDAPOptions(LDAPOptions{StartTLS: true, BaseDN: "dc=example,dc=com"}); err != nil {
+		t.Errorf("valid StartTLS options rejected: %v", err)
+	}
+}
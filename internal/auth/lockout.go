@@ -0,0 +1,218 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrInvalidCredentials marks authentication failures caused by wrong credentials,
+// as opposed to failures reaching the authentication server
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// LockedError is returned while a username or client IP is locked out
+type LockedError struct {
+	Subject    string // "user" or "client"
+	RetryAfter time.Duration
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("too many failed attempts for this %s, retry after %s", e.Subject, e.RetryAfter.Round(time.Second))
+}
+
+// LockoutPolicy configures brute-force protection
+type LockoutPolicy struct {
+	UserThreshold   int           // failures per username before locking, 0 disables
+	ClientThreshold int           // failures per client IP before locking, 0 disables
+	BaseDelay       time.Duration // first lockout duration, doubled on every further failure
+	MaxDelay        time.Duration // upper bound of a lockout, also the time after which failures are forgotten
+	FailureCacheTTL time.Duration // how long failed credentials are rejected without asking the server
+}
+
+// failureCounter tracks consecutive failures of a username or client IP
+type failureCounter struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// lockoutTracker keeps failure counters and the negative credential cache
+type lockoutTracker struct {
+	policy   LockoutPolicy
+	mu       sync.Mutex
+	users    map[string]*failureCounter
+	clients  map[string]*failureCounter
+	negative map[string]time.Time // failed credential hash -> expiry
+	lockouts int
+}
+
+func newLockoutTracker() *lockoutTracker {
+	return &lockoutTracker{
+		users:    make(map[string]*failureCounter),
+		clients:  make(map[string]*failureCounter),
+		negative: make(map[string]time.Time),
+	}
+}
+
+// SetLockoutPolicy configures brute-force protection
+func (a *AuthModule) SetLockoutPolicy(policy LockoutPolicy) {
+	if policy.BaseDelay <= 0 {
+		policy.BaseDelay = time.Minute
+	}
+	if policy.MaxDelay < policy.BaseDelay {
+		policy.MaxDelay = policy.BaseDelay
+	}
+
+	a.lockout.mu.Lock()
+	a.lockout.policy = policy
+	a.lockout.mu.Unlock()
+}
+
+// check returns a LockedError if the username or client is currently locked
+func (t *lockoutTracker) check(username, clientIP string, now time.Time) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if counter, ok := t.users[username]; ok && now.Before(counter.lockedUntil) {
+		return &LockedError{Subject: "user", RetryAfter: counter.lockedUntil.Sub(now)}
+	}
+	if counter, ok := t.clients[clientIP]; ok && now.Before(counter.lockedUntil) {
+		return &LockedError{Subject: "client", RetryAfter: counter.lockedUntil.Sub(now)}
+	}
+	return nil
+}
+
+// isKnownFailure reports whether the credential hash failed recently
+func (t *lockoutTracker) isKnownFailure(credHash string, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	expiry, ok := t.negative[credHash]
+	return ok && now.Before(expiry)
+}
+
+// recordFailure counts a failed attempt and returns the resulting lockout durations
+func (t *lockoutTracker) recordFailure(username, clientIP, credHash string, now time.Time) (userLock, clientLock time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.policy.FailureCacheTTL > 0 && credHash != "" {
+		t.negative[credHash] = now.Add(t.policy.FailureCacheTTL)
+	}
+
+	if username != "" {
+		userLock = t.count(t.users, username, t.policy.UserThreshold, now)
+	}
+	if clientIP != "" {
+		clientLock = t.count(t.clients, clientIP, t.policy.ClientThreshold, now)
+	}
+	return userLock, clientLock
+}
+
+// count increments a failure counter and locks it once the threshold is reached
+func (t *lockoutTracker) count(counters map[string]*failureCounter, key string, threshold int, now time.Time) time.Duration {
+	if threshold <= 0 {
+		return 0
+	}
+
+	counter, ok := counters[key]
+	if !ok || now.Sub(counter.lastFailure) > t.policy.MaxDelay {
+		counter = &failureCounter{}
+		counters[key] = counter
+	}
+	counter.failures++
+	counter.lastFailure = now
+
+	if counter.failures < threshold {
+		return 0
+	}
+
+	// Exponential backoff, doubling for every failure past the threshold
+	delay := t.policy.BaseDelay
+	for i := threshold; i < counter.failures && delay < t.policy.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > t.policy.MaxDelay {
+		delay = t.policy.MaxDelay
+	}
+
+	counter.lockedUntil = now.Add(delay)
+	t.lockouts++
+	return delay
+}
+
+// recordSuccess resets the failure counter of a username
+func (t *lockoutTracker) recordSuccess(username string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.users, username)
+}
+
+// cleanup removes expired counters and negative cache entries
+func (t *lockoutTracker) cleanup(now time.Time) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	removed := 0
+	for _, counters := range []map[string]*failureCounter{t.users, t.clients} {
+		for key, counter := range counters {
+			if now.After(counter.lockedUntil) && now.Sub(counter.lastFailure) > t.policy.MaxDelay {
+				delete(counters, key)
+				removed++
+			}
+		}
+	}
+	for key, expiry := range t.negative {
+		if now.After(expiry) {
+			delete(t.negative, key)
+			removed++
+		}
+	}
+	return removed
+}
+
+// LockoutStats describes the current brute-force protection state
+type LockoutStats struct {
+	LockedUsers     int
+	LockedClients   int
+	FailedCreds     int // entries in the negative cache
+	TotalLockouts   int // lockouts triggered since startup
+	TrackedCounters int
+}
+
+// LockoutStats returns statistics about locked usernames and clients
+func (a *AuthModule) LockoutStats() LockoutStats {
+	t := a.lockout
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stats := LockoutStats{
+		FailedCreds:     len(t.negative),
+		TotalLockouts:   t.lockouts,
+		TrackedCounters: len(t.users) + len(t.clients),
+	}
+	for _, counter := range t.users {
+		if now.Before(counter.lockedUntil) {
+			stats.LockedUsers++
+		}
+	}
+	for _, counter := range t.clients {
+		if now.Before(counter.lockedUntil) {
+			stats.LockedClients++
+		}
+	}
+	return stats
+}
+
+// CleanupLockouts removes expired failure counters and negative cache entries
+func (a *AuthModule) CleanupLockouts() int {
+	removed := a.lockout.cleanup(time.Now())
+	if removed > 0 {
+		a.logger.Debug("Cleaned up %d expired lockout entries", removed)
+	}
+	return removed
+}
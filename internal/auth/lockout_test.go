@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLockoutExponentialBackoff(t *testing.T) {
+	tracker := newLockoutTracker()
+	tracker.policy = LockoutPolicy{UserThreshold: 3, BaseDelay: time.Minute, MaxDelay: 5 * time.Minute}
+	now := time.Now()
+
+	expected := []time.Duration{0, 0, time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute}
+	for i, want := range expected {
+		userLock, _ := tracker.recordFailure("alice", "", "", now)
+		if userLock != want {
+			t.Errorf("failure %d: expected lockout %s, got %s", i+1, want, userLock)
+		}
+	}
+
+	var lockedErr *LockedError
+	if err := tracker.check("alice", "", now); !errors.As(err, &lockedErr) || lockedErr.Subject != "user" {
+		t.Errorf("expected user lockout, got %v", err)
+	}
+	if err := tracker.check("alice", "", now.Add(6*time.Minute)); err != nil {
+		t.Errorf("lockout did not expire: %v", err)
+	}
+
+	tracker.recordSuccess("alice")
+	if err := tracker.check("alice", "", now); err != nil {
+		t.Errorf("success did not reset the lockout: %v", err)
+	}
+}
+
+func TestLockoutRejectsBeforeServer(t *testing.T) {
+	srv := newFakeOAuthServer(t, map[string]string{"token-alice": "alice@example.com"}, nil)
+
+	authModule, err := NewAuthModule("OAUTH2", srv.URL, 60)
+	if err != nil {
+		t.Fatalf("NewAuthModule: %v", err)
+	}
+	authModule.SetLockoutPolicy(LockoutPolicy{
+		UserThreshold:   10,
+		ClientThreshold: 2,
+		BaseDelay:       time.Minute,
+		MaxDelay:        time.Hour,
+		FailureCacheTTL: time.Minute,
+	})
+
+	for i := 0; i < 2; i++ {
+		err := authModule.Authenticate("alice@example.com", "wrong", "192.0.2.1")
+		if !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("attempt %d: expected invalid credentials, got %v", i+1, err)
+		}
+	}
+
+	// Even correct credentials are rejected while the client is locked
+	var lockedErr *LockedError
+	err = authModule.Authenticate("alice@example.com", "token-alice", "192.0.2.1")
+	if !errors.As(err, &lockedErr) || lockedErr.RetryAfter <= 0 {
+		t.Errorf("expected client lockout, got %v", err)
+	}
+
+	// Other clients are unaffected
+	if err := authModule.Authenticate("alice@example.com", "token-alice", "192.0.2.2"); err != nil {
+		t.Errorf("other client rejected: %v", err)
+	}
+
+	stats := authModule.LockoutStats()
+	if stats.LockedClients != 1 || stats.FailedCreds != 1 || stats.TotalLockouts != 1 {
+		t.Errorf("unexpected lockout stats: %+v", stats)
+	}
+}
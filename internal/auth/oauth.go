@@ -0,0 +1,252 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/logger"
+)
+
+// OAuthToken is the token response of Mailcow's OAuth2 token endpoint
+type OAuthToken struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// oauthProfile is the subset of Mailcow's /oauth/profile response used by the bridge
+type oauthProfile struct {
+	Success  bool            `json:"success"`
+	Username string          `json:"username"`
+	Email    string          `json:"email"`
+	Active   json.RawMessage `json:"active"`
+}
+
+// isActive reports whether the profile belongs to an active mailbox.
+// Mailcow encodes the flag either as a number or as a string.
+func (p *oauthProfile) isActive() bool {
+	switch strings.Trim(string(p.Active), `"`) {
+	case "0", "false":
+		return false
+	}
+	return true
+}
+
+// identity returns the mailbox address of the profile
+func (p *oauthProfile) identity() string {
+	if p.Email != "" {
+		return p.Email
+	}
+	return p.Username
+}
+
+// oauthBaseURL returns the Mailcow base URL used for OAuth2 requests
+func (a *AuthModule) oauthBaseURL() string {
+	return strings.TrimRight(a.serverAddress, "/")
+}
+
+// OAuthAuthorizeURL returns the Mailcow authorization URL starting the authorization-code flow
+func (a *AuthModule) OAuthAuthorizeURL(clientID, redirectURL, state string) string {
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", clientID)
+	params.Set("redirect_uri", redirectURL)
+	params.Set("state", state)
+	return a.oauthBaseURL() + "/oauth/authorize?" + params.Encode()
+}
+
+// ExchangeOAuthCode exchanges an authorization code for an access token
+func (a *AuthModule) ExchangeOAuthCode(code, clientID, clientSecret, redirectURL string) (*OAuthToken, error) {
+	requestID := fmt.Sprintf("AUTH-%d", time.Now().UnixNano())
+	log := a.logger.WithRequestID(requestID)
+
+	log.Debug("Exchanging OAuth2 authorization code")
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("client_id", clientID)
+	form.Set("client_secret", clientSecret)
+	form.Set("redirect_uri", redirectURL)
+
+	startTime := time.Now()
+	resp, err := a.httpClient.PostForm(a.oauthBaseURL()+"/oauth/token", form)
+	duration := time.Since(startTime)
+	if err != nil {
+		log.Error("OAuth2 token request failed (took %s): %v", logger.FormatDuration(duration), err)
+		return nil, fmt.Errorf("OAuth2 token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Error("Failed to read OAuth2 token response: %v", err)
+		return nil, fmt.Errorf("failed to read OAuth2 token response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		log.Warn("OAuth2 token request rejected with status %d: %s", resp.StatusCode, string(body))
+		return nil, fmt.Errorf("OAuth2 token request rejected with status %d", resp.StatusCode)
+	}
+
+	var token OAuthToken
+	if err := json.Unmarshal(body, &token); err != nil {
+		log.Error("Failed to decode OAuth2 token response: %v", err)
+		return nil, fmt.Errorf("failed to decode OAuth2 token response: %w", err)
+	}
+	if token.AccessToken == "" {
+		return nil, fmt.Errorf("OAuth2 token response did not contain an access token")
+	}
+
+	log.Debug("OAuth2 authorization code exchanged successfully (took %s)", logger.FormatDuration(duration))
+	return &token, nil
+}
+
+// AuthenticateToken validates a Mailcow OAuth2 access token and returns the mailbox it belongs to.
+// clientIP is used for brute-force protection and may be empty.
+func (a *AuthModule) AuthenticateToken(token, clientIP string) (string, error) {
+	requestID := fmt.Sprintf("AUTH-%d", time.Now().UnixNano())
+	log := a.logger.WithRequestID(requestID)
+
+	if strings.ToUpper(a.method) != "OAUTH2" {
+		return "", fmt.Errorf("token authentication requires the OAUTH2 method")
+	}
+	if token == "" {
+		return "", fmt.Errorf("empty access token")
+	}
+
+	// Tokens are cached under their own namespace so they never collide with passwords
+	credHash := a.credentialKey("\x00oauth", token)
+
+	if err := a.lockout.check("", clientIP, time.Now()); err != nil {
+		log.Warn("Rejecting token validation from %s: %v", clientIP, err)
+		return "", err
+	}
+	if a.lockout.isKnownFailure(credHash, time.Now()) {
+		log.Debug("Rejecting recently failed access token")
+		a.recordFailure("", clientIP, credHash, log)
+		return "", fmt.Errorf("token validation failed: %w", ErrInvalidCredentials)
+	}
+
+	if a.IsCacheEnabled() {
+		cacheEntry, found := a.cache.get(credHash)
+
+		if found && time.Now().Before(cacheEntry.Expiry) {
+			log.Debug("Using cached token validation (valid until %s)", cacheEntry.Expiry.Format(time.RFC3339))
+			return cacheEntry.Username, nil
+		}
+	}
+
+	startTime := time.Now()
+	username, shared, err := a.flights.do(credHash, func() (string, error) {
+		if err := a.checkAvailable(); err != nil {
+			return "", err
+		}
+		release, err := a.acquireUpstream()
+		if err != nil {
+			return "", err
+		}
+		defer release()
+
+		profile, err := a.fetchOAuthProfile(token, requestID)
+		if err != nil {
+			return "", err
+		}
+		return profile.identity(), nil
+	})
+	duration := time.Since(startTime)
+	if shared {
+		log.Debug("Joined in-flight token validation")
+	}
+	if err != nil {
+		log.Error("Token validation failed after %s: %v", logger.FormatDuration(duration), err)
+		if errors.Is(err, ErrInvalidCredentials) {
+			a.recordFailure("", clientIP, credHash, log)
+		}
+		return "", err
+	}
+
+	if a.IsCacheEnabled() {
+		expiry := time.Now().Add(a.cacheTTL)
+		a.cache.set(credHash, a.userKey(username), AuthCache{
+			Expiry:   expiry,
+			Username: username,
+		})
+	}
+
+	log.Info("Token validated for user %s (took %s)", maskUsername(username), logger.FormatDuration(duration))
+	return username, nil
+}
+
+// authenticateOAuth authenticates a user whose password is a Mailcow OAuth2 access token
+func (a *AuthModule) authenticateOAuth(username, token, requestID string) error {
+	log := a.logger.WithRequestID(requestID)
+
+	profile, err := a.fetchOAuthProfile(token, requestID)
+	if err != nil {
+		return err
+	}
+
+	if !strings.EqualFold(profile.identity(), username) {
+		log.Warn("OAuth2 token belongs to a different mailbox than %s", maskUsername(username))
+		return fmt.Errorf("OAuth2 token does not belong to user: %w", ErrInvalidCredentials)
+	}
+
+	return nil
+}
+
+// fetchOAuthProfile resolves an access token through Mailcow's profile endpoint
+func (a *AuthModule) fetchOAuthProfile(token, requestID string) (*oauthProfile, error) {
+	log := a.logger.WithRequestID(requestID)
+	log.Debug("Requesting OAuth2 profile from %s", a.oauthBaseURL())
+
+	req, err := http.NewRequest("GET", a.oauthBaseURL()+"/oauth/profile", nil)
+	if err != nil {
+		log.Error("Failed to create profile request: %v", err)
+		return nil, fmt.Errorf("failed to create profile request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		log.Error("OAuth2 profile request failed: %v", err)
+		return nil, fmt.Errorf("OAuth2 profile request failed: %w", a.connectionFailed(err))
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Error("Failed to read OAuth2 profile response: %v", err)
+		return nil, fmt.Errorf("failed to read OAuth2 profile response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		log.Debug("OAuth2 profile request rejected with status %d: %s", resp.StatusCode, string(body))
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+			return nil, fmt.Errorf("OAuth2 token rejected with status %d: %w", resp.StatusCode, ErrInvalidCredentials)
+		}
+		return nil, fmt.Errorf("OAuth2 token rejected with status %d", resp.StatusCode)
+	}
+
+	var profile oauthProfile
+	if err := json.Unmarshal(body, &profile); err != nil {
+		log.Error("Failed to decode OAuth2 profile response: %v", err)
+		return nil, fmt.Errorf("failed to decode OAuth2 profile response: %w", err)
+	}
+
+	if !profile.Success || profile.identity() == "" {
+		return nil, fmt.Errorf("OAuth2 profile lookup was not successful")
+	}
+	if !profile.isActive() {
+		return nil, fmt.Errorf("mailbox of OAuth2 token is inactive: %w", ErrInvalidCredentials)
+	}
+
+	return &profile, nil
+}
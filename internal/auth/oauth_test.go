@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newFakeOAuthServer starts a fake Mailcow OAuth2 server knowing a single code and token per mailbox
+func newFakeOAuthServer(t *testing.T, tokens map[string]string, inactive map[string]bool) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth/profile", func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		email, ok := tokens[token]
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_token"})
+			return
+		}
+		active := "1"
+		if inactive[email] {
+			active = "0"
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":  true,
+			"username": email,
+			"email":    email,
+			"active":   active,
+		})
+	})
+	mux.HandleFunc("/oauth/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("grant_type") != "authorization_code" || r.PostFormValue("client_secret") != "secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.PostFormValue("code") != "good-code" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "token-alice",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+		})
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestOAuthAuthenticateToken(t *testing.T) {
+	srv := newFakeOAuthServer(t,
+		map[string]string{"token-alice": "alice@example.com", "token-bob": "bob@example.com"},
+		map[string]bool{"bob@example.com": true},
+	)
+
+	authModule, err := NewAuthModule("OAUTH2", srv.URL, 60)
+	if err != nil {
+		t.Fatalf("NewAuthModule: %v", err)
+	}
+
+	username, err := authModule.AuthenticateToken("token-alice", "")
+	if err != nil {
+		t.Fatalf("valid token rejected: %v", err)
+	}
+	if username != "alice@example.com" {
+		t.Errorf("expected alice@example.com, got %s", username)
+	}
+
+	if _, err := authModule.AuthenticateToken("unknown", ""); err == nil {
+		t.Error("unknown token was accepted")
+	}
+	if _, err := authModule.AuthenticateToken("token-bob", ""); err == nil {
+		t.Error("token of an inactive mailbox was accepted")
+	}
+
+	// Cached validations must keep resolving the mailbox once the server is gone
+	srv.Close()
+	username, err = authModule.AuthenticateToken("token-alice", "")
+	if err != nil || username != "alice@example.com" {
+		t.Errorf("cached token validation failed: %s, %v", username, err)
+	}
+}
+
+func TestOAuthAuthenticateUsernameToken(t *testing.T) {
+	srv := newFakeOAuthServer(t, map[string]string{"token-alice": "alice@example.com"}, nil)
+
+	authModule, err := NewAuthModule("OAUTH2", srv.URL, 0)
+	if err != nil {
+		t.Fatalf("NewAuthModule: %v", err)
+	}
+
+	if err := authModule.Authenticate("Alice@Example.com", "token-alice", ""); err != nil {
+		t.Errorf("matching username and token rejected: %v", err)
+	}
+	if err := authModule.Authenticate("mallory@example.com", "token-alice", ""); err == nil {
+		t.Error("token was accepted for a different mailbox")
+	}
+}
+
+func TestOAuthExchangeCode(t *testing.T) {
+	srv := newFakeOAuthServer(t, map[string]string{"token-alice": "alice@example.com"}, nil)
+
+	authModule, err := NewAuthModule("OAUTH2", srv.URL+"/", 0)
+	if err != nil {
+		t.Fatalf("NewAuthModule: %v", err)
+	}
+
+	authorizeURL := authModule.OAuthAuthorizeURL("client", "https://bridge.example.com/oauth/callback", "xyz")
+	if !strings.HasPrefix(authorizeURL, srv.URL+"/oauth/authorize?") || !strings.Contains(authorizeURL, "state=xyz") {
+		t.Errorf("unexpected authorize URL: %s", authorizeURL)
+	}
+
+	token, err := authModule.ExchangeOAuthCode("good-code", "client", "secret", "https://bridge.example.com/oauth/callback")
+	if err != nil {
+		t.Fatalf("code exchange failed: %v", err)
+	}
+	if token.AccessToken != "token-alice" || token.ExpiresIn != 3600 {
+		t.Errorf("unexpected token: %+v", token)
+	}
+
+	if _, err := authModule.ExchangeOAuthCode("bad-code", "client", "secret", "https://bridge.example.com/oauth/callback"); err == nil {
+		t.Error("invalid code was exchanged")
+	}
+}
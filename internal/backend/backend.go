@@ -0,0 +1,163 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/auth"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/mailcow"
+)
+
+// DefaultDomain marks the backend serving all domains not assigned to another backend
+const DefaultDomain = "*"
+
+// ErrNoBackend is returned for users whose domain is served by no backend
+var ErrNoBackend = errors.New("no backend serves this domain")
+
+var (
+	// ErrNotFound is returned for unknown aliases
+	ErrNotFound = errors.New("alias not found on the mail server")
+	// ErrUnknownDomain and ErrInactiveDomain are returned for domains aliases cannot be created on
+	ErrUnknownDomain  = errors.New("domain does not exist on the mail server")
+	ErrInactiveDomain = errors.New("domain is inactive on the mail server")
+	// ErrUnsupported is returned for changes the mail server cannot make, e.g. disabling a Mailu alias
+	ErrUnsupported = errors.New("not supported by the mail server")
+	// ErrUnavailable is returned while the mail server cannot be reached
+	ErrUnavailable = errors.New("mail server unavailable")
+	// ErrInvalidAlias is returned for addresses or comments the mail server cannot store
+	ErrInvalidAlias = errors.New("invalid alias")
+)
+
+// Alias is an alias of a mail server
+type Alias struct {
+	Address string
+	Goto    []string
+	Active  bool
+	Comment string // private comment, e.g. the hostname the alias was created for
+}
+
+// AliasOptions are optional settings of a new alias
+type AliasOptions struct {
+	// SenderAllowed lets the goto mailboxes send as the alias where supported, nil keeps the server's default
+	SenderAllowed *bool
+	Comment       string
+}
+
+// AliasUpdate lists the attributes of an alias to change, nil fields are kept
+type AliasUpdate struct {
+	Active  *bool
+	Comment *string
+	Goto    []string
+}
+
+// AliasBackend manages the aliases of a mail server
+type AliasBackend interface {
+	CreateAlias(address string, gotoAddresses []string, opts AliasOptions) error
+	GetAlias(address string) (*Alias, error)
+	ListAliases() ([]Alias, error)
+	UpdateAlias(address string, update AliasUpdate) error
+	DeleteAlias(address string) error
+}
+
+// DomainChecker is implemented by alias backends that know which domains they can create aliases on
+type DomainChecker interface {
+	// CheckDomain returns ErrUnknownDomain or ErrInactiveDomain for unusable domains
+	CheckDomain(domain string) error
+}
+
+// HealthChecker is implemented by alias backends whose mail server can become unreachable.
+// While it is, their operations fail fast with ErrUnavailable.
+type HealthChecker interface {
+	// CheckHealth contacts the mail server and records whether it is reachable
+	CheckHealth() error
+	// Available reports whether the mail server was reachable at the last contact
+	Available() bool
+}
+
+// Backend is a mail server with its auth server, serving a set of login domains
+type Backend struct {
+	Name    string
+	Domains []string
+	Aliases AliasBackend
+	// Mailcow offers mailbox lookups, time-limited aliases and logs, nil for other servers
+	Mailcow *mailcow.MailcowClient
+	Auth    *auth.AuthModule
+}
+
+// MailServerAvailable reports whether the mail server was reachable at the last contact.
+// Alias backends without health checks, e.g. local virtual maps, are always available.
+func (b *Backend) MailServerAvailable() bool {
+	checker, ok := b.Aliases.(HealthChecker)
+	return !ok || checker.Available()
+}
+
+// AuthServerAvailable reports whether the auth server was reachable at the last contact
+func (b *Backend) AuthServerAvailable() bool {
+	return b.Auth == nil || b.Auth.Available()
+}
+
+// CheckHealth contacts the mail server and the auth server and records whether they are reachable
+func (b *Backend) CheckHealth() (mailErr, authErr error) {
+	if checker, ok := b.Aliases.(HealthChecker); ok {
+		mailErr = checker.CheckHealth()
+	}
+	if b.Auth != nil {
+		authErr = b.Auth.CheckServer()
+	}
+	return mailErr, authErr
+}
+
+// Set routes users to backends by the domain of their login
+type Set struct {
+	backends []*Backend
+	byDomain map[string]*Backend
+}
+
+// NewSet creates a set of backends. Each domain, including DefaultDomain, may be served by one backend only.
+func NewSet(backends []*Backend) (*Set, error) {
+	set := &Set{byDomain: make(map[string]*Backend)}
+	for _, backend := range backends {
+		for _, domain := range backend.Domains {
+			domain = strings.ToLower(domain)
+			if other, ok := set.byDomain[domain]; ok {
+				return nil, fmt.Errorf("domain %q is served by backends %q and %q", domain, other.Name, backend.Name)
+			}
+			set.byDomain[domain] = backend
+		}
+		set.backends = append(set.backends, backend)
+	}
+	return set, nil
+}
+
+// Single returns a set of one backend serving all domains
+func Single(client *mailcow.MailcowClient, authModule *auth.AuthModule) *Set {
+	set, _ := NewSet([]*Backend{{Name: "default", Domains: []string{DefaultDomain}, Aliases: NewMailcowAliases(client), Mailcow: client, Auth: authModule}})
+	return set
+}
+
+// ForDomain returns the backend serving a domain
+func (s *Set) ForDomain(domain string) (*Backend, error) {
+	if backend, ok := s.byDomain[strings.ToLower(domain)]; ok {
+		return backend, nil
+	}
+	if backend, ok := s.byDomain[DefaultDomain]; ok {
+		return backend, nil
+	}
+	return nil, fmt.Errorf("%s: %w", domain, ErrNoBackend)
+}
+
+// ForUser returns the backend serving the domain of a login or address
+func (s *Set) ForUser(username string) (*Backend, error) {
+	return s.ForDomain(username[strings.LastIndex(username, "@")+1:])
+}
+
+// Default returns the backend serving all other domains, or nil
+func (s *Set) Default() *Backend {
+	return s.byDomain[DefaultDomain]
+}
+
+// All returns all backends in configuration order
+func (s *Set) All() []*Backend {
+	return s.backends
+}
@@ -0,0 +1,42 @@
+package backend
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSetRouting(t *testing.T) {
+	orgA := &Backend{Name: "org-a", Domains: []string{"a.example", "a.example.net"}}
+	orgB := &Backend{Name: "org-b", Domains: []string{"b.example"}}
+
+	set, err := NewSet([]*Backend{orgA, orgB})
+	if err != nil {
+		t.Fatalf("NewSet: %v", err)
+	}
+	for username, want := range map[string]*Backend{
+		"alice@A.example":   orgA,
+		"bob@a.example.net": orgA,
+		"carol@b.example":   orgB,
+		"b.example":         orgB,
+	} {
+		if got, err := set.ForUser(username); err != nil || got != want {
+			t.Errorf("ForUser(%q) = %v, %v", username, got, err)
+		}
+	}
+	if _, err := set.ForUser("dave@c.example"); !errors.Is(err, ErrNoBackend) {
+		t.Errorf("expected ErrNoBackend without default backend, got %v", err)
+	}
+
+	fallback := &Backend{Name: "default", Domains: []string{DefaultDomain}}
+	set, err = NewSet([]*Backend{orgA, fallback})
+	if err != nil {
+		t.Fatalf("NewSet: %v", err)
+	}
+	if got, _ := set.ForUser("dave@c.example"); got != fallback || set.Default() != fallback {
+		t.Errorf("expected default backend for unassigned domain, got %v", got)
+	}
+
+	if _, err := NewSet([]*Backend{orgA, {Name: "copy", Domains: []string{"A.example"}}}); err == nil {
+		t.Error("NewSet accepted a domain served by two backends")
+	}
+}
@@ -0,0 +1,14 @@
+package backend
+
+import "path/filepath"
+
+// dockerMailserverAliasFile is the alias file in the config directory of docker-mailserver
+const dockerMailserverAliasFile = "postfix-virtual.cf"
+
+// NewDockerMailserverAliases returns the alias backend of a docker-mailserver instance, managing
+// postfix-virtual.cf in its config directory. docker-mailserver notices changes of the file and
+// rebuilds its virtual alias map on its own. The directory, not the file, must be mounted into the
+// bridge so the file can be replaced atomically.
+func NewDockerMailserverAliases(configDir string, domains []string) AliasBackend {
+	return NewPostfixAliases(filepath.Join(configDir, dockerMailserverAliasFile), "", domains)
+}
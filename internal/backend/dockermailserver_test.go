@@ -0,0 +1,31 @@
+package backend
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestDockerMailserverAliases(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "postfix-virtual.cf")
+	// As written by "setup alias add"
+	if err := ioutil.WriteFile(path, []byte("info@example.com alice@example.com\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	aliases := NewDockerMailserverAliases(dir, nil)
+	if err := aliases.CreateAlias("random@example.com", []string{"alice@example.com"}, AliasOptions{}); err != nil {
+		t.Fatalf("CreateAlias: %v", err)
+	}
+	inactive := false
+	if err := aliases.UpdateAlias("info@example.com", AliasUpdate{Active: &inactive}); err != nil {
+		t.Fatalf("UpdateAlias: %v", err)
+	}
+
+	content, _ := ioutil.ReadFile(path)
+	want := "#disabled info@example.com alice@example.com\nrandom@example.com alice@example.com\n"
+	if string(content) != want {
+		t.Errorf("unexpected postfix-virtual.cf:\n%s\nwant:\n%s", content, want)
+	}
+}
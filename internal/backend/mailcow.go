@@ -0,0 +1,106 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/mailcow"
+)
+
+// mailcowAliases manages aliases through the Mailcow API
+type mailcowAliases struct {
+	client *mailcow.MailcowClient
+}
+
+// NewMailcowAliases returns the alias backend of a Mailcow instance
+func NewMailcowAliases(client *mailcow.MailcowClient) AliasBackend {
+	return &mailcowAliases{client: client}
+}
+
+// CreateAlias implements AliasBackend
+func (m *mailcowAliases) CreateAlias(address string, gotoAddresses []string, opts AliasOptions) error {
+	err := m.client.CreateAlias(address, strings.Join(gotoAddresses, ","), mailcow.AliasOptions{
+		SenderAllowed: opts.SenderAllowed,
+		Comment:       opts.Comment,
+	})
+	return translate(err, address)
+}
+
+// GetAlias implements AliasBackend
+func (m *mailcowAliases) GetAlias(address string) (*Alias, error) {
+	aliases, err := m.ListAliases()
+	if err != nil {
+		return nil, err
+	}
+	for _, alias := range aliases {
+		if strings.EqualFold(alias.Address, address) {
+			return &alias, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNotFound, address)
+}
+
+// ListAliases implements AliasBackend
+func (m *mailcowAliases) ListAliases() ([]Alias, error) {
+	mailcowAliases, err := m.client.GetAliases()
+	if err != nil {
+		return nil, err
+	}
+
+	aliases := make([]Alias, 0, len(mailcowAliases))
+	for _, alias := range mailcowAliases {
+		aliases = append(aliases, Alias{
+			Address: alias.Address,
+			Goto:    strings.Split(alias.Goto, ","),
+			Active:  alias.IsActive(),
+			Comment: alias.PrivateComment,
+		})
+	}
+	return aliases, nil
+}
+
+// UpdateAlias implements AliasBackend
+func (m *mailcowAliases) UpdateAlias(address string, update AliasUpdate) error {
+	mailcowUpdate := mailcow.AliasUpdate{Active: update.Active, PrivateComment: update.Comment}
+	if update.Goto != nil {
+		gotoAddresses := strings.Join(update.Goto, ",")
+		mailcowUpdate.Goto = &gotoAddresses
+	}
+	return translate(m.client.UpdateAlias(address, mailcowUpdate), address)
+}
+
+// DeleteAlias implements AliasBackend
+func (m *mailcowAliases) DeleteAlias(address string) error {
+	return translate(m.client.DeleteAlias(address), address)
+}
+
+// CheckDomain implements DomainChecker
+func (m *mailcowAliases) CheckDomain(domain string) error {
+	return translate(m.client.CheckDomain(domain), domain)
+}
+
+// CheckHealth implements HealthChecker
+func (m *mailcowAliases) CheckHealth() error {
+	return m.client.CheckAPIConnectivity()
+}
+
+// Available implements HealthChecker
+func (m *mailcowAliases) Available() bool {
+	return m.client.Available()
+}
+
+// translate maps the errors of the Mailcow client about an alias or domain to the errors of this package
+func translate(err error, subject string) error {
+	switch {
+	case errors.Is(err, mailcow.ErrNotFound):
+		return fmt.Errorf("%w: %s", ErrNotFound, subject)
+	case errors.Is(err, mailcow.ErrUnknownDomain):
+		return fmt.Errorf("%w: %s", ErrUnknownDomain, subject)
+	case errors.Is(err, mailcow.ErrInactiveDomain):
+		return fmt.Errorf("%w: %s", ErrInactiveDomain, subject)
+	case errors.Is(err, mailcow.ErrUnavailable):
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	return err
+}
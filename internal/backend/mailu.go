@@ -0,0 +1,103 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/mailu"
+)
+
+// mailuAliases manages aliases through the Mailu REST API. Mailu aliases cannot be disabled,
+// only deleted.
+type mailuAliases struct {
+	client *mailu.Client
+}
+
+// NewMailuAliases returns the alias backend of a Mailu instance
+func NewMailuAliases(client *mailu.Client) AliasBackend {
+	return &mailuAliases{client: client}
+}
+
+// CreateAlias implements AliasBackend, Mailu has no send-as option for aliases
+func (m *mailuAliases) CreateAlias(address string, gotoAddresses []string, opts AliasOptions) error {
+	err := m.client.CreateAlias(mailu.Alias{Email: address, Destination: gotoAddresses, Comment: opts.Comment})
+	return translateMailu(err, address)
+}
+
+// GetAlias implements AliasBackend
+func (m *mailuAliases) GetAlias(address string) (*Alias, error) {
+	alias, err := m.client.GetAlias(address)
+	if err != nil {
+		return nil, translateMailu(err, address)
+	}
+	converted := fromMailu(*alias)
+	return &converted, nil
+}
+
+// ListAliases implements AliasBackend
+func (m *mailuAliases) ListAliases() ([]Alias, error) {
+	mailuAliases, err := m.client.GetAliases()
+	if err != nil {
+		return nil, translateMailu(err, "")
+	}
+
+	aliases := make([]Alias, 0, len(mailuAliases))
+	for _, alias := range mailuAliases {
+		aliases = append(aliases, fromMailu(alias))
+	}
+	return aliases, nil
+}
+
+// UpdateAlias implements AliasBackend. Disabling returns ErrUnsupported, enabling is a no-op.
+func (m *mailuAliases) UpdateAlias(address string, update AliasUpdate) error {
+	if update.Active != nil && !*update.Active {
+		return fmt.Errorf("%w: Mailu aliases cannot be disabled", ErrUnsupported)
+	}
+	if update.Comment == nil && update.Goto == nil {
+		// Nothing to change, but unknown aliases are reported all the same
+		_, err := m.GetAlias(address)
+		return err
+	}
+	err := m.client.UpdateAlias(address, mailu.AliasUpdate{Destination: update.Goto, Comment: update.Comment})
+	return translateMailu(err, address)
+}
+
+// DeleteAlias implements AliasBackend
+func (m *mailuAliases) DeleteAlias(address string) error {
+	return translateMailu(m.client.DeleteAlias(address), address)
+}
+
+// CheckDomain implements DomainChecker
+func (m *mailuAliases) CheckDomain(domain string) error {
+	_, err := m.client.GetDomain(domain)
+	if errors.Is(err, mailu.ErrNotFound) {
+		return fmt.Errorf("%w: %s", ErrUnknownDomain, domain)
+	}
+	return translateMailu(err, domain)
+}
+
+// CheckHealth implements HealthChecker
+func (m *mailuAliases) CheckHealth() error {
+	return m.client.CheckAPIConnectivity()
+}
+
+// Available implements HealthChecker
+func (m *mailuAliases) Available() bool {
+	return m.client.Available()
+}
+
+// fromMailu converts a Mailu alias, which is always active
+func fromMailu(alias mailu.Alias) Alias {
+	return Alias{Address: alias.Email, Goto: alias.Destination, Active: true, Comment: alias.Comment}
+}
+
+// translateMailu maps the errors of the Mailu client about an alias to the errors of this package
+func translateMailu(err error, address string) error {
+	switch {
+	case errors.Is(err, mailu.ErrNotFound):
+		return fmt.Errorf("%w: %s", ErrNotFound, address)
+	case errors.Is(err, mailu.ErrUnavailable):
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	return err
+}
@@ -0,0 +1,174 @@
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/mailu"
+)
+
+// fakeMailu serves the alias and domain endpoints of the Mailu API from memory
+type fakeMailu struct {
+	mu      sync.Mutex
+	aliases map[string]mailu.Alias
+	domains map[string]bool
+}
+
+func (f *fakeMailu) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	notFound := func() {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"code": 404, "message": "Alias not found"}`))
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/api/v1")
+	switch {
+	case path == "/alias" && r.Method == "GET":
+		list := []mailu.Alias{}
+		for _, alias := range f.aliases {
+			list = append(list, alias)
+		}
+		json.NewEncoder(w).Encode(list)
+	case path == "/alias" && r.Method == "POST":
+		var alias mailu.Alias
+		json.NewDecoder(r.Body).Decode(&alias)
+		if _, ok := f.aliases[alias.Email]; ok {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(`{"code": 409, "message": "Duplicate alias"}`))
+			return
+		}
+		f.aliases[alias.Email] = alias
+		w.Write([]byte(`{"code": 200, "message": "Alias created"}`))
+	case strings.HasPrefix(path, "/alias/"):
+		email := strings.TrimPrefix(path, "/alias/")
+		alias, ok := f.aliases[email]
+		if !ok {
+			notFound()
+			return
+		}
+		switch r.Method {
+		case "GET":
+			json.NewEncoder(w).Encode(alias)
+		case "PATCH":
+			var update mailu.AliasUpdate
+			json.NewDecoder(r.Body).Decode(&update)
+			if update.Destination != nil {
+				alias.Destination = update.Destination
+			}
+			if update.Comment != nil {
+				alias.Comment = *update.Comment
+			}
+			f.aliases[email] = alias
+			w.Write([]byte(`{"code": 200, "message": "Alias updated"}`))
+		case "DELETE":
+			delete(f.aliases, email)
+			w.Write([]byte(`{"code": 200, "message": "Alias deleted"}`))
+		}
+	case path == "/domain" && r.Method == "GET":
+		list := []mailu.Domain{}
+		for name := range f.domains {
+			list = append(list, mailu.Domain{Name: name})
+		}
+		json.NewEncoder(w).Encode(list)
+	case strings.HasPrefix(path, "/domain/"):
+		name := strings.TrimPrefix(path, "/domain/")
+		if !f.domains[name] {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"code": 404, "message": "Domain not found"}`))
+			return
+		}
+		json.NewEncoder(w).Encode(mailu.Domain{Name: name})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestMailuAliases(t *testing.T) {
+	fake := &fakeMailu{aliases: make(map[string]mailu.Alias), domains: map[string]bool{"example.com": true}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	client, err := mailu.NewClient(server.URL+"/", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	aliases := NewMailuAliases(client)
+
+	if err := aliases.CreateAlias("random@example.com", []string{"alice@example.com"}, AliasOptions{Comment: "github.com"}); err != nil {
+		t.Fatalf("CreateAlias: %v", err)
+	}
+	if err := aliases.CreateAlias("random@example.com", []string{"alice@example.com"}, AliasOptions{}); err == nil {
+		t.Error("CreateAlias accepted a duplicate alias")
+	}
+
+	alias, err := aliases.GetAlias("Random@example.com")
+	if err != nil || alias.Comment != "github.com" || !alias.Active || len(alias.Goto) != 1 {
+		t.Fatalf("GetAlias = %+v, %v", alias, err)
+	}
+
+	if err := aliases.UpdateAlias("random@example.com", AliasUpdate{Goto: []string{"alice@example.com", "team@example.com"}}); err != nil {
+		t.Fatalf("UpdateAlias: %v", err)
+	}
+	if got := fake.aliases["random@example.com"].Destination; len(got) != 2 {
+		t.Errorf("expected two destinations, got %v", got)
+	}
+	inactive := false
+	if err := aliases.UpdateAlias("random@example.com", AliasUpdate{Active: &inactive}); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported disabling a Mailu alias, got %v", err)
+	}
+
+	if list, err := aliases.ListAliases(); err != nil || len(list) != 1 {
+		t.Errorf("ListAliases = %+v, %v", list, err)
+	}
+	if err := aliases.DeleteAlias("random@example.com"); err != nil {
+		t.Fatalf("DeleteAlias: %v", err)
+	}
+	if _, err := aliases.GetAlias("random@example.com"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound after deletion, got %v", err)
+	}
+
+	checker := aliases.(DomainChecker)
+	if err := checker.CheckDomain("example.com"); err != nil {
+		t.Errorf("CheckDomain(example.com) = %v", err)
+	}
+	if err := checker.CheckDomain("other.org"); !errors.Is(err, ErrUnknownDomain) {
+		t.Errorf("expected ErrUnknownDomain, got %v", err)
+	}
+}
+
+func TestMailuAliasesUnavailable(t *testing.T) {
+	server := httptest.NewServer(&fakeMailu{aliases: make(map[string]mailu.Alias), domains: map[string]bool{"example.com": true}})
+	client, err := mailu.NewClient(server.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &Backend{Name: "mailu", Aliases: NewMailuAliases(client)}
+	if mailErr, _ := b.CheckHealth(); mailErr != nil || !b.MailServerAvailable() {
+		t.Fatalf("CheckHealth = %v", mailErr)
+	}
+
+	server.Close()
+	if err := b.Aliases.CreateAlias("random@example.com", []string{"alice@example.com"}, AliasOptions{}); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("expected ErrUnavailable for an unreachable Mailu, got %v", err)
+	}
+	if b.MailServerAvailable() {
+		t.Error("unreachable Mailu reported as available")
+	}
+	if _, err := b.Aliases.ListAliases(); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("expected ListAliases to fail fast with ErrUnavailable, got %v", err)
+	}
+	if err := b.Aliases.(DomainChecker).CheckDomain("example.com"); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("expected CheckDomain to fail fast with ErrUnavailable, got %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+package backend
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// memoryAliases keeps aliases in memory, for the dev mode and tests. Aliases are lost on restart.
+type memoryAliases struct {
+	aliases map[string]Alias // by lowercased address
+	domains []string
+	mu      sync.Mutex
+}
+
+// NewMemoryAliases returns an alias backend keeping its aliases in memory.
+// If domains are given, aliases can only be created on them.
+func NewMemoryAliases(domains []string) AliasBackend {
+	lowered := make([]string, 0, len(domains))
+	for _, domain := range domains {
+		lowered = append(lowered, strings.ToLower(domain))
+	}
+	return &memoryAliases{aliases: make(map[string]Alias), domains: lowered}
+}
+
+// CreateAlias implements AliasBackend
+func (m *memoryAliases) CreateAlias(address string, gotoAddresses []string, opts AliasOptions) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := strings.ToLower(address)
+	if _, ok := m.aliases[key]; ok {
+		return fmt.Errorf("alias %s already exists", address)
+	}
+	m.aliases[key] = Alias{
+		Address: address,
+		Goto:    append([]string{}, gotoAddresses...),
+		Active:  true,
+		Comment: opts.Comment,
+	}
+	return nil
+}
+
+// GetAlias implements AliasBackend
+func (m *memoryAliases) GetAlias(address string) (*Alias, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	alias, ok := m.aliases[strings.ToLower(address)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, address)
+	}
+	alias.Goto = append([]string{}, alias.Goto...)
+	return &alias, nil
+}
+
+// ListAliases implements AliasBackend, sorted by address
+func (m *memoryAliases) ListAliases() ([]Alias, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	aliases := make([]Alias, 0, len(m.aliases))
+	for _, alias := range m.aliases {
+		alias.Goto = append([]string{}, alias.Goto...)
+		aliases = append(aliases, alias)
+	}
+	sort.Slice(aliases, func(i, j int) bool { return aliases[i].Address < aliases[j].Address })
+	return aliases, nil
+}
+
+// UpdateAlias implements AliasBackend
+func (m *memoryAliases) UpdateAlias(address string, update AliasUpdate) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := strings.ToLower(address)
+	alias, ok := m.aliases[key]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotFound, address)
+	}
+	if update.Active != nil {
+		alias.Active = *update.Active
+	}
+	if update.Comment != nil {
+		alias.Comment = *update.Comment
+	}
+	if update.Goto != nil {
+		alias.Goto = append([]string{}, update.Goto...)
+	}
+	m.aliases[key] = alias
+	return nil
+}
+
+// DeleteAlias implements AliasBackend
+func (m *memoryAliases) DeleteAlias(address string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := strings.ToLower(address)
+	if _, ok := m.aliases[key]; !ok {
+		return fmt.Errorf("%w: %s", ErrNotFound, address)
+	}
+	delete(m.aliases, key)
+	return nil
+}
+
+// CheckDomain implements DomainChecker, any domain is accepted if none were configured
+func (m *memoryAliases) CheckDomain(domain string) error {
+	if len(m.domains) == 0 {
+		return nil
+	}
+	domain = strings.ToLower(domain)
+	for _, d := range m.domains {
+		if d == domain {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrUnknownDomain, domain)
+}
@@ -0,0 +1,39 @@
+package backend
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMemoryAliases(t *testing.T) {
+	aliases := NewMemoryAliases([]string{"example.com"})
+
+	if err := aliases.CreateAlias("b@example.com", []string{"alice@example.com"}, AliasOptions{Comment: "github.com"}); err != nil {
+		t.Fatalf("CreateAlias: %v", err)
+	}
+	if err := aliases.CreateAlias("A@example.com", []string{"alice@example.com"}, AliasOptions{}); err != nil {
+		t.Fatalf("CreateAlias: %v", err)
+	}
+	if err := aliases.CreateAlias("a@EXAMPLE.com", []string{"bob@example.com"}, AliasOptions{}); err == nil {
+		t.Error("CreateAlias accepted an existing address")
+	}
+
+	inactive := false
+	if err := aliases.UpdateAlias("B@example.com", AliasUpdate{Active: &inactive}); err != nil {
+		t.Fatalf("UpdateAlias: %v", err)
+	}
+	list, err := aliases.ListAliases()
+	if err != nil || len(list) != 2 || list[0].Address != "A@example.com" || list[1].Active || list[1].Comment != "github.com" {
+		t.Errorf("ListAliases = %+v, %v", list, err)
+	}
+
+	if err := aliases.DeleteAlias("a@example.com"); err != nil {
+		t.Fatalf("DeleteAlias: %v", err)
+	}
+	if _, err := aliases.GetAlias("a@example.com"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound after deletion, got %v", err)
+	}
+	if err := aliases.(DomainChecker).CheckDomain("other.org"); !errors.Is(err, ErrUnknownDomain) {
+		t.Errorf("expected ErrUnknownDomain, got %v", err)
+	}
+}
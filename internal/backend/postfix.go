@@ -0,0 +1,361 @@
+package backend
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+const (
+	// postfixCommentPrefix marks the comment of the entry on the next line
+	postfixCommentPrefix = "# comment: "
+	// postfixDisabledPrefix comments out a disabled entry
+	postfixDisabledPrefix = "#disabled "
+)
+
+// postfixAliases manages aliases in a Postfix virtual alias map file.
+// Lines the bridge does not understand, e.g. comments of the administrator, are kept as they are.
+type postfixAliases struct {
+	path string
+	// postmap is the command rebuilding the lookup table, run with the map path appended; empty to skip
+	postmap []string
+	domains []string
+	mu      sync.Mutex
+}
+
+// NewPostfixAliases returns the alias backend of a Postfix virtual alias map at path. The file is created on
+// the first change if missing. If postmapCommand is set, e.g. "postmap", it is run after every change.
+// If domains are given, aliases can only be created on them.
+func NewPostfixAliases(path, postmapCommand string, domains []string) AliasBackend {
+	lowered := make([]string, 0, len(domains))
+	for _, domain := range domains {
+		lowered = append(lowered, strings.ToLower(domain))
+	}
+	return &postfixAliases{path: path, postmap: strings.Fields(postmapCommand), domains: lowered}
+}
+
+// postfixLine is a line of the map, either an entry or kept verbatim
+type postfixLine struct {
+	raw   string
+	entry *Alias
+}
+
+// read parses the map file, a missing file is an empty map
+func (p *postfixAliases) read() ([]postfixLine, error) {
+	content, err := ioutil.ReadFile(p.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read virtual alias map: %w", err)
+	}
+
+	var lines []postfixLine
+	comment, hasComment := "", false
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		raw := scanner.Text()
+		trimmed := strings.TrimSpace(raw)
+
+		// Comments belong to the entry right below them
+		if strings.HasPrefix(raw, postfixCommentPrefix) {
+			if hasComment {
+				lines = append(lines, postfixLine{raw: postfixCommentPrefix + comment})
+			}
+			comment, hasComment = strings.TrimPrefix(raw, postfixCommentPrefix), true
+			continue
+		}
+
+		var entry *Alias
+		switch {
+		case strings.HasPrefix(raw, postfixDisabledPrefix):
+			entry = parsePostfixEntry(strings.TrimPrefix(raw, postfixDisabledPrefix))
+			if entry != nil {
+				entry.Active = false
+			}
+		case trimmed != "" && !strings.HasPrefix(trimmed, "#"):
+			// Lines starting with whitespace continue the previous entry
+			if raw[0] == ' ' || raw[0] == '\t' {
+				if n := len(lines); n > 0 && lines[n-1].entry != nil && !hasComment {
+					lines[n-1].entry.Goto = append(lines[n-1].entry.Goto, splitPostfixGoto(trimmed)...)
+					continue
+				}
+			}
+			entry = parsePostfixEntry(trimmed)
+		}
+
+		if entry != nil {
+			if hasComment {
+				entry.Comment = comment
+			}
+			lines = append(lines, postfixLine{entry: entry})
+		} else {
+			if hasComment {
+				lines = append(lines, postfixLine{raw: postfixCommentPrefix + comment})
+			}
+			lines = append(lines, postfixLine{raw: raw})
+		}
+		comment, hasComment = "", false
+	}
+	if hasComment {
+		lines = append(lines, postfixLine{raw: postfixCommentPrefix + comment})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read virtual alias map: %w", err)
+	}
+	return lines, nil
+}
+
+// parsePostfixEntry parses an active "address goto, goto" entry, nil if the line is none
+func parsePostfixEntry(line string) *Alias {
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return nil
+	}
+	return &Alias{
+		Address: fields[0],
+		Goto:    splitPostfixGoto(strings.Join(fields[1:], " ")),
+		Active:  true,
+	}
+}
+
+// splitPostfixGoto splits the right-hand side of an entry, separated by commas and/or whitespace
+func splitPostfixGoto(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+}
+
+// validatePostfixEntry checks that an entry is written as a single line and reads back the same.
+// A line break in a comment or whitespace in an address would add entries of its own.
+func validatePostfixEntry(entry *Alias) error {
+	if strings.IndexFunc(entry.Comment, unicode.IsControl) >= 0 {
+		return fmt.Errorf("%w: comment of %s contains control characters", ErrInvalidAlias, entry.Address)
+	}
+	if !validPostfixAddress(entry.Address) {
+		return fmt.Errorf("%w: address %q", ErrInvalidAlias, entry.Address)
+	}
+	if len(entry.Goto) == 0 {
+		return fmt.Errorf("%w: %s has no destination", ErrInvalidAlias, entry.Address)
+	}
+	for _, address := range entry.Goto {
+		if !validPostfixAddress(address) {
+			return fmt.Errorf("%w: destination %q of %s", ErrInvalidAlias, address, entry.Address)
+		}
+	}
+	return nil
+}
+
+// validPostfixAddress reports whether an address is a single field of an entry
+func validPostfixAddress(address string) bool {
+	return address != "" && !strings.HasPrefix(address, "#") &&
+		strings.IndexFunc(address, func(r rune) bool { return r == ',' || unicode.IsSpace(r) || unicode.IsControl(r) }) < 0
+}
+
+// write replaces the map file atomically and rebuilds the lookup table.
+// If rebuilding fails, the previous map is restored so the file matches the table in use.
+func (p *postfixAliases) write(lines []postfixLine) error {
+	var buf bytes.Buffer
+	for _, line := range lines {
+		if line.entry == nil {
+			buf.WriteString(line.raw + "\n")
+			continue
+		}
+		if err := validatePostfixEntry(line.entry); err != nil {
+			return err
+		}
+		if line.entry.Comment != "" {
+			buf.WriteString(postfixCommentPrefix + line.entry.Comment + "\n")
+		}
+		if !line.entry.Active {
+			buf.WriteString(postfixDisabledPrefix)
+		}
+		buf.WriteString(line.entry.Address + " " + strings.Join(line.entry.Goto, ", ") + "\n")
+	}
+
+	previous, err := ioutil.ReadFile(p.path)
+	existed := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read virtual alias map: %w", err)
+	}
+	if err := p.replace(buf.Bytes()); err != nil {
+		return err
+	}
+
+	if len(p.postmap) == 0 {
+		return nil
+	}
+	args := append(append([]string{}, p.postmap[1:]...), p.path)
+	if output, err := exec.Command(p.postmap[0], args...).CombinedOutput(); err != nil {
+		err = fmt.Errorf("failed to run %s: %w: %s", p.postmap[0], err, strings.TrimSpace(string(output)))
+		restoreErr := os.Remove(p.path)
+		if existed {
+			restoreErr = p.replace(previous)
+		}
+		if restoreErr != nil {
+			return fmt.Errorf("%w, restoring the previous map failed: %v", err, restoreErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// replace replaces the content of the map file atomically, keeping its mode
+func (p *postfixAliases) replace(content []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(p.path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(p.path), ".virtual-*")
+	if err != nil {
+		return fmt.Errorf("failed to create virtual alias map: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write virtual alias map: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write virtual alias map: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return fmt.Errorf("failed to write virtual alias map: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), p.path); err != nil {
+		return fmt.Errorf("failed to replace virtual alias map: %w", err)
+	}
+	return nil
+}
+
+// find returns the index of the entry of an address, -1 if there is none
+func find(lines []postfixLine, address string) int {
+	for i, line := range lines {
+		if line.entry != nil && strings.EqualFold(line.entry.Address, address) {
+			return i
+		}
+	}
+	return -1
+}
+
+// CreateAlias implements AliasBackend
+func (p *postfixAliases) CreateAlias(address string, gotoAddresses []string, opts AliasOptions) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	lines, err := p.read()
+	if err != nil {
+		return err
+	}
+	if find(lines, address) >= 0 {
+		return fmt.Errorf("alias %s already exists", address)
+	}
+
+	lines = append(lines, postfixLine{entry: &Alias{
+		Address: address,
+		Goto:    append([]string{}, gotoAddresses...),
+		Active:  true,
+		Comment: opts.Comment,
+	}})
+	return p.write(lines)
+}
+
+// GetAlias implements AliasBackend
+func (p *postfixAliases) GetAlias(address string) (*Alias, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	lines, err := p.read()
+	if err != nil {
+		return nil, err
+	}
+	i := find(lines, address)
+	if i < 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, address)
+	}
+	alias := *lines[i].entry
+	return &alias, nil
+}
+
+// ListAliases implements AliasBackend
+func (p *postfixAliases) ListAliases() ([]Alias, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	lines, err := p.read()
+	if err != nil {
+		return nil, err
+	}
+	var aliases []Alias
+	for _, line := range lines {
+		if line.entry != nil {
+			aliases = append(aliases, *line.entry)
+		}
+	}
+	return aliases, nil
+}
+
+// UpdateAlias implements AliasBackend
+func (p *postfixAliases) UpdateAlias(address string, update AliasUpdate) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	lines, err := p.read()
+	if err != nil {
+		return err
+	}
+	i := find(lines, address)
+	if i < 0 {
+		return fmt.Errorf("%w: %s", ErrNotFound, address)
+	}
+
+	entry := lines[i].entry
+	if update.Active != nil {
+		entry.Active = *update.Active
+	}
+	if update.Comment != nil {
+		entry.Comment = *update.Comment
+	}
+	if update.Goto != nil {
+		entry.Goto = append([]string{}, update.Goto...)
+	}
+	return p.write(lines)
+}
+
+// DeleteAlias implements AliasBackend
+func (p *postfixAliases) DeleteAlias(address string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	lines, err := p.read()
+	if err != nil {
+		return err
+	}
+	i := find(lines, address)
+	if i < 0 {
+		return fmt.Errorf("%w: %s", ErrNotFound, address)
+	}
+	return p.write(append(lines[:i], lines[i+1:]...))
+}
+
+// CheckDomain implements DomainChecker, any domain is accepted if none were configured
+func (p *postfixAliases) CheckDomain(domain string) error {
+	if len(p.domains) == 0 {
+		return nil
+	}
+	domain = strings.ToLower(domain)
+	for _, d := range p.domains {
+		if d == domain {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrUnknownDomain, domain)
+}
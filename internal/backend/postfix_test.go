@@ -0,0 +1,177 @@
+package backend
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPostfixAliases(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "virtual")
+	initial := "# Managed by hand\npostmaster@example.com admin@example.com\n" +
+		"team@example.com alice@example.com,\n\tbob@example.com\n"
+	if err := ioutil.WriteFile(path, []byte(initial), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	// The postmap stand-in copies the map to the lookup table
+	postmap := filepath.Join(dir, "postmap")
+	if err := ioutil.WriteFile(postmap, []byte("#!/bin/sh\ncp \"$1\" \"$1.db\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	aliases := NewPostfixAliases(path, postmap, []string{"Example.com"})
+
+	team, err := aliases.GetAlias("TEAM@example.com")
+	if err != nil || strings.Join(team.Goto, ",") != "alice@example.com,bob@example.com" || !team.Active {
+		t.Fatalf("GetAlias(team) = %+v, %v", team, err)
+	}
+
+	if err := aliases.CreateAlias("random.word@example.com", []string{"alice@example.com"}, AliasOptions{Comment: "github.com"}); err != nil {
+		t.Fatalf("CreateAlias: %v", err)
+	}
+	if err := aliases.CreateAlias("random.word@example.com", []string{"bob@example.com"}, AliasOptions{}); err == nil {
+		t.Error("CreateAlias accepted an existing address")
+	}
+
+	active := false
+	if err := aliases.UpdateAlias("random.word@example.com", AliasUpdate{Active: &active, Goto: []string{"alice@example.com", "bob@example.com"}}); err != nil {
+		t.Fatalf("UpdateAlias: %v", err)
+	}
+	if err := aliases.DeleteAlias("postmaster@example.com"); err != nil {
+		t.Fatalf("DeleteAlias: %v", err)
+	}
+	if err := aliases.DeleteAlias("postmaster@example.com"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound deleting twice, got %v", err)
+	}
+
+	content, _ := ioutil.ReadFile(path)
+	want := "# Managed by hand\n" +
+		"team@example.com alice@example.com, bob@example.com\n" +
+		"# comment: github.com\n" +
+		"#disabled random.word@example.com alice@example.com, bob@example.com\n"
+	if string(content) != want {
+		t.Errorf("unexpected map:\n%s\nwant:\n%s", content, want)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0640 {
+		t.Errorf("expected the file mode to be kept, got %v", info.Mode().Perm())
+	}
+	if table, _ := ioutil.ReadFile(path + ".db"); string(table) != want {
+		t.Error("expected postmap to run after the last change")
+	}
+
+	// The written map reads back the same
+	list, err := aliases.ListAliases()
+	if err != nil || len(list) != 2 {
+		t.Fatalf("ListAliases = %+v, %v", list, err)
+	}
+	if list[1].Active || list[1].Comment != "github.com" || len(list[1].Goto) != 2 {
+		t.Errorf("unexpected disabled alias %+v", list[1])
+	}
+
+	checker := aliases.(DomainChecker)
+	if err := checker.CheckDomain("EXAMPLE.com"); err != nil {
+		t.Errorf("CheckDomain(example.com) = %v", err)
+	}
+	if err := checker.CheckDomain("other.org"); !errors.Is(err, ErrUnknownDomain) {
+		t.Errorf("expected ErrUnknownDomain, got %v", err)
+	}
+}
+
+func TestPostfixAliasesMissingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "virtual")
+	aliases := NewPostfixAliases(path, "", nil)
+
+	if list, err := aliases.ListAliases(); err != nil || len(list) != 0 {
+		t.Fatalf("ListAliases of missing map = %v, %v", list, err)
+	}
+	if err := aliases.CreateAlias("a@example.com", []string{"b@example.com"}, AliasOptions{}); err != nil {
+		t.Fatalf("CreateAlias: %v", err)
+	}
+	if content, _ := ioutil.ReadFile(path); string(content) != "a@example.com b@example.com\n" {
+		t.Errorf("unexpected map %q", content)
+	}
+}
+
+func TestPostfixAliasesRejectInjection(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "virtual")
+	aliases := NewPostfixAliases(path, "", nil)
+
+	for _, test := range []struct {
+		address      string
+		destinations []string
+		comment      string
+	}{
+		{"a@example.com", []string{"b@example.com"}, "x\nceo@example.com attacker@evil.com"},
+		{"a@example.com", []string{"b@example.com"}, "x\rceo@example.com attacker@evil.com"},
+		{"a@example.com ceo@example.com", []string{"b@example.com"}, ""},
+		{"a@example.com", []string{"b@example.com\nceo@example.com attacker@evil.com"}, ""},
+		{"a@example.com", []string{"b@example.com attacker@evil.com"}, ""},
+		{"a@example.com", []string{"b@example.com,attacker@evil.com"}, ""},
+		{"#a@example.com", []string{"b@example.com"}, ""},
+		{"a@example.com", nil, ""},
+	} {
+		err := aliases.CreateAlias(test.address, test.destinations, AliasOptions{Comment: test.comment})
+		if !errors.Is(err, ErrInvalidAlias) {
+			t.Errorf("CreateAlias(%q, %q, %q) = %v, expected ErrInvalidAlias", test.address, test.destinations, test.comment, err)
+		}
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("rejected aliases were written")
+	}
+
+	if err := aliases.CreateAlias("a@example.com", []string{"b@example.com"}, AliasOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	comment := "x\nceo@example.com attacker@evil.com"
+	if err := aliases.UpdateAlias("a@example.com", AliasUpdate{Comment: &comment}); !errors.Is(err, ErrInvalidAlias) {
+		t.Errorf("UpdateAlias accepted a comment with a line break: %v", err)
+	}
+	if list, _ := aliases.ListAliases(); len(list) != 1 || list[0].Comment != "" {
+		t.Errorf("unexpected aliases after rejected update: %+v", list)
+	}
+}
+
+func TestPostfixAliasesRollBackFailedPostmap(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "virtual")
+	initial := "a@example.com b@example.com\n"
+	if err := ioutil.WriteFile(path, []byte(initial), 0640); err != nil {
+		t.Fatal(err)
+	}
+	postmap := filepath.Join(dir, "postmap")
+	if err := ioutil.WriteFile(postmap, []byte("#!/bin/sh\necho 'fatal: broken table' >&2\nexit 1\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	aliases := NewPostfixAliases(path, postmap, nil)
+
+	if err := aliases.CreateAlias("c@example.com", []string{"b@example.com"}, AliasOptions{}); err == nil || !strings.Contains(err.Error(), "broken table") {
+		t.Fatalf("expected the postmap failure to be reported, got %v", err)
+	}
+	if content, _ := ioutil.ReadFile(path); string(content) != initial {
+		t.Errorf("map not restored after failed postmap:\n%s", content)
+	}
+
+	// Once postmap works again, the same alias can be created
+	if err := ioutil.WriteFile(postmap, []byte("#!/bin/sh\nexit 0\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := aliases.CreateAlias("c@example.com", []string{"b@example.com"}, AliasOptions{}); err != nil {
+		t.Errorf("retry after failed postmap: %v", err)
+	}
+
+	// A map that did not exist before is removed again
+	missing := filepath.Join(dir, "new-virtual")
+	if err := ioutil.WriteFile(postmap, []byte("#!/bin/sh\nexit 1\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewPostfixAliases(missing, postmap, nil).CreateAlias("c@example.com", []string{"b@example.com"}, AliasOptions{}); err == nil {
+		t.Error("expected the postmap failure to be reported")
+	}
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Errorf("map created by the failed change was kept: %v", err)
+	}
+}
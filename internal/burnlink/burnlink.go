@@ -0,0 +1,91 @@
+package burnlink
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Actions a link can carry out on an alias
+const (
+	ActionDisable = "disable"
+	ActionDelete  = "delete"
+	ActionEnable  = "enable"
+)
+
+// Actions lists all link actions
+var Actions = []string{ActionDisable, ActionDelete, ActionEnable}
+
+var (
+	// ErrInvalid is returned for links that were not issued by the signer or were altered
+	ErrInvalid = errors.New("invalid link signature")
+	// ErrExpired is returned for links past their expiry
+	ErrExpired = errors.New("link has expired")
+)
+
+// Signer issues and verifies HMAC-signed, expiring action links for aliases
+type Signer struct {
+	secret   []byte
+	baseURL  string
+	validity time.Duration
+}
+
+// NewSigner creates a signer for links below baseURL that are valid for validity
+func NewSigner(secret, baseURL string, validity time.Duration) *Signer {
+	return &Signer{
+		secret:   []byte(secret),
+		baseURL:  strings.TrimRight(baseURL, "/"),
+		validity: validity,
+	}
+}
+
+// signature returns the signature of an action on an address until expires
+func (s *Signer) signature(action, address string, expires int64) string {
+	mac := hmac.New(sha256.New, s.secret)
+	fmt.Fprintf(mac, "%s\n%s\n%d", action, strings.ToLower(address), expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Link returns the URL carrying out action on address, valid from now on
+func (s *Signer) Link(action, address string, now time.Time) string {
+	expires := now.Add(s.validity).Unix()
+	query := url.Values{
+		"alias":   {strings.ToLower(address)},
+		"expires": {strconv.FormatInt(expires, 10)},
+		"sig":     {s.signature(action, address, expires)},
+	}
+	return fmt.Sprintf("%s/burn/%s?%s", s.baseURL, action, query.Encode())
+}
+
+// Links returns the URLs of all actions on address
+func (s *Signer) Links(address string, now time.Time) map[string]string {
+	links := make(map[string]string, len(Actions))
+	for _, action := range Actions {
+		links[action] = s.Link(action, address, now)
+	}
+	return links
+}
+
+// Verify checks the parameters of a link for action and returns the alias address it names
+func (s *Signer) Verify(action string, query url.Values, now time.Time) (string, error) {
+	address := query.Get("alias")
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if address == "" || err != nil {
+		return "", ErrInvalid
+	}
+
+	expected := s.signature(action, address, expires)
+	if !hmac.Equal([]byte(expected), []byte(query.Get("sig"))) {
+		return "", ErrInvalid
+	}
+	if now.Unix() > expires {
+		return "", ErrExpired
+	}
+	return strings.ToLower(address), nil
+}
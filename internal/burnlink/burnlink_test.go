@@ -0,0 +1,43 @@
+package burnlink
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignerLinks(t *testing.T) {
+	signer := NewSigner("secret", "https://bridge.example.com/", 24*time.Hour)
+	now := time.Now()
+
+	link := signer.Link(ActionDisable, "Site@example.com", now)
+	if !strings.HasPrefix(link, "https://bridge.example.com/burn/disable?") {
+		t.Fatalf("unexpected link %s", link)
+	}
+	parsed, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := parsed.Query()
+
+	address, err := signer.Verify(ActionDisable, query, now)
+	if err != nil || address != "site@example.com" {
+		t.Errorf("Verify = %q, %v", address, err)
+	}
+
+	// The signature binds the action, the alias and the expiry
+	if _, err := signer.Verify(ActionDelete, query, now); err != ErrInvalid {
+		t.Errorf("expected ErrInvalid for another action, got %v", err)
+	}
+	tampered := url.Values{"alias": {"other@example.com"}, "expires": query["expires"], "sig": query["sig"]}
+	if _, err := signer.Verify(ActionDisable, tampered, now); err != ErrInvalid {
+		t.Errorf("expected ErrInvalid for another alias, got %v", err)
+	}
+	if _, err := NewSigner("other", "", time.Hour).Verify(ActionDisable, query, now); err != ErrInvalid {
+		t.Errorf("expected ErrInvalid for another secret, got %v", err)
+	}
+	if _, err := signer.Verify(ActionDisable, query, now.Add(25*time.Hour)); err != ErrExpired {
+		t.Errorf("expected ErrExpired, got %v", err)
+	}
+}
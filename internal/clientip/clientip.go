@@ -0,0 +1,165 @@
+package clientip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Forwarding headers the trusted proxies may write
+const (
+	HeaderXForwardedFor = "x-forwarded-for"
+	HeaderForwarded     = "forwarded"
+)
+
+type contextKey struct{}
+
+// Resolver determines the real client IP of requests passing trusted reverse proxies
+type Resolver struct {
+	trusted []*net.IPNet
+	header  string
+}
+
+// NewResolver creates a resolver trusting the given proxy IPs or CIDR ranges. Only the given
+// forwarding header is read, a client may send the other one through a proxy that passes it on.
+func NewResolver(trustedProxies []string, header string) (*Resolver, error) {
+	header = strings.ToLower(header)
+	if header != HeaderXForwardedFor && header != HeaderForwarded {
+		return nil, fmt.Errorf("invalid forwarding header %q", header)
+	}
+
+	r := &Resolver{header: header}
+	for _, entry := range trustedProxies {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			r.trusted = append(r.trusted, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+		}
+		r.trusted = append(r.trusted, network)
+	}
+	return r, nil
+}
+
+// isTrusted reports whether an address belongs to a trusted proxy
+func (r *Resolver) isTrusted(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, network := range r.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve returns the real client IP of a request. Forwarding headers are only
+// honored if the request comes from a trusted proxy; the chain is walked from the
+// nearest hop until the first address that is not a trusted proxy.
+func (r *Resolver) Resolve(req *http.Request) string {
+	remote := remoteIP(req)
+	if !r.isTrusted(remote) {
+		return remote
+	}
+
+	var chain []string
+	if r.header == HeaderForwarded {
+		chain = forwardedFor(req.Header.Values("Forwarded"))
+	} else {
+		chain = xForwardedFor(req.Header.Values("X-Forwarded-For"))
+	}
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		if net.ParseIP(chain[i]) == nil {
+			// Obfuscated or malformed entries cannot be attributed, stop at the last known hop
+			break
+		}
+		remote = chain[i]
+		if !r.isTrusted(remote) {
+			break
+		}
+	}
+	return remote
+}
+
+// Middleware resolves the client IP once and makes it available through FromRequest
+func (r *Resolver) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := context.WithValue(req.Context(), contextKey{}, r.Resolve(req))
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+// FromRequest returns the client IP resolved by the middleware, or the peer address without it
+func FromRequest(req *http.Request) string {
+	if ip, ok := req.Context().Value(contextKey{}).(string); ok {
+		return ip
+	}
+	return remoteIP(req)
+}
+
+// remoteIP returns the IP address of the direct peer of a request
+func remoteIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// xForwardedFor parses X-Forwarded-For headers into the list of hops, client first
+func xForwardedFor(values []string) []string {
+	var chain []string
+	for _, value := range values {
+		for _, hop := range strings.Split(value, ",") {
+			chain = append(chain, stripPort(strings.TrimSpace(hop)))
+		}
+	}
+	return chain
+}
+
+// forwardedFor parses the "for" parameters of RFC 7239 Forwarded headers, client first
+func forwardedFor(values []string) []string {
+	var chain []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok || !strings.EqualFold(key, "for") {
+					continue
+				}
+				chain = append(chain, stripPort(strings.Trim(val, `"`)))
+			}
+		}
+	}
+	return chain
+}
+
+// stripPort removes an optional port and IPv6 brackets from a forwarded address
+func stripPort(address string) string {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(address, "["), "]")
+}
@@ -0,0 +1,63 @@
+package clientip
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	trusted := []string{"10.0.0.0/8", "192.0.2.1", "2001:db8::1"}
+	xForwardedFor, err := NewResolver(trusted, HeaderXForwardedFor)
+	if err != nil {
+		t.Fatalf("NewResolver: %v", err)
+	}
+	forwarded, err := NewResolver(trusted, HeaderForwarded)
+	if err != nil {
+		t.Fatalf("NewResolver: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		resolver *Resolver
+		remote   string
+		headers  map[string]string
+		want     string
+	}{
+		{"direct client", xForwardedFor, "198.51.100.7:1234", nil, "198.51.100.7"},
+		{"untrusted peer spoofing", xForwardedFor, "198.51.100.7:1234", map[string]string{"X-Forwarded-For": "203.0.113.5"}, "198.51.100.7"},
+		{"trusted proxy", xForwardedFor, "10.1.2.3:80", map[string]string{"X-Forwarded-For": "203.0.113.5"}, "203.0.113.5"},
+		{"proxy chain", xForwardedFor, "10.1.2.3:80", map[string]string{"X-Forwarded-For": "1.1.1.1, 203.0.113.5, 192.0.2.1"}, "203.0.113.5"},
+		// The proxy appends X-Forwarded-For and passes the client's own Forwarded header on
+		{"client forged forwarded", xForwardedFor, "10.1.2.3:80", map[string]string{"X-Forwarded-For": "203.0.113.5", "Forwarded": "for=1.2.3.4"}, "203.0.113.5"},
+		{"forwarded header", forwarded, "[2001:db8::1]:443", map[string]string{"Forwarded": `for="[2001:db8:cafe::17]:4711";proto=https, for=10.0.0.2`}, "2001:db8:cafe::17"},
+		{"client forged x-forwarded-for", forwarded, "10.1.2.3:80", map[string]string{"Forwarded": "for=203.0.113.5", "X-Forwarded-For": "1.2.3.4"}, "203.0.113.5"},
+		{"obfuscated hop", forwarded, "10.1.2.3:80", map[string]string{"Forwarded": "for=_hidden"}, "10.1.2.3"},
+		{"only proxies", xForwardedFor, "10.1.2.3:80", map[string]string{"X-Forwarded-For": "10.9.9.9"}, "10.9.9.9"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = test.remote
+			for name, value := range test.headers {
+				r.Header.Set(name, value)
+			}
+			if got := test.resolver.Resolve(r); got != test.want {
+				t.Errorf("Resolve() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestNewResolverRejectsInvalidEntries(t *testing.T) {
+	if _, err := NewResolver([]string{"proxy.example.com"}, HeaderXForwardedFor); err == nil {
+		t.Error("expected an error for a hostname")
+	}
+	if _, err := NewResolver([]string{"10.0.0.0/33"}, HeaderXForwardedFor); err == nil {
+		t.Error("expected an error for an invalid CIDR")
+	}
+	if _, err := NewResolver([]string{"10.0.0.0/8"}, "x-real-ip"); err == nil {
+		t.Error("expected an error for an unsupported header")
+	}
+}
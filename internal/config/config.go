@@ -1,12 +1,46 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"strconv"
 	"strings"
+
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/alias"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/clientip"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/policy"
+)
+
+// Alias backend types
+const (
+	BackendMailcow          = "mailcow"           // aliases managed through the Mailcow API
+	BackendPostfix          = "postfix"           // aliases written to a Postfix virtual alias map
+	BackendMailu            = "mailu"             // aliases managed through the Mailu REST API
+	BackendDockerMailserver = "docker-mailserver" // aliases written to postfix-virtual.cf of docker-mailserver
+	BackendMemory           = "memory"            // aliases kept in memory, dev mode only
 )
 
+// defaultDevUsers are the test users of the dev mode if DEV_USERS is unset
+const defaultDevUsers = "alice@example.com:alice,bob@example.com:bob"
+
+// BackendConfig is a mail server with its auth server, serving a set of login domains
+type BackendConfig struct {
+	Name          string   `json:"name"`
+	Type          string   `json:"type"`    // see Backend*, Mailcow if empty
+	Domains       []string `json:"domains"` // "*" serves all other domains
+	APIURL        string   `json:"api_url"` // Mailcow and Mailu backends
+	APIKey        string   `json:"api_key"`
+	AuthMethod    string   `json:"auth_method"`
+	ServerAddress string   `json:"server_address"`
+
+	// Postfix and docker-mailserver backends
+	VirtualMap     string   `json:"virtual_map"`
+	PostmapCommand string   `json:"postmap_command"` // run with the map path after changes, skipped if empty
+	ConfigDir      string   `json:"config_dir"`      // docker-mailserver config directory holding postfix-virtual.cf
+	AliasDomains   []string `json:"alias_domains"`   // domains aliases may be created on, all if empty
+}
+
 // Config stores the application configuration
 type Config struct {
 	Port                   int
@@ -16,17 +50,98 @@ type Config struct {
 	MailcowServerAddress   string
 	AliasValidityPeriod    int
 	AliasGenerationPattern string
+	AliasType              string // type of random aliases, see alias.Type*
+	AliasSubaddressPattern string // pattern of the tag of subaddresses
+	AliasReusePerHostname  bool   // return the existing alias for a hostname instead of a new one
+	// Alias domains per mailbox domain, "*" applies to all other domains and "%d" is the mailbox domain
+	AliasDomainMap map[string][]string
+	// Whether owners may send as their aliases, Mailcow's default if nil
+	AliasSenderAllowed *bool
+	// Alias limits per mailbox, 0 means unlimited
+	AliasQuotaTotal  int
+	AliasQuotaHourly int
+	AliasQuotaDaily  int
+	// Garbage collection of unused aliases
+	AliasGCAfterDays int    // age after which unused aliases are collected, 0 disables
+	AliasGCAction    string // see policy.GCAction*
+	AliasGCDryRun    bool   // only report what would be collected
+	AliasGCInterval  int    // in hours
+	// Alias activity statistics from the Mailcow logs
+	ActivitySyncInterval int // in minutes, 0 disables the periodic sync
+	ActivityLogLines     int // entries read from each log per sync
+	// Seconds between checks of the mail and auth servers, requests fail fast while one is unreachable
+	HealthCheckInterval int
+	// Metadata store, kept in memory only if unset
+	StorePath string
+	// Mailcow instances by login domain, a single one from the MAILCOW_* variables if no file is given
+	BackendsFile string
+	Backends     []BackendConfig
+	// OAuth2 authorization-code flow configuration (OAUTH2 auth method)
+	OAuthClientID     string
+	OAuthClientSecret string
+	OAuthRedirectURL  string
+	// LDAP configuration (LDAP auth method)
+	LDAPStartTLS           bool
+	LDAPBindDNTemplate     string
+	LDAPSearchBindDN       string
+	LDAPSearchBindPassword string
+	LDAPBaseDN             string
+	LDAPUserFilter         string
+	LDAPGroupFilter        string
+	LDAPGroupBaseDN        string
 	// Auth caching configuration
-	AuthCacheTTL int // in seconds, 0 means disabled
+	AuthCacheTTL          int    // in seconds, 0 means disabled
+	AuthCacheMaxEntries   int    // LRU bound, 0 means unbounded
+	AuthCacheSecret       string // key for cache keys, random per process if unset
+	AuthCacheSnapshotPath string // encrypted on-disk snapshot, disabled if unset
+	// Brute-force protection configuration
+	AuthLockoutThreshold       int // failures per username before locking, 0 disables
+	AuthLockoutClientThreshold int // failures per client IP before locking, 0 disables
+	AuthLockoutBaseDelay       int // in seconds
+	AuthLockoutMaxDelay        int // in seconds
+	AuthFailureCacheTTL        int // in seconds, 0 disables
+	// Upstream authentication concurrency
+	AuthMaxConcurrent int // concurrent upstream authentications, 0 means unlimited
+	AuthQueueTimeout  int // in seconds to wait for a free upstream slot
+	// Request rate limiting per client IP
+	RateLimitRPS   float64 // requests per second, 0 disables
+	RateLimitBurst int
+	// Reverse proxies whose forwarding headers are trusted, as IPs or CIDR ranges
+	TrustedProxies []string
+	// Forwarding header written by the trusted proxies, see clientip.Header*
+	TrustedProxyHeader string
 	// CORS configuration
 	CORSAllowOrigin string
+	// Admin API configuration
+	AdminAPIKey string
+	// Externally reachable base URL of the bridge, used in links it issues
+	PublicURL string
+	// Signed alias action links, disabled if the secret is unset
+	BurnLinkSecret   string
+	BurnLinkValidity int // in days
+	// Authorization policy file, everyone may create aliases on their own domain if unset
+	PolicyFile string
 	// Logging configuration
 	LogLevel    string
 	LogColorize bool
+	// Test users of the dev mode, passwords by username
+	DevUsers map[string]string
 }
 
 // LoadConfig loads the configuration from environment variables
 func LoadConfig() (*Config, error) {
+	return loadConfig(false)
+}
+
+// LoadDevConfig loads the configuration of the dev mode, which needs no mail server: a single
+// in-memory backend serves all domains, and the test users of DEV_USERS log in against an
+// in-process IMAP server, whose address is only known once it runs. Other settings are read as usual.
+func LoadDevConfig() (*Config, error) {
+	return loadConfig(true)
+}
+
+// loadConfig loads the configuration from environment variables, for the dev mode if dev is set
+func loadConfig(dev bool) (*Config, error) {
 	port, err := strconv.Atoi(os.Getenv("PORT"))
 	if err != nil {
 		port = 8080 // Default port
@@ -57,6 +172,54 @@ func LoadConfig() (*Config, error) {
 		}
 	}
 
+	// Brute-force protection configuration
+	authLockoutThreshold := getEnvInt("AUTH_LOCKOUT_THRESHOLD", 5)
+	authLockoutClientThreshold := getEnvInt("AUTH_LOCKOUT_CLIENT_THRESHOLD", 20)
+	authLockoutBaseDelay := getEnvInt("AUTH_LOCKOUT_BASE_DELAY", 60)
+	authLockoutMaxDelay := getEnvInt("AUTH_LOCKOUT_MAX_DELAY", 3600)
+	authFailureCacheTTL := getEnvInt("AUTH_FAILURE_CACHE_TTL", 60)
+	authMaxConcurrent := getEnvInt("AUTH_MAX_CONCURRENT", 10)
+	authQueueTimeout := getEnvInt("AUTH_QUEUE_TIMEOUT", 10)
+
+	aliasDomainMap, err := parseDomainMap(os.Getenv("ALIAS_DOMAIN_MAP"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ALIAS_DOMAIN_MAP: %w", err)
+	}
+
+	var aliasSenderAllowed *bool
+	if value := os.Getenv("ALIAS_SENDER_ALLOWED"); value != "" {
+		allowed, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("ALIAS_SENDER_ALLOWED must be true or false")
+		}
+		aliasSenderAllowed = &allowed
+	}
+
+	// Rate limiting configuration
+	rateLimitRPS := 0.0
+	if value := os.Getenv("RATE_LIMIT_RPS"); value != "" {
+		rps, err := strconv.ParseFloat(value, 64)
+		if err != nil || rps < 0 {
+			return nil, fmt.Errorf("RATE_LIMIT_RPS must be a non-negative number")
+		}
+		rateLimitRPS = rps
+	}
+
+	var trustedProxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			trustedProxies = append(trustedProxies, proxy)
+		}
+	}
+	trustedProxyHeader := strings.ToLower(os.Getenv("TRUSTED_PROXY_HEADER"))
+	switch trustedProxyHeader {
+	case "":
+		trustedProxyHeader = clientip.HeaderXForwardedFor
+	case clientip.HeaderXForwardedFor, clientip.HeaderForwarded:
+	default:
+		return nil, fmt.Errorf("TRUSTED_PROXY_HEADER must be %q or %q", clientip.HeaderXForwardedFor, clientip.HeaderForwarded)
+	}
+
 	// Logging configuration
 	logLevel := os.Getenv("LOG_LEVEL")
 	if logLevel == "" {
@@ -69,34 +232,315 @@ func LoadConfig() (*Config, error) {
 	if strings.ToLower(logColorStr) == "false" {
 		logColorize = false
 	}
-	
+
 	cfg := &Config{
-		Port:                   port,
-		MailcowAdminAPIURL:     os.Getenv("MAILCOW_ADMIN_API_URL"),
-		MailcowAdminAPIKey:     os.Getenv("MAILCOW_ADMIN_API_KEY"),
-		MailcowAuthMethod:      authMethod,
-		MailcowServerAddress:   os.Getenv("MAILCOW_SERVER_ADDRESS"),
-		AliasValidityPeriod:    aliasValidityPeriod,
-		AliasGenerationPattern: os.Getenv("ALIAS_GENERATION_PATTERN"),
-		AuthCacheTTL:           authCacheTTL,
-		LogLevel:               logLevel,
-		LogColorize:            logColorize,
-		CORSAllowOrigin:        os.Getenv("CORS_ALLOW_ORIGIN"),
+		Port:                       port,
+		MailcowAdminAPIURL:         os.Getenv("MAILCOW_ADMIN_API_URL"),
+		MailcowAdminAPIKey:         os.Getenv("MAILCOW_ADMIN_API_KEY"),
+		MailcowAuthMethod:          authMethod,
+		MailcowServerAddress:       os.Getenv("MAILCOW_SERVER_ADDRESS"),
+		AliasValidityPeriod:        aliasValidityPeriod,
+		AliasGenerationPattern:     os.Getenv("ALIAS_GENERATION_PATTERN"),
+		AliasDomainMap:             aliasDomainMap,
+		AliasType:                  strings.ToLower(os.Getenv("ALIAS_TYPE")),
+		AliasSubaddressPattern:     os.Getenv("ALIAS_SUBADDRESS_PATTERN"),
+		AliasReusePerHostname:      strings.ToLower(os.Getenv("ALIAS_REUSE_PER_HOSTNAME")) == "true",
+		AliasSenderAllowed:         aliasSenderAllowed,
+		AliasQuotaTotal:            getEnvInt("ALIAS_QUOTA_TOTAL", 0),
+		AliasQuotaHourly:           getEnvInt("ALIAS_QUOTA_HOURLY", 0),
+		AliasQuotaDaily:            getEnvInt("ALIAS_QUOTA_DAILY", 0),
+		AliasGCAfterDays:           getEnvInt("ALIAS_GC_AFTER_DAYS", 0),
+		AliasGCAction:              strings.ToLower(os.Getenv("ALIAS_GC_ACTION")),
+		AliasGCDryRun:              strings.ToLower(os.Getenv("ALIAS_GC_DRY_RUN")) == "true",
+		AliasGCInterval:            getEnvInt("ALIAS_GC_INTERVAL", 24),
+		ActivitySyncInterval:       getEnvInt("ACTIVITY_SYNC_INTERVAL", 15),
+		ActivityLogLines:           getEnvInt("ACTIVITY_LOG_LINES", 10000),
+		HealthCheckInterval:        getEnvInt("HEALTH_CHECK_INTERVAL", 15),
+		StorePath:                  os.Getenv("STORE_PATH"),
+		BackendsFile:               os.Getenv("MAILCOW_BACKENDS_FILE"),
+		OAuthClientID:              os.Getenv("MAILCOW_OAUTH_CLIENT_ID"),
+		OAuthClientSecret:          os.Getenv("MAILCOW_OAUTH_CLIENT_SECRET"),
+		OAuthRedirectURL:           os.Getenv("MAILCOW_OAUTH_REDIRECT_URL"),
+		LDAPStartTLS:               strings.ToLower(os.Getenv("LDAP_STARTTLS")) == "true",
+		LDAPBindDNTemplate:         os.Getenv("LDAP_BIND_DN_TEMPLATE"),
+		LDAPSearchBindDN:           os.Getenv("LDAP_SEARCH_BIND_DN"),
+		LDAPSearchBindPassword:     os.Getenv("LDAP_SEARCH_BIND_PASSWORD"),
+		LDAPBaseDN:                 os.Getenv("LDAP_BASE_DN"),
+		LDAPUserFilter:             os.Getenv("LDAP_USER_FILTER"),
+		LDAPGroupFilter:            os.Getenv("LDAP_GROUP_FILTER"),
+		LDAPGroupBaseDN:            os.Getenv("LDAP_GROUP_BASE_DN"),
+		AuthCacheTTL:               authCacheTTL,
+		AuthCacheMaxEntries:        getEnvInt("AUTH_CACHE_MAX_ENTRIES", 10000),
+		AuthCacheSecret:            os.Getenv("AUTH_CACHE_SECRET"),
+		PublicURL:                  os.Getenv("PUBLIC_URL"),
+		BurnLinkSecret:             os.Getenv("BURN_LINK_SECRET"),
+		BurnLinkValidity:           getEnvInt("BURN_LINK_VALIDITY", 30),
+		AuthCacheSnapshotPath:      os.Getenv("AUTH_CACHE_SNAPSHOT_PATH"),
+		AuthLockoutThreshold:       authLockoutThreshold,
+		AuthLockoutClientThreshold: authLockoutClientThreshold,
+		AuthLockoutBaseDelay:       authLockoutBaseDelay,
+		AuthLockoutMaxDelay:        authLockoutMaxDelay,
+		AuthFailureCacheTTL:        authFailureCacheTTL,
+		AuthMaxConcurrent:          authMaxConcurrent,
+		AuthQueueTimeout:           authQueueTimeout,
+		LogLevel:                   logLevel,
+		LogColorize:                logColorize,
+		RateLimitRPS:               rateLimitRPS,
+		RateLimitBurst:             getEnvInt("RATE_LIMIT_BURST", 10),
+		TrustedProxies:             trustedProxies,
+		TrustedProxyHeader:         trustedProxyHeader,
+		CORSAllowOrigin:            os.Getenv("CORS_ALLOW_ORIGIN"),
+		AdminAPIKey:                os.Getenv("ADMIN_API_KEY"),
+		PolicyFile:                 os.Getenv("POLICY_FILE"),
 	}
 
 	// Check if required environment variables are set
-	if cfg.MailcowAdminAPIURL == "" {
-		return nil, fmt.Errorf("MAILCOW_ADMIN_API_URL environment variable not set")
+	if dev {
+		users := os.Getenv("DEV_USERS")
+		if users == "" {
+			users = defaultDevUsers
+		}
+		cfg.DevUsers, err = parseDevUsers(users)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DEV_USERS: %w", err)
+		}
+		cfg.Backends = []BackendConfig{{
+			Name:       "dev",
+			Type:       BackendMemory,
+			Domains:    []string{"*"},
+			AuthMethod: "IMAP",
+		}}
+	} else if cfg.BackendsFile != "" {
+		cfg.Backends, err = loadBackends(cfg.BackendsFile, cfg.MailcowAuthMethod)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MAILCOW_BACKENDS_FILE: %w", err)
+		}
+	} else {
+		backendType := strings.ToLower(os.Getenv("ALIAS_BACKEND"))
+		if backendType == "" {
+			backendType = BackendMailcow
+		}
+		apiURL, apiKey := cfg.MailcowAdminAPIURL, cfg.MailcowAdminAPIKey
+		switch backendType {
+		case BackendMailcow:
+			if cfg.MailcowAdminAPIURL == "" {
+				return nil, fmt.Errorf("MAILCOW_ADMIN_API_URL environment variable not set")
+			}
+			if cfg.MailcowAdminAPIKey == "" {
+				return nil, fmt.Errorf("MAILCOW_ADMIN_API_KEY environment variable not set")
+			}
+		case BackendPostfix:
+			if os.Getenv("POSTFIX_VIRTUAL_MAP") == "" {
+				return nil, fmt.Errorf("POSTFIX_VIRTUAL_MAP must be set for the postfix alias backend")
+			}
+		case BackendMailu:
+			apiURL, apiKey = os.Getenv("MAILU_API_URL"), os.Getenv("MAILU_API_TOKEN")
+			if apiURL == "" || apiKey == "" {
+				return nil, fmt.Errorf("MAILU_API_URL and MAILU_API_TOKEN must be set for the mailu alias backend")
+			}
+		case BackendDockerMailserver:
+			if os.Getenv("DMS_CONFIG_DIR") == "" {
+				return nil, fmt.Errorf("DMS_CONFIG_DIR must be set for the docker-mailserver alias backend")
+			}
+		default:
+			return nil, fmt.Errorf("ALIAS_BACKEND must be %q, %q, %q or %q", BackendMailcow, BackendPostfix, BackendMailu, BackendDockerMailserver)
+		}
+		if cfg.MailcowServerAddress == "" {
+			return nil, fmt.Errorf("MAILCOW_SERVER_ADDRESS environment variable not set")
+		}
+
+		var aliasDomains []string
+		for _, domain := range strings.Split(os.Getenv("POSTFIX_ALIAS_DOMAINS"), ",") {
+			if domain = strings.TrimSpace(domain); domain != "" {
+				aliasDomains = append(aliasDomains, domain)
+			}
+		}
+		cfg.Backends = []BackendConfig{{
+			Name:           "default",
+			Type:           backendType,
+			Domains:        []string{"*"},
+			APIURL:         apiURL,
+			APIKey:         apiKey,
+			AuthMethod:     cfg.MailcowAuthMethod,
+			ServerAddress:  cfg.MailcowServerAddress,
+			VirtualMap:     os.Getenv("POSTFIX_VIRTUAL_MAP"),
+			PostmapCommand: os.Getenv("POSTFIX_POSTMAP_COMMAND"),
+			ConfigDir:      os.Getenv("DMS_CONFIG_DIR"),
+			AliasDomains:   aliasDomains,
+		}}
+	}
+	for _, backend := range cfg.Backends {
+		if strings.EqualFold(backend.AuthMethod, "LDAP") && cfg.LDAPBindDNTemplate == "" && cfg.LDAPBaseDN == "" {
+			return nil, fmt.Errorf("LDAP_BIND_DN_TEMPLATE or LDAP_BASE_DN must be set for the LDAP auth method")
+		}
+		if strings.EqualFold(backend.AuthMethod, "OAUTH2") && backend.Type != BackendMailcow {
+			return nil, fmt.Errorf("backend %q: the OAUTH2 auth method requires Mailcow", backend.Name)
+		}
+	}
+	if cfg.AuthCacheSnapshotPath != "" && cfg.AuthCacheSecret == "" {
+		return nil, fmt.Errorf("AUTH_CACHE_SECRET must be set when AUTH_CACHE_SNAPSHOT_PATH is set")
+	}
+	if cfg.OAuthClientID != "" && (cfg.OAuthClientSecret == "" || cfg.OAuthRedirectURL == "") {
+		return nil, fmt.Errorf("MAILCOW_OAUTH_CLIENT_SECRET and MAILCOW_OAUTH_REDIRECT_URL must be set when MAILCOW_OAUTH_CLIENT_ID is set")
+	}
+	if cfg.AliasType == "" {
+		cfg.AliasType = alias.TypePermanent
+	}
+	if !alias.ValidType(cfg.AliasType) {
+		return nil, fmt.Errorf("ALIAS_TYPE must be %q, %q or %q", alias.TypePermanent, alias.TypeTemporary, alias.TypeSubaddress)
 	}
-	if cfg.MailcowAdminAPIKey == "" {
-		return nil, fmt.Errorf("MAILCOW_ADMIN_API_KEY environment variable not set")
+	if cfg.AliasSubaddressPattern == "" {
+		cfg.AliasSubaddressPattern = alias.HostnamePlaceholder
 	}
-	if cfg.MailcowServerAddress == "" {
-		return nil, fmt.Errorf("MAILCOW_SERVER_ADDRESS environment variable not set")
+	if cfg.AliasGCAction == "" {
+		cfg.AliasGCAction = policy.GCActionDeactivate
+	}
+	if !policy.ValidGCAction(cfg.AliasGCAction) {
+		return nil, fmt.Errorf("ALIAS_GC_ACTION must be %q, %q or %q", policy.GCActionKeep, policy.GCActionDeactivate, policy.GCActionDelete)
+	}
+	if cfg.HealthCheckInterval < 1 {
+		return nil, fmt.Errorf("HEALTH_CHECK_INTERVAL must be at least 1 second")
+	}
+	if cfg.AliasGCAfterDays > 0 && cfg.AliasGCInterval < 1 {
+		return nil, fmt.Errorf("ALIAS_GC_INTERVAL must be at least 1 hour")
+	}
+	if cfg.BurnLinkSecret != "" && cfg.PublicURL == "" {
+		return nil, fmt.Errorf("PUBLIC_URL must be set when BURN_LINK_SECRET is set")
+	}
+	if cfg.BurnLinkSecret != "" && cfg.BurnLinkValidity < 1 {
+		return nil, fmt.Errorf("BURN_LINK_VALIDITY must be at least 1 day")
+	}
+	if cfg.ActivityLogLines < 1 {
+		return nil, fmt.Errorf("ACTIVITY_LOG_LINES must be at least 1")
 	}
 	if cfg.AliasGenerationPattern == "" {
-		cfg.AliasGenerationPattern = "{firstname}.{lastname}@%s" // Default alias generation pattern
+		cfg.AliasGenerationPattern = "{firstname}.{lastname}@%d" // Default alias generation pattern
 	}
 
 	return cfg, nil
 }
+
+// getEnvInt reads an integer environment variable, falling back to a default if unset or invalid
+func getEnvInt(name string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// parseDomainMap parses "mailbox.com=alias.com,relay.net;other.org=%d" into a map of lowercase domains
+func parseDomainMap(value string) (map[string][]string, error) {
+	domainMap := make(map[string][]string)
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		mailboxDomain, aliasDomains, ok := strings.Cut(entry, "=")
+		mailboxDomain = strings.ToLower(strings.TrimSpace(mailboxDomain))
+		if !ok || mailboxDomain == "" {
+			return nil, fmt.Errorf("entry %q must be of the form domain=alias-domain[,alias-domain...]", entry)
+		}
+
+		for _, aliasDomain := range strings.Split(aliasDomains, ",") {
+			if aliasDomain = strings.ToLower(strings.TrimSpace(aliasDomain)); aliasDomain != "" {
+				domainMap[mailboxDomain] = append(domainMap[mailboxDomain], aliasDomain)
+			}
+		}
+		if len(domainMap[mailboxDomain]) == 0 {
+			return nil, fmt.Errorf("entry %q lists no alias domains", entry)
+		}
+	}
+	return domainMap, nil
+}
+
+// parseDevUsers parses test users of the form "user@example.com:password,..."
+func parseDevUsers(value string) (map[string]string, error) {
+	users := make(map[string]string)
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		username, password, ok := strings.Cut(entry, ":")
+		username = strings.ToLower(strings.TrimSpace(username))
+		if !ok || !strings.Contains(username, "@") || password == "" {
+			return nil, fmt.Errorf("entry %q must be of the form user@domain:password", entry)
+		}
+		users[username] = password
+	}
+	if len(users) == 0 {
+		return nil, fmt.Errorf("no users defined")
+	}
+	return users, nil
+}
+
+// loadBackends reads the backends from a JSON file of the form {"backends": [...]}.
+// Backends without type are Mailcow backends, backends without auth method use defaultAuthMethod.
+func loadBackends(path, defaultAuthMethod string) ([]BackendConfig, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file struct {
+		Backends []BackendConfig `json:"backends"`
+	}
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, err
+	}
+	if len(file.Backends) == 0 {
+		return nil, fmt.Errorf("no backends defined")
+	}
+
+	names := make(map[string]bool)
+	domains := make(map[string]string)
+	for i := range file.Backends {
+		backend := &file.Backends[i]
+		if backend.Name == "" || backend.ServerAddress == "" {
+			return nil, fmt.Errorf("backend %d: name and server_address must be set", i+1)
+		}
+		backend.Type = strings.ToLower(backend.Type)
+		switch backend.Type {
+		case "", BackendMailcow:
+			backend.Type = BackendMailcow
+			if backend.APIURL == "" || backend.APIKey == "" {
+				return nil, fmt.Errorf("backend %q: api_url and api_key must be set", backend.Name)
+			}
+		case BackendPostfix:
+			if backend.VirtualMap == "" {
+				return nil, fmt.Errorf("backend %q: virtual_map must be set", backend.Name)
+			}
+		case BackendMailu:
+			if backend.APIURL == "" || backend.APIKey == "" {
+				return nil, fmt.Errorf("backend %q: api_url and api_key must be set", backend.Name)
+			}
+		case BackendDockerMailserver:
+			if backend.ConfigDir == "" {
+				return nil, fmt.Errorf("backend %q: config_dir must be set", backend.Name)
+			}
+		default:
+			return nil, fmt.Errorf("backend %q: type must be %q, %q, %q or %q", backend.Name, BackendMailcow, BackendPostfix, BackendMailu, BackendDockerMailserver)
+		}
+		if names[backend.Name] {
+			return nil, fmt.Errorf("backend name %q is used twice", backend.Name)
+		}
+		names[backend.Name] = true
+		if backend.AuthMethod == "" {
+			backend.AuthMethod = defaultAuthMethod
+		}
+		if len(backend.Domains) == 0 {
+			return nil, fmt.Errorf("backend %q serves no domains", backend.Name)
+		}
+		for j, domain := range backend.Domains {
+			domain = strings.ToLower(strings.TrimSpace(domain))
+			if other, ok := domains[domain]; ok {
+				return nil, fmt.Errorf("domain %q is served by backends %q and %q", domain, other, backend.Name)
+			}
+			domains[domain] = backend.Name
+			backend.Domains[j] = domain
+		}
+	}
+	return file.Backends, nil
+}
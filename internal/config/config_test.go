@@ -0,0 +1,100 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseDomainMap(t *testing.T) {
+	got, err := parseDomainMap(" Example.com = alias.example.com, Relay.example.net ; *=%d")
+	if err != nil {
+		t.Fatalf("parseDomainMap: %v", err)
+	}
+	want := map[string][]string{
+		"example.com": {"alias.example.com", "relay.example.net"},
+		"*":           {"%d"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseDomainMap() = %v, want %v", got, want)
+	}
+
+	for _, invalid := range []string{"example.com", "example.com=", "=alias.example.com"} {
+		if _, err := parseDomainMap(invalid); err == nil {
+			t.Errorf("parseDomainMap(%q) accepted an invalid entry", invalid)
+		}
+	}
+}
+
+func TestParseDevUsers(t *testing.T) {
+	got, err := parseDevUsers(" Alice@example.com:a:b , bob@example.com:secret,")
+	if err != nil {
+		t.Fatalf("parseDevUsers: %v", err)
+	}
+	want := map[string]string{"alice@example.com": "a:b", "bob@example.com": "secret"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseDevUsers() = %v, want %v", got, want)
+	}
+
+	for _, invalid := range []string{"", "alice@example.com", "alice:secret", "alice@example.com:"} {
+		if _, err := parseDevUsers(invalid); err == nil {
+			t.Errorf("parseDevUsers(%q) accepted an invalid entry", invalid)
+		}
+	}
+}
+
+func TestLoadBackends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backends.json")
+	content := `{"backends": [
+		{"name": "org-a", "domains": ["A.example"], "api_url": "https://a", "api_key": "k", "server_address": "a:993"},
+		{"name": "org-b", "domains": ["*"], "api_url": "https://b", "api_key": "k", "server_address": "b:465", "auth_method": "SMTP"}
+	]}`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	backends, err := loadBackends(path, "IMAP")
+	if err != nil {
+		t.Fatalf("loadBackends: %v", err)
+	}
+	if len(backends) != 2 || backends[0].Domains[0] != "a.example" || backends[0].AuthMethod != "IMAP" || backends[1].AuthMethod != "SMTP" {
+		t.Errorf("unexpected backends: %+v", backends)
+	}
+
+	postfix := `{"backends": [
+		{"name": "mailcow", "domains": ["a.example"], "api_url": "https://a", "api_key": "k", "server_address": "a:993"},
+		{"name": "postfix", "type": "Postfix", "domains": ["b.example"], "virtual_map": "/etc/postfix/virtual", "server_address": "b:993"},
+		{"name": "mailu", "type": "mailu", "domains": ["c.example"], "api_url": "https://c", "api_key": "k", "server_address": "c:993"},
+		{"name": "dms", "type": "docker-mailserver", "domains": ["d.example"], "config_dir": "/dms/config", "server_address": "d:993"}
+	]}`
+	if err := os.WriteFile(path, []byte(postfix), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	backends, err = loadBackends(path, "IMAP")
+	if err != nil {
+		t.Fatalf("loadBackends: %v", err)
+	}
+	if backends[0].Type != BackendMailcow || backends[1].Type != BackendPostfix || backends[2].Type != BackendMailu || backends[3].Type != BackendDockerMailserver {
+		t.Errorf("unexpected backend types: %+v", backends)
+	}
+
+	missingMap := `{"backends": [{"name": "postfix", "type": "postfix", "domains": ["*"], "server_address": "b:993"}]}`
+	if err := os.WriteFile(path, []byte(missingMap), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadBackends(path, "IMAP"); err == nil {
+		t.Error("loadBackends accepted a postfix backend without virtual map")
+	}
+
+	duplicate := `{"backends": [
+		{"name": "org-a", "domains": ["a.example"], "api_url": "https://a", "api_key": "k", "server_address": "a:993"},
+		{"name": "org-b", "domains": ["a.example"], "api_url": "https://b", "api_key": "k", "server_address": "b:993"}
+	]}`
+	if err := os.WriteFile(path, []byte(duplicate), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadBackends(path, "IMAP"); err == nil {
+		t.Error("loadBackends accepted a domain served by two backends")
+	}
+}
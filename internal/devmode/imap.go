@@ -0,0 +1,168 @@
+package devmode
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	imapbackend "github.com/emersion/go-imap/backend"
+	"github.com/emersion/go-imap/server"
+
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/logger"
+)
+
+// errInvalidCredentials is answered to logins with unknown users or wrong passwords
+var errInvalidCredentials = errors.New("invalid credentials")
+
+// IMAPServer is an in-process IMAP server accepting the logins of test users. It knows no
+// mailboxes, the bridge only uses IMAP to check passwords.
+type IMAPServer struct {
+	listener  net.Listener
+	server    *server.Server
+	clientTLS *tls.Config
+	logger    *logger.Logger
+}
+
+// StartIMAPServer starts an IMAP server with a self-signed certificate on a random local port.
+// users maps usernames, compared case-insensitively, to passwords.
+func StartIMAPServer(users map[string]string) (*IMAPServer, error) {
+	certificate, pool, err := selfSignedCertificate()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{certificate}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %w", err)
+	}
+
+	lowered := make(map[string]string, len(users))
+	for username, password := range users {
+		lowered[strings.ToLower(username)] = password
+	}
+
+	s := &IMAPServer{
+		listener:  listener,
+		clientTLS: &tls.Config{RootCAs: pool},
+		logger:    logger.WithComponent("DevIMAP"),
+	}
+	s.server = server.New(&imapUsers{users: lowered, logger: s.logger})
+	s.server.ErrorLog = imapLogger{s.logger}
+
+	go func() {
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, net.ErrClosed) {
+			s.logger.Error("IMAP server stopped: %v", err)
+		}
+	}()
+	s.logger.Info("Dev IMAP server listening on %s with %d test users", s.Addr(), len(lowered))
+	return s, nil
+}
+
+// Addr returns the address of the server, to be used as auth server address
+func (s *IMAPServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// ClientTLSConfig returns a TLS configuration trusting the certificate of the server
+func (s *IMAPServer) ClientTLSConfig() *tls.Config {
+	return s.clientTLS.Clone()
+}
+
+// Close stops the server
+func (s *IMAPServer) Close() error {
+	return s.server.Close()
+}
+
+// selfSignedCertificate creates a certificate for 127.0.0.1 and a pool trusting it
+func selfSignedCertificate() (tls.Certificate, *x509.CertPool, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 62))
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "SimpleLogin bridge dev IMAP"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	parsed, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(parsed)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: parsed}, pool, nil
+}
+
+// imapUsers is the IMAP backend checking the passwords of the test users
+type imapUsers struct {
+	users  map[string]string
+	logger *logger.Logger
+}
+
+// Login implements backend.Backend
+func (b *imapUsers) Login(_ *imap.ConnInfo, username, password string) (imapbackend.User, error) {
+	expected, ok := b.users[strings.ToLower(username)]
+	if !ok || subtle.ConstantTimeCompare([]byte(expected), []byte(password)) != 1 {
+		b.logger.Debug("Rejected login of %s", username)
+		return nil, errInvalidCredentials
+	}
+	return imapUser(username), nil
+}
+
+// imapUser is a logged in test user without mailboxes
+type imapUser string
+
+func (u imapUser) Username() string { return string(u) }
+
+func (u imapUser) ListMailboxes(bool) ([]imapbackend.Mailbox, error) { return nil, nil }
+
+func (u imapUser) GetMailbox(string) (imapbackend.Mailbox, error) {
+	return nil, imapbackend.ErrNoSuchMailbox
+}
+
+func (u imapUser) CreateMailbox(string) error { return errors.New("mailboxes are not supported") }
+
+func (u imapUser) DeleteMailbox(string) error { return imapbackend.ErrNoSuchMailbox }
+
+func (u imapUser) RenameMailbox(string, string) error { return imapbackend.ErrNoSuchMailbox }
+
+func (u imapUser) Logout() error { return nil }
+
+// imapLogger passes the error log of the IMAP server to the logger
+type imapLogger struct {
+	logger *logger.Logger
+}
+
+func (l imapLogger) Printf(format string, v ...interface{}) {
+	l.logger.Warn(strings.TrimSuffix(format, "\n"), v...)
+}
+
+func (l imapLogger) Println(v ...interface{}) {
+	l.logger.Warn("%s", strings.TrimSuffix(fmt.Sprintln(v...), "\n"))
+}
@@ -0,0 +1,32 @@
+package devmode
+
+import (
+	"errors"
+	"testing"
+
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/auth"
+)
+
+func TestIMAPServer(t *testing.T) {
+	srv, err := StartIMAPServer(map[string]string{"Alice@example.com": "secret"})
+	if err != nil {
+		t.Fatalf("StartIMAPServer: %v", err)
+	}
+	defer srv.Close()
+
+	authModule, err := auth.NewAuthModule("IMAP", srv.Addr(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authModule.SetTLSConfig(srv.ClientTLSConfig())
+
+	if err := authModule.Authenticate("alice@example.com", "secret", ""); err != nil {
+		t.Errorf("expected test user to authenticate, got %v", err)
+	}
+	if err := authModule.Authenticate("alice@example.com", "wrong", ""); !errors.Is(err, auth.ErrInvalidCredentials) {
+		t.Errorf("expected ErrInvalidCredentials for wrong password, got %v", err)
+	}
+	if err := authModule.Authenticate("mallory@example.com", "secret", ""); !errors.Is(err, auth.ErrInvalidCredentials) {
+		t.Errorf("expected ErrInvalidCredentials for unknown user, got %v", err)
+	}
+}
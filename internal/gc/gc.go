@@ -0,0 +1,185 @@
+package gc
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/activity"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/alias"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/backend"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/logger"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/mailcow"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/policy"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/store"
+)
+
+// Options configure the garbage collection of unused aliases
+type Options struct {
+	MaxAge time.Duration // aliases younger than this are kept
+	Action string        // default action, see policy.GCAction*
+}
+
+// Candidate is an unused alias found by a collection run
+type Candidate struct {
+	Address   string    `json:"address"`
+	Owner     string    `json:"owner"`
+	CreatedAt time.Time `json:"created_at"`
+	Action    string    `json:"action"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Report summarizes a collection run
+type Report struct {
+	DryRun     bool        `json:"dry_run"`
+	Scanned    int         `json:"scanned"`
+	Unobserved int         `json:"unobserved"` // kept, created before their activity was tracked
+	Candidates []Candidate `json:"candidates"`
+}
+
+// Collector deactivates or deletes bridge-created aliases that never received mail,
+// e.g. because the user cancelled the Bitwarden dialog after generating them
+type Collector struct {
+	backends *backend.Set
+	store    *store.Store
+	policy   *policy.Policy
+	activity *activity.Tracker
+	opts     Options
+	mu       sync.Mutex // one run at a time
+	logger   *logger.Logger
+}
+
+// NewCollector creates a garbage collector for unused aliases
+func NewCollector(backends *backend.Set, st *store.Store, pol *policy.Policy, tracker *activity.Tracker, opts Options) *Collector {
+	return &Collector{
+		backends: backends,
+		store:    st,
+		policy:   pol,
+		activity: tracker,
+		opts:     opts,
+		logger:   logger.WithComponent("AliasGC"),
+	}
+}
+
+// Run collects unused aliases. With dryRun the candidates are only reported.
+func (c *Collector) Run(dryRun bool) (*Report, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	// Catch up on deliveries since the last sync before judging aliases unused
+	if _, err := c.activity.Sync(); err != nil {
+		return nil, err
+	}
+
+	report := &Report{DryRun: dryRun, Candidates: []Candidate{}}
+	actions := make(map[string]string) // per owner
+	for _, record := range c.store.Aliases() {
+		// Subaddresses and temporary aliases need no collection
+		if record.Type == alias.TypeSubaddress || record.Type == alias.TypeTemporary || record.Disabled {
+			continue
+		}
+		report.Scanned++
+
+		if record.LastActivity != nil || record.CreatedAt.After(now.Add(-c.opts.MaxAge)) {
+			continue
+		}
+		// Only the lack of activity over an alias' whole lifetime shows it is unused
+		if !c.observed(record) {
+			report.Unobserved++
+			continue
+		}
+
+		action, ok := actions[record.Owner]
+		if !ok {
+			action = c.actionFor(record.Owner)
+			actions[record.Owner] = action
+		}
+		if action == policy.GCActionKeep {
+			continue
+		}
+
+		candidate := Candidate{Address: record.Address, Owner: record.Owner, CreatedAt: record.CreatedAt, Action: action}
+		if !dryRun {
+			if err := c.apply(record.Owner, record.Address, action); err != nil {
+				c.logger.Error("Failed to %s alias %s: %v", action, record.Address, err)
+				candidate.Error = err.Error()
+			}
+		}
+		report.Candidates = append(report.Candidates, candidate)
+	}
+
+	verb := "Collected"
+	if dryRun {
+		verb = "Would collect"
+	}
+	c.logger.Info("%s %d of %d aliases unused for %s, kept %d created before their activity was tracked",
+		verb, len(report.Candidates), report.Scanned, c.opts.MaxAge, report.Unobserved)
+	return report, nil
+}
+
+// observed reports whether the logs were read without gaps since an alias was created
+func (c *Collector) observed(record store.AliasRecord) bool {
+	b, err := c.backends.ForUser(record.Owner)
+	if err != nil {
+		return false
+	}
+	since, ok := c.store.ActivityObservedSince(b.Name)
+	return ok && !record.CreatedAt.Before(since)
+}
+
+// actionFor returns the collection action for the aliases of an owner according to the policy
+func (c *Collector) actionFor(owner string) string {
+	b, err := c.backends.ForUser(owner)
+	if err != nil {
+		c.logger.Warn("Keeping aliases of %s: %v", owner, err)
+		return policy.GCActionKeep
+	}
+	// Without Mailcow logs there is no activity to judge the aliases by
+	if b.Mailcow == nil {
+		return policy.GCActionKeep
+	}
+
+	mailbox, err := b.Mailcow.GetMailbox(owner)
+	if err != nil {
+		if !errors.Is(err, mailcow.ErrNotFound) {
+			c.logger.Warn("Failed to look up mailbox %s, keeping its aliases: %v", owner, err)
+			return policy.GCActionKeep
+		}
+		return c.opts.Action
+	}
+
+	decision := c.policy.Evaluate(policy.Subject{Mailbox: mailbox.Username, Domain: mailbox.Domain, Tags: mailbox.Tags})
+	if decision.GCAction != "" {
+		return decision.GCAction
+	}
+	return c.opts.Action
+}
+
+// apply deactivates or deletes an alias on the backend of its owner and updates its record
+func (c *Collector) apply(owner, address, action string) error {
+	b, err := c.backends.ForUser(owner)
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case policy.GCActionDeactivate:
+		active := false
+		err = b.Aliases.UpdateAlias(address, backend.AliasUpdate{Active: &active})
+		if err == nil {
+			return c.store.UpdateAlias(address, func(r *store.AliasRecord) { r.Disabled = true })
+		}
+	case policy.GCActionDelete:
+		err = b.Aliases.DeleteAlias(address)
+	default:
+		return fmt.Errorf("unknown action %q", action)
+	}
+
+	// Aliases deleted on the mail server in the meantime are forgotten as well
+	if err != nil && !errors.Is(err, backend.ErrNotFound) {
+		return err
+	}
+	return c.store.DeleteAlias(address)
+}
@@ -0,0 +1,155 @@
+package gc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/activity"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/alias"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/backend"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/mailcow"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/policy"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/store"
+)
+
+// fakeMailcow serves the endpoints used by the collector and records edits and deletions
+type fakeMailcow struct {
+	mu      sync.Mutex
+	edited  []string
+	deleted []string
+}
+
+func (f *fakeMailcow) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch r.URL.Path {
+	case "/api/v1/get/logs/postfix/100":
+		fmt.Fprintf(w, `[{"time": "%d", "program": "postfix/lmtp", "message": "ABC: to=<alice@example.com>, orig_to=<used@example.com>, status=sent"}]`,
+			time.Now().Add(-time.Hour).Unix())
+	case "/api/v1/get/alias/all":
+		w.Write([]byte(`[{"id": 1, "address": "unused@example.com", "goto": "alice@example.com", "active": 1},
+			{"id": 2, "address": "used@example.com", "goto": "alice@example.com", "active": 1}]`))
+	case "/api/v1/get/mailbox/alice@example.com":
+		w.Write([]byte(`{"username": "alice@example.com", "domain": "example.com", "active": 1}`))
+	case "/api/v1/edit/alias":
+		var body struct {
+			Items []string `json:"items"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		f.edited = append(f.edited, body.Items...)
+		w.Write([]byte(`[{"type": "success"}]`))
+	case "/api/v1/delete/alias":
+		var items []string
+		json.NewDecoder(r.Body).Decode(&items)
+		f.deleted = append(f.deleted, items...)
+		w.Write([]byte(`[{"type": "success"}]`))
+	default:
+		w.Write([]byte(`[]`))
+	}
+}
+
+func TestCollector(t *testing.T) {
+	fake := &fakeMailcow{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	client, err := mailcow.NewMailcowClient(server.URL, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := store.Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := time.Now().Add(-30 * 24 * time.Hour)
+	for _, record := range []store.AliasRecord{
+		{Address: "unused@example.com", Owner: "alice@example.com", CreatedAt: old},
+		{Address: "used@example.com", Owner: "alice@example.com", CreatedAt: old},
+		{Address: "fresh@example.com", Owner: "alice@example.com"},
+		{Address: "alice+site@example.com", Owner: "alice@example.com", Type: alias.TypeSubaddress, CreatedAt: old},
+	} {
+		if _, err := st.AddAlias(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The logs have been read since before the aliases were created
+	if err := st.RestartActivityObservation("default", old.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	backends := backend.Single(client, nil)
+	collector := NewCollector(backends, st, policy.Default(), activity.NewTracker(backends, st, 100), Options{
+		MaxAge: 7 * 24 * time.Hour,
+		Action: policy.GCActionDeactivate,
+	})
+
+	report, err := collector.Run(true)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if len(report.Candidates) != 1 || report.Candidates[0].Address != "unused@example.com" || report.Scanned != 3 {
+		t.Fatalf("unexpected dry-run report: %+v", report)
+	}
+	if len(fake.edited) != 0 {
+		t.Fatal("dry run modified aliases")
+	}
+	for _, record := range st.AliasesOf("alice@example.com") {
+		if record.Address == "used@example.com" && record.LastActivity == nil {
+			t.Error("activity of used alias was not recorded")
+		}
+	}
+
+	if _, err := collector.Run(false); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if len(fake.edited) != 1 || fake.edited[0] != "1" {
+		t.Errorf("expected alias 1 to be deactivated, edited %v", fake.edited)
+	}
+
+	// Deactivated aliases are not collected again
+	report, err = collector.Run(false)
+	if err != nil || len(report.Candidates) != 0 {
+		t.Errorf("unexpected second run: %+v, %v", report, err)
+	}
+}
+
+func TestCollectorKeepsUnobservedAliases(t *testing.T) {
+	fake := &fakeMailcow{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	client, err := mailcow.NewMailcowClient(server.URL, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := store.Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Created before the logs were first read, its mail may simply not be in them
+	old := time.Now().Add(-30 * 24 * time.Hour)
+	if _, err := st.AddAlias(store.AliasRecord{Address: "unused@example.com", Owner: "alice@example.com", CreatedAt: old}); err != nil {
+		t.Fatal(err)
+	}
+
+	backends := backend.Single(client, nil)
+	collector := NewCollector(backends, st, policy.Default(), activity.NewTracker(backends, st, 100), Options{
+		MaxAge: 7 * 24 * time.Hour,
+		Action: policy.GCActionDelete,
+	})
+	report, err := collector.Run(false)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if len(report.Candidates) != 0 || report.Unobserved != 1 || len(fake.deleted) != 0 {
+		t.Errorf("alias without tracked activity was collected: %+v, deleted %v", report, fake.deleted)
+	}
+}
@@ -0,0 +1,74 @@
+package mailcow
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// AliasUpdate lists the attributes of an alias to change, nil fields are kept
+type AliasUpdate struct {
+	Active         *bool
+	PrivateComment *string
+	Goto           *string // comma-separated destinations
+}
+
+// aliasID looks up the ID of an alias, which Mailcow's edit and delete operations expect
+func (c *MailcowClient) aliasID(address string) (int, error) {
+	aliases, err := c.GetAliases()
+	if err != nil {
+		return 0, err
+	}
+	for _, alias := range aliases {
+		if strings.EqualFold(alias.Address, address) {
+			return alias.ID, nil
+		}
+	}
+	return 0, fmt.Errorf("alias %s: %w", address, ErrNotFound)
+}
+
+// UpdateAlias changes attributes of an alias
+func (c *MailcowClient) UpdateAlias(address string, update AliasUpdate) error {
+	id, err := c.aliasID(address)
+	if err != nil {
+		return err
+	}
+
+	attributes := map[string]string{}
+	if update.Active != nil {
+		attributes["active"] = "0"
+		if *update.Active {
+			attributes["active"] = "1"
+		}
+	}
+	if update.PrivateComment != nil {
+		attributes["private_comment"] = *update.PrivateComment
+	}
+	if update.Goto != nil {
+		attributes["goto"] = *update.Goto
+	}
+
+	c.logger.Info("Updating Mailcow alias %s (id %d)", address, id)
+	_, err = c.post("/api/v1/edit/alias", map[string]interface{}{
+		"items": []string{strconv.Itoa(id)},
+		"attr":  attributes,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update alias: %w", err)
+	}
+	return nil
+}
+
+// DeleteAlias deletes an alias
+func (c *MailcowClient) DeleteAlias(address string) error {
+	id, err := c.aliasID(address)
+	if err != nil {
+		return err
+	}
+
+	c.logger.Info("Deleting Mailcow alias %s (id %d)", address, id)
+	if _, err := c.post("/api/v1/delete/alias", []string{strconv.Itoa(id)}); err != nil {
+		return fmt.Errorf("failed to delete alias: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package mailcow
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// domainCacheTTL is how long the domain lists are cached
+	domainCacheTTL = 5 * time.Minute
+	// domainCacheMinRefresh limits refreshes triggered by unknown domains
+	domainCacheMinRefresh = 30 * time.Second
+)
+
+// Errors returned by CheckDomain
+var (
+	ErrUnknownDomain  = errors.New("domain does not exist in Mailcow")
+	ErrInactiveDomain = errors.New("domain is inactive in Mailcow")
+)
+
+// Domain is a Mailcow mail domain
+type Domain struct {
+	Name   string      `json:"domain_name"`
+	Active mailcowBool `json:"active"`
+}
+
+// AliasDomain is a Mailcow alias domain, mirroring all addresses of its target domain
+type AliasDomain struct {
+	Name   string      `json:"alias_domain"`
+	Target string      `json:"target_domain"`
+	Active mailcowBool `json:"active"`
+}
+
+// GetDomains returns all mail domains
+func (c *MailcowClient) GetDomains() ([]Domain, error) {
+	body, err := c.get("/api/v1/get/domain/all")
+	if err != nil {
+		return nil, err
+	}
+
+	var domains []Domain
+	if err := decodeList(body, &domains); err != nil {
+		return nil, fmt.Errorf("failed to decode domains: %w", err)
+	}
+	return domains, nil
+}
+
+// GetAliasDomains returns all alias domains
+func (c *MailcowClient) GetAliasDomains() ([]AliasDomain, error) {
+	body, err := c.get("/api/v1/get/alias-domain/all")
+	if err != nil {
+		return nil, err
+	}
+
+	var aliasDomains []AliasDomain
+	if err := decodeList(body, &aliasDomains); err != nil {
+		return nil, fmt.Errorf("failed to decode alias domains: %w", err)
+	}
+	return aliasDomains, nil
+}
+
+// decodeList decodes a Mailcow list response, which is an empty object if there are no entries
+func decodeList(body []byte, v interface{}) error {
+	trimmed := strings.TrimSpace(string(body))
+	if trimmed == "{}" || trimmed == "" {
+		return nil
+	}
+	return json.Unmarshal(body, v)
+}
+
+// domainCache caches the active state of all domains and alias domains
+type domainCache struct {
+	mu      sync.Mutex
+	active  map[string]bool
+	fetched time.Time
+}
+
+// refreshDomains fetches the domain and alias-domain lists, the caller must hold the cache lock
+func (c *MailcowClient) refreshDomains() error {
+	domains, err := c.GetDomains()
+	if err != nil {
+		return err
+	}
+	aliasDomains, err := c.GetAliasDomains()
+	if err != nil {
+		return err
+	}
+
+	active := make(map[string]bool, len(domains)+len(aliasDomains))
+	for _, domain := range domains {
+		active[strings.ToLower(domain.Name)] = bool(domain.Active)
+	}
+	// Alias domains only receive mail while their target domain is active as well
+	for _, aliasDomain := range aliasDomains {
+		active[strings.ToLower(aliasDomain.Name)] = bool(aliasDomain.Active) && active[strings.ToLower(aliasDomain.Target)]
+	}
+
+	c.domains.active = active
+	c.domains.fetched = time.Now()
+	c.logger.Debug("Cached %d domains and %d alias domains", len(domains), len(aliasDomains))
+	return nil
+}
+
+// CheckDomain verifies that aliases can be created on a domain, which must exist in
+// Mailcow as an active domain or alias domain. The domain lists are cached.
+func (c *MailcowClient) CheckDomain(domain string) error {
+	domain = strings.ToLower(domain)
+
+	c.domains.mu.Lock()
+	defer c.domains.mu.Unlock()
+
+	age := time.Since(c.domains.fetched)
+	active, known := c.domains.active[domain]
+
+	// Refresh expired lists, and recent ones if the domain might have been added since
+	if c.domains.active == nil || age > domainCacheTTL || (!known && age > domainCacheMinRefresh) {
+		if err := c.refreshDomains(); err != nil {
+			return fmt.Errorf("failed to fetch domains: %w", err)
+		}
+		active, known = c.domains.active[domain]
+	}
+
+	if !known {
+		return fmt.Errorf("%w: %s", ErrUnknownDomain, domain)
+	}
+	if !active {
+		return fmt.Errorf("%w: %s", ErrInactiveDomain, domain)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package mailcow
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCheckDomain(t *testing.T) {
+	var fetches int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/v1/get/mailq/all":
+			w.Write([]byte(`[]`))
+		case "/api/v1/get/domain/all":
+			atomic.AddInt32(&fetches, 1)
+			w.Write([]byte(`[{"domain_name": "example.com", "active": 1}, {"domain_name": "old.example.com", "active": "0"}]`))
+		case "/api/v1/get/alias-domain/all":
+			w.Write([]byte(`[{"alias_domain": "alias.example.com", "target_domain": "example.com", "active": "1"},
+				{"alias_domain": "alias.old.example.com", "target_domain": "old.example.com", "active": "1"}]`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewMailcowClient(server.URL, "key")
+	if err != nil {
+		t.Fatalf("NewMailcowClient: %v", err)
+	}
+
+	for _, domain := range []string{"example.com", "Alias.Example.com"} {
+		if err := client.CheckDomain(domain); err != nil {
+			t.Errorf("CheckDomain(%s): %v", domain, err)
+		}
+	}
+	for _, domain := range []string{"old.example.com", "alias.old.example.com"} {
+		if err := client.CheckDomain(domain); !errors.Is(err, ErrInactiveDomain) {
+			t.Errorf("CheckDomain(%s) = %v, want ErrInactiveDomain", domain, err)
+		}
+	}
+	if err := client.CheckDomain("other.org"); !errors.Is(err, ErrUnknownDomain) {
+		t.Errorf("CheckDomain(other.org) = %v, want ErrUnknownDomain", err)
+	}
+	if got := atomic.LoadInt32(&fetches); got != 1 {
+		t.Errorf("expected the domain list to be fetched once, got %d", got)
+	}
+
+	// Unknown domains trigger a refresh once the lists are no longer fresh
+	client.domains.fetched = time.Now().Add(-time.Minute)
+	client.CheckDomain("other.org")
+	if got := atomic.LoadInt32(&fetches); got != 2 {
+		t.Errorf("expected a refresh for an unknown domain, got %d fetches", got)
+	}
+}
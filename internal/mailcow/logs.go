@@ -0,0 +1,106 @@
+package mailcow
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// LogEntry is a line of a Mailcow container log
+type LogEntry struct {
+	Time     mailcowTime `json:"time"`
+	Priority string      `json:"priority"`
+	Program  string      `json:"program"`
+	Message  string      `json:"message"`
+}
+
+// At returns the time of the entry
+func (e *LogEntry) At() time.Time {
+	return time.Time(e.Time)
+}
+
+var (
+	// recipientRegex matches the recipients of a postfix delivery log line
+	recipientRegex = regexp.MustCompile(`\b(?:orig_to|to)=<([^>]+)>`)
+	// senderRegex matches the sender of a postfix queue manager log line
+	senderRegex = regexp.MustCompile(`\bfrom=<([^>]*)>`)
+	// queueIDRegex matches the queue ID prefixing postfix log lines
+	queueIDRegex = regexp.MustCompile(`^([0-9A-Za-z]+): `)
+)
+
+// Recipients returns the recipient addresses named in the entry, lowercased
+func (e *LogEntry) Recipients() []string {
+	var recipients []string
+	for _, match := range recipientRegex.FindAllStringSubmatch(e.Message, -1) {
+		recipients = append(recipients, strings.ToLower(match[1]))
+	}
+	return recipients
+}
+
+// QueueID returns the postfix queue ID of the entry, or an empty string
+func (e *LogEntry) QueueID() string {
+	if match := queueIDRegex.FindStringSubmatch(e.Message); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+// Sender returns the sender named in the entry, lowercased, or an empty string
+func (e *LogEntry) Sender() string {
+	if match := senderRegex.FindStringSubmatch(e.Message); match != nil {
+		return strings.ToLower(match[1])
+	}
+	return ""
+}
+
+// IsDelivery reports whether the entry records a successful delivery
+func (e *LogEntry) IsDelivery() bool {
+	return strings.Contains(e.Message, "status=sent")
+}
+
+// RspamdEntry is an entry of the rspamd scan history
+type RspamdEntry struct {
+	Time       mailcowTime `json:"unix_time"`
+	Action     string      `json:"action"`
+	Sender     string      `json:"sender_smtp"`
+	Recipients []string    `json:"rcpt_smtp"`
+}
+
+// At returns the time of the entry
+func (e *RspamdEntry) At() time.Time {
+	return time.Time(e.Time)
+}
+
+// IsRejected reports whether rspamd rejected the message
+func (e *RspamdEntry) IsRejected() bool {
+	return e.Action == "reject"
+}
+
+// GetRspamdHistory returns the latest count entries of the rspamd scan history
+func (c *MailcowClient) GetRspamdHistory(count int) ([]RspamdEntry, error) {
+	body, err := c.get(fmt.Sprintf("/api/v1/get/logs/rspamd-history/%d", count))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []RspamdEntry
+	if err := decodeList(body, &entries); err != nil {
+		return nil, fmt.Errorf("failed to decode rspamd history: %w", err)
+	}
+	return entries, nil
+}
+
+// GetPostfixLogs returns the latest count lines of the postfix log
+func (c *MailcowClient) GetPostfixLogs(count int) ([]LogEntry, error) {
+	body, err := c.get(fmt.Sprintf("/api/v1/get/logs/postfix/%d", count))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []LogEntry
+	if err := decodeList(body, &entries); err != nil {
+		return nil, fmt.Errorf("failed to decode postfix logs: %w", err)
+	}
+	return entries, nil
+}
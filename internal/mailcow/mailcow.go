@@ -3,9 +3,14 @@ package mailcow
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/logger"
@@ -16,7 +21,10 @@ type MailcowClient struct {
 	apiURL     string
 	apiKey     string
 	httpClient *http.Client
-	logger     *logger.Logger
+	domains    domainCache
+	// unavailable is set while the API cannot be reached
+	unavailable atomic.Bool
+	logger      *logger.Logger
 }
 
 // NewMailcowClient creates a new MailcowClient
@@ -30,27 +38,52 @@ func NewMailcowClient(apiURL, apiKey string) (*MailcowClient, error) {
 		Timeout: 10 * time.Second,
 	}
 
-	mc := &MailcowClient{
+	// The API is not contacted here, see CheckAPIConnectivity
+	return &MailcowClient{
 		apiURL:     apiURL,
 		apiKey:     apiKey,
 		httpClient: client,
 		logger:     logger.WithComponent("Mailcow"),
-	}
+	}, nil
+}
+
+// Available reports whether the API was reachable at the last contact
+func (c *MailcowClient) Available() bool {
+	return !c.unavailable.Load()
+}
 
-	// Check API connectivity at startup
-	if err := mc.CheckAPIConnectivity(); err != nil {
-		return nil, fmt.Errorf("Mailcow API connectivity check failed: %w", err)
+// setAvailable records whether the API could be reached and logs changes
+func (c *MailcowClient) setAvailable(err error) {
+	wasUnavailable := c.unavailable.Swap(err != nil)
+	if err != nil && !wasUnavailable {
+		c.logger.Warn("Mailcow API at %s is unavailable, failing requests until it recovers: %v", c.apiURL, err)
+	} else if err == nil && wasUnavailable {
+		c.logger.Info("Mailcow API at %s is available again", c.apiURL)
 	}
+}
 
-	return mc, nil
+// checkAvailable fails fast while the API is known to be unreachable
+func (c *MailcowClient) checkAvailable() error {
+	if !c.Available() {
+		return fmt.Errorf("%w: %s", ErrUnavailable, c.apiURL)
+	}
+	return nil
 }
 
-// CheckAPIConnectivity verifies the Mailcow API is accessible
+// CheckAPIConnectivity verifies the Mailcow API is accessible and records the result. While the API
+// is unreachable other requests fail with ErrUnavailable, so call it periodically to notice recovery.
 func (c *MailcowClient) CheckAPIConnectivity() error {
+	err := c.checkAPIConnectivity()
+	c.setAvailable(err)
+	return err
+}
+
+// checkAPIConnectivity requests the mail queue, which every API key may read
+func (c *MailcowClient) checkAPIConnectivity() error {
 	requestID := fmt.Sprintf("MCOW-INIT-%d", time.Now().UnixNano())
 	log := c.logger.WithRequestID(requestID)
 
-	log.Info("Checking Mailcow API connectivity at %s", c.apiURL)
+	log.Debug("Checking Mailcow API connectivity at %s", c.apiURL)
 
 	// Create request to the mailq endpoint
 	req, err := http.NewRequest("GET", c.apiURL+"/api/v1/get/mailq/all", nil)
@@ -68,7 +101,7 @@ func (c *MailcowClient) CheckAPIConnectivity() error {
 	requestDuration := time.Since(startTime)
 
 	if err != nil {
-		log.Error("API connectivity check failed (took %s): %v", logger.FormatDuration(requestDuration), err)
+		log.Debug("API connectivity check failed (took %s): %v", logger.FormatDuration(requestDuration), err)
 		return fmt.Errorf("API connectivity check failed: %w", err)
 	}
 	defer resp.Body.Close()
@@ -79,29 +112,56 @@ func (c *MailcowClient) CheckAPIConnectivity() error {
 	if resp.StatusCode != http.StatusOK {
 		// Read the response body for error details
 		body, _ := ioutil.ReadAll(resp.Body)
-		log.Error("API check failed with status %d: %s", resp.StatusCode, string(body))
+		log.Debug("API check failed with status %d: %s", resp.StatusCode, string(body))
 		return fmt.Errorf("API check failed with status %d", resp.StatusCode)
 	}
 
-	log.Info("Mailcow API connectivity check successful")
+	log.Debug("Mailcow API connectivity check successful")
 	return nil
 }
 
+// AliasOptions are optional settings of a new alias
+type AliasOptions struct {
+	// SenderAllowed lets the goto mailbox send as the alias and offers it as identity in SOGo.
+	// Mailcow's API offers no way to read a mailbox's sender ACL without replacing it,
+	// so send-as rights are granted through the alias instead. Nil keeps Mailcow's default.
+	SenderAllowed *bool
+	// Comment is stored as the alias's private comment, visible to admins only
+	Comment string
+}
+
 // CreateAlias creates a new alias in Mailcow
-func (c *MailcowClient) CreateAlias(address, gotoAddress string) error {
+func (c *MailcowClient) CreateAlias(address, gotoAddress string, opts AliasOptions) error {
 	requestID := fmt.Sprintf("MCOW-%d", time.Now().UnixNano())
 	log := c.logger.WithRequestID(requestID)
 
+	if err := c.checkAvailable(); err != nil {
+		return err
+	}
 	log.Info("Creating new Mailcow alias: %s -> %s", address, gotoAddress)
 
 	// Prepare request body
-	requestBody, err := json.Marshal(map[string]string{
+	attributes := map[string]string{
 		"address": address,
 		"goto":    gotoAddress,
 		"active":  "1", // Active by default
-	})
+	}
+	if opts.SenderAllowed != nil {
+		flag := "0"
+		if *opts.SenderAllowed {
+			flag = "1"
+		}
+		attributes["sender_allowed"] = flag
+		attributes["sogo_visible"] = flag
+		log.Debug("Setting sender_allowed and sogo_visible to %s", flag)
+	}
+	if opts.Comment != "" {
+		attributes["private_comment"] = opts.Comment
+	}
+
+	requestBody, err := json.Marshal(attributes)
 	if err != nil {
-		log.Error("Failed to marshal request body: %v", requestID, err)
+		log.Error("Failed to marshal request body: %v", err)
 		return fmt.Errorf("failed to marshal request body: %w", err)
 	}
 
@@ -125,7 +185,8 @@ func (c *MailcowClient) CreateAlias(address, gotoAddress string) error {
 
 	if err != nil {
 		log.Error("Failed to execute request (took %s): %v", logger.FormatDuration(requestDuration), err)
-		return fmt.Errorf("failed to execute request: %w", err)
+		c.setAvailable(err)
+		return fmt.Errorf("failed to execute request: %w: %w", ErrUnavailable, err)
 	}
 	defer resp.Body.Close()
 
@@ -143,13 +204,240 @@ func (c *MailcowClient) CreateAlias(address, gotoAddress string) error {
 		return fmt.Errorf("failed to create alias, status code: %d, response: %s", resp.StatusCode, string(body))
 	}
 
-	// Validate the response
-	_, err = ioutil.ReadAll(resp.Body)
+	// Validate the response, Mailcow reports rejected aliases with status 200
+	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		log.Error("Failed to read success response body: %v", err)
 		return fmt.Errorf("failed to read response body: %w", err)
 	}
+	if err := checkResult(body); err != nil {
+		log.Error("Mailcow rejected alias %s: %s", address, string(body))
+		return err
+	}
 
 	log.Info("Successfully created alias in Mailcow")
 	return nil
 }
+
+// ErrNotFound is returned when a requested Mailcow object does not exist
+var ErrNotFound = errors.New("not found in Mailcow")
+
+// ErrUnavailable is returned when the Mailcow API cannot be reached, and without contacting it
+// until CheckAPIConnectivity succeeds again
+var ErrUnavailable = errors.New("Mailcow API unavailable")
+
+// mailcowBool decodes Mailcow flags, which are encoded as numbers, strings or booleans
+type mailcowBool bool
+
+// UnmarshalJSON implements json.Unmarshaler
+func (b *mailcowBool) UnmarshalJSON(data []byte) error {
+	switch strings.Trim(string(data), `"`) {
+	case "1", "true":
+		*b = true
+	default:
+		*b = false
+	}
+	return nil
+}
+
+// Mailbox is a Mailcow mailbox
+type Mailbox struct {
+	Username  string      `json:"username"`
+	Name      string      `json:"name"`
+	Domain    string      `json:"domain"`
+	LocalPart string      `json:"local_part"`
+	Active    mailcowBool `json:"active"`
+	Tags      []string    `json:"tags"`
+	Created   string      `json:"created"`
+}
+
+// DelegateTagPrefix marks mailbox tags naming a user who may use the mailbox as alias destination,
+// e.g. "delegate:alice@example.com" on a team mailbox. This is a convention of the bridge,
+// Mailcow's own delegation (IMAP ACLs, SOGo permissions) is not read.
+const DelegateTagPrefix = "delegate:"
+
+// IsDelegatedTo reports whether the mailbox is delegated to a user by a delegate tag
+func (m *Mailbox) IsDelegatedTo(username string) bool {
+	for _, tag := range m.Tags {
+		if strings.HasPrefix(tag, DelegateTagPrefix) && strings.EqualFold(strings.TrimSpace(tag[len(DelegateTagPrefix):]), username) {
+			return true
+		}
+	}
+	return false
+}
+
+// CreatedAt returns the creation time of the mailbox, zero if unknown
+func (m *Mailbox) CreatedAt() time.Time {
+	created, err := time.ParseInLocation("2006-01-02 15:04:05", m.Created, time.UTC)
+	if err != nil {
+		return time.Time{}
+	}
+	return created
+}
+
+// IsActive reports whether the mailbox is active
+func (m *Mailbox) IsActive() bool {
+	return bool(m.Active)
+}
+
+// GetMailbox looks up a mailbox by its address
+func (c *MailcowClient) GetMailbox(username string) (*Mailbox, error) {
+	body, err := c.get("/api/v1/get/mailbox/" + url.PathEscape(strings.ToLower(username)))
+	if err != nil {
+		return nil, err
+	}
+
+	// Unknown mailboxes are answered with an empty object or list
+	trimmed := strings.TrimSpace(string(body))
+	if trimmed == "{}" || trimmed == "[]" || trimmed == "" {
+		return nil, ErrNotFound
+	}
+
+	var mailbox Mailbox
+	if err := json.Unmarshal(body, &mailbox); err != nil {
+		return nil, fmt.Errorf("failed to decode mailbox: %w", err)
+	}
+	if mailbox.Username == "" {
+		return nil, ErrNotFound
+	}
+
+	return &mailbox, nil
+}
+
+// GetMailboxes returns all mailboxes
+func (c *MailcowClient) GetMailboxes() ([]Mailbox, error) {
+	body, err := c.get("/api/v1/get/mailbox/all")
+	if err != nil {
+		return nil, err
+	}
+
+	var mailboxes []Mailbox
+	if err := decodeList(body, &mailboxes); err != nil {
+		return nil, fmt.Errorf("failed to decode mailboxes: %w", err)
+	}
+	return mailboxes, nil
+}
+
+// Alias is a Mailcow alias
+type Alias struct {
+	ID             int         `json:"id"`
+	Address        string      `json:"address"`
+	Goto           string      `json:"goto"`
+	PrivateComment string      `json:"private_comment"`
+	Active         mailcowBool `json:"active"`
+}
+
+// IsActive reports whether the alias is active
+func (a *Alias) IsActive() bool {
+	return bool(a.Active)
+}
+
+// GetAliases returns all aliases
+func (c *MailcowClient) GetAliases() ([]Alias, error) {
+	body, err := c.get("/api/v1/get/alias/all")
+	if err != nil {
+		return nil, err
+	}
+
+	var aliases []Alias
+	if err := decodeList(body, &aliases); err != nil {
+		return nil, fmt.Errorf("failed to decode aliases: %w", err)
+	}
+	return aliases, nil
+}
+
+// get executes a GET request against the Mailcow API and returns the response body
+func (c *MailcowClient) get(path string) ([]byte, error) {
+	return c.request("GET", path, nil)
+}
+
+// post executes a POST request against the Mailcow API and checks the result messages
+func (c *MailcowClient) post(path string, payload interface{}) ([]byte, error) {
+	body, err := c.request("POST", path, payload)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResult(body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// checkResult returns an error if Mailcow reported a failure, which it does with status 200
+func checkResult(body []byte) error {
+	var results []struct {
+		Type string          `json:"type"`
+		Msg  json.RawMessage `json:"msg"`
+	}
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		trimmed = append(append([]byte("["), trimmed...), ']')
+	}
+	if err := json.Unmarshal(trimmed, &results); err != nil {
+		// Not a result list, e.g. an empty body
+		return nil
+	}
+
+	for _, result := range results {
+		if result.Type == "danger" || result.Type == "error" {
+			return fmt.Errorf("Mailcow rejected the request: %s", string(result.Msg))
+		}
+	}
+	return nil
+}
+
+// request executes a request against the Mailcow API and returns the response body
+func (c *MailcowClient) request(method, path string, payload interface{}) ([]byte, error) {
+	if err := c.checkAvailable(); err != nil {
+		return nil, err
+	}
+	requestID := fmt.Sprintf("MCOW-%d", time.Now().UnixNano())
+	log := c.logger.WithRequestID(requestID)
+
+	var requestBody io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			log.Error("Failed to marshal request body: %v", err)
+			return nil, fmt.Errorf("failed to marshal request body: %w", err)
+		}
+		requestBody = bytes.NewReader(data)
+	}
+
+	log.Debug("Preparing HTTP request to: %s %s", method, c.apiURL+path)
+	req, err := http.NewRequest(method, c.apiURL+path, requestBody)
+	if err != nil {
+		log.Error("Failed to create request: %v", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("X-API-Key", c.apiKey)
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	startTime := time.Now()
+	resp, err := c.httpClient.Do(req)
+	requestDuration := time.Since(startTime)
+
+	if err != nil {
+		log.Error("Failed to execute request (took %s): %v", logger.FormatDuration(requestDuration), err)
+		c.setAvailable(err)
+		return nil, fmt.Errorf("failed to execute request: %w: %w", ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	log.Debug("Received response in %s with status code: %d", logger.FormatDuration(requestDuration), resp.StatusCode)
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Error("Failed to read response body: %v", err)
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		log.Error("Error response body: %s", string(body))
+		return nil, fmt.Errorf("request failed, status code: %d, response: %s", resp.StatusCode, string(body))
+	}
+
+	return body, nil
+}
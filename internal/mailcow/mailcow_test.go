@@ -0,0 +1,145 @@
+package mailcow
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCreateAliasSenderAllowed(t *testing.T) {
+	var received map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/add/alias" {
+			received = nil
+			json.NewDecoder(r.Body).Decode(&received)
+		}
+		w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	client, err := NewMailcowClient(server.URL, "key")
+	if err != nil {
+		t.Fatalf("NewMailcowClient: %v", err)
+	}
+
+	if err := client.CreateAlias("a@example.com", "user@example.com", AliasOptions{}); err != nil {
+		t.Fatalf("CreateAlias: %v", err)
+	}
+	if _, ok := received["sender_allowed"]; ok {
+		t.Errorf("sender_allowed sent without being configured: %v", received)
+	}
+
+	allowed := true
+	if err := client.CreateAlias("b@example.com", "user@example.com", AliasOptions{SenderAllowed: &allowed}); err != nil {
+		t.Fatalf("CreateAlias: %v", err)
+	}
+	if received["sender_allowed"] != "1" || received["sogo_visible"] != "1" || received["goto"] != "user@example.com" {
+		t.Errorf("unexpected request: %v", received)
+	}
+}
+
+func TestCreateTimeLimitedAlias(t *testing.T) {
+	aliases := []map[string]interface{}{
+		{"address": "old@example.com", "goto": "user@example.com", "validity": 1700000000},
+	}
+	var received map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/get/time_limited_aliases/user@example.com":
+			json.NewEncoder(w).Encode(aliases)
+		case "/api/v1/add/time_limited_alias":
+			json.NewDecoder(r.Body).Decode(&received)
+			aliases = append(aliases, map[string]interface{}{"address": "x7k2m9@example.com", "goto": "user@example.com", "validity": "1800000000"})
+			w.Write([]byte(`[{"type": "success", "msg": ["mailbox_modified", "user@example.com"]}]`))
+		default:
+			w.Write([]byte(`[]`))
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewMailcowClient(server.URL, "key")
+	if err != nil {
+		t.Fatalf("NewMailcowClient: %v", err)
+	}
+
+	created, err := client.CreateTimeLimitedAlias("User@example.com", "example.com", "test", 90*time.Minute)
+	if err != nil {
+		t.Fatalf("CreateTimeLimitedAlias: %v", err)
+	}
+	if created.Address != "x7k2m9@example.com" || created.ExpiresAt().Unix() != 1800000000 {
+		t.Errorf("unexpected alias: %+v", created)
+	}
+	if received["validity"] != "2" || received["domain"] != "example.com" {
+		t.Errorf("unexpected request: %v", received)
+	}
+}
+
+func TestPostReportsMailcowErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			w.Write([]byte(`[{"type": "danger", "msg": ["domain_invalid"]}]`))
+			return
+		}
+		w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	client, err := NewMailcowClient(server.URL, "key")
+	if err != nil {
+		t.Fatalf("NewMailcowClient: %v", err)
+	}
+	if _, err := client.CreateTimeLimitedAlias("user@example.com", "other.org", "", time.Hour); err == nil {
+		t.Error("expected Mailcow's error to be reported")
+	}
+}
+
+func TestUnavailableAPIFailsFast(t *testing.T) {
+	var down atomic.Bool
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if down.Load() {
+			// Drop the connection like an unreachable server
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	client, err := NewMailcowClient(server.URL, "key")
+	if err != nil {
+		t.Fatalf("NewMailcowClient: %v", err)
+	}
+	if requests.Load() != 0 {
+		t.Errorf("NewMailcowClient contacted the API")
+	}
+
+	down.Store(true)
+	if _, err := client.GetAliases(); !errors.Is(err, ErrUnavailable) || client.Available() {
+		t.Fatalf("expected ErrUnavailable for a dropped connection, got %v", err)
+	}
+	before := requests.Load()
+	if err := client.CreateAlias("a@example.com", "user@example.com", AliasOptions{}); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("expected CreateAlias to fail fast, got %v", err)
+	}
+	if requests.Load() != before {
+		t.Error("requests reached the API while it was unavailable")
+	}
+	if err := client.CheckAPIConnectivity(); err == nil {
+		t.Error("expected the connectivity check to fail")
+	}
+
+	down.Store(false)
+	if err := client.CheckAPIConnectivity(); err != nil || !client.Available() {
+		t.Fatalf("expected the API to recover, got %v", err)
+	}
+	if _, err := client.GetAliases(); err != nil {
+		t.Errorf("GetAliases after recovery: %v", err)
+	}
+}
@@ -0,0 +1,757 @@
+// Package mailcowtest provides a fake Mailcow API for tests and manual testing.
+//
+// The fake keeps domains, mailboxes, aliases and logs in memory and answers like Mailcow does,
+// including its quirks: empty lists are encoded as {}, unknown objects as {}, flags as numbers or
+// strings, timestamps as strings or fractional numbers, and failed changes are reported as
+// "danger" messages with status 200. Faults can be injected per path.
+package mailcowtest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// mailcowTimeLayout is the layout of Mailcow's creation dates
+const mailcowTimeLayout = "2006-01-02 15:04:05"
+
+// Mailbox is a mailbox of the fake
+type Mailbox struct {
+	Username string
+	Name     string
+	Active   bool
+	Tags     []string
+	Created  time.Time
+}
+
+// Alias is an alias of the fake
+type Alias struct {
+	ID             int
+	Address        string
+	Goto           string // comma-separated destinations
+	Active         bool
+	PrivateComment string
+	SenderAllowed  bool
+	Created        time.Time
+}
+
+// TimeLimitedAlias is a time-limited alias of the fake
+type TimeLimitedAlias struct {
+	Address     string
+	Goto        string
+	Description string
+	Validity    time.Time
+}
+
+// RspamdEntry is an entry of the fake's rspamd history
+type RspamdEntry struct {
+	Time       time.Time
+	Action     string
+	Sender     string
+	Recipients []string
+}
+
+// logLine is a line of the fake's postfix log
+type logLine struct {
+	time    time.Time
+	program string
+	message string
+}
+
+// fault answers the next count requests of a path prefix with an error
+type fault struct {
+	path   string
+	status int    // HTTP status, or 0 for a danger message with status 200
+	danger string // message of the danger response
+	count  int
+}
+
+// Fake is an in-memory Mailcow API. Use NewServer to run it in tests, or serve it with any
+// http.Server. The zero value is not usable, create it with New.
+type Fake struct {
+	apiKey string
+	router *mux.Router
+
+	mu           sync.Mutex
+	domains      map[string]bool // active state by name
+	aliasDomains map[string]aliasDomain
+	mailboxes    map[string]Mailbox
+	aliases      []Alias
+	timeLimited  map[string][]TimeLimitedAlias // by username
+	postfixLog   []logLine
+	rspamd       []RspamdEntry
+	nextID       int
+	queueID      int
+	latency      time.Duration
+	unreachable  bool
+	faults       []*fault
+	requests     []string
+}
+
+// aliasDomain is an alias domain of the fake
+type aliasDomain struct {
+	target string
+	active bool
+}
+
+// New creates a fake accepting requests with the given API key, or any key if empty
+func New(apiKey string) *Fake {
+	f := &Fake{
+		apiKey:       apiKey,
+		router:       mux.NewRouter(),
+		domains:      make(map[string]bool),
+		aliasDomains: make(map[string]aliasDomain),
+		mailboxes:    make(map[string]Mailbox),
+		timeLimited:  make(map[string][]TimeLimitedAlias),
+		nextID:       1,
+	}
+	f.routes()
+	return f
+}
+
+// Server is a fake running on a local port
+type Server struct {
+	*Fake
+	*httptest.Server
+}
+
+// NewServer starts a fake accepting requests with the given API key. Close it when done.
+func NewServer(apiKey string) *Server {
+	fake := New(apiKey)
+	return &Server{Fake: fake, Server: httptest.NewServer(fake)}
+}
+
+func (f *Fake) routes() {
+	api := f.router.PathPrefix("/api/v1").Subrouter()
+	api.Use(f.middleware)
+	api.HandleFunc("/get/mailq/all", f.handleMailQueue).Methods("GET")
+	api.HandleFunc("/get/domain/all", f.handleDomains).Methods("GET")
+	api.HandleFunc("/get/alias-domain/all", f.handleAliasDomains).Methods("GET")
+	api.HandleFunc("/get/mailbox/all", f.handleMailboxes).Methods("GET")
+	api.HandleFunc("/get/mailbox/{username}", f.handleMailbox).Methods("GET")
+	api.HandleFunc("/get/alias/all", f.handleAliases).Methods("GET")
+	api.HandleFunc("/get/time_limited_aliases/{username}", f.handleTimeLimitedAliases).Methods("GET")
+	api.HandleFunc("/get/logs/postfix/{count:[0-9]+}", f.handlePostfixLogs).Methods("GET")
+	api.HandleFunc("/get/logs/rspamd-history/{count:[0-9]+}", f.handleRspamdHistory).Methods("GET")
+	api.HandleFunc("/add/alias", f.handleAddAlias).Methods("POST")
+	api.HandleFunc("/edit/alias", f.handleEditAlias).Methods("POST")
+	api.HandleFunc("/delete/alias", f.handleDeleteAlias).Methods("POST")
+	api.HandleFunc("/add/time_limited_alias", f.handleAddTimeLimitedAlias).Methods("POST")
+
+	// Control endpoints for manual testing, see cmd/fake-mailcow
+	control := f.router.PathPrefix("/fake").Subrouter()
+	control.HandleFunc("/fail", f.handleControlFault).Methods("POST")
+	control.HandleFunc("/danger", f.handleControlFault).Methods("POST")
+	control.HandleFunc("/latency", f.handleControlLatency).Methods("POST")
+	control.HandleFunc("/unreachable", f.handleControlUnreachable).Methods("POST")
+	control.HandleFunc("/delivery", f.handleControlDelivery).Methods("POST")
+	control.HandleFunc("/requests", f.handleControlRequests).Methods("GET")
+}
+
+// ServeHTTP implements http.Handler
+func (f *Fake) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.router.ServeHTTP(w, r)
+}
+
+// middleware records requests, checks the API key and applies latency and faults
+func (f *Fake) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+		latency := f.latency
+		unreachable := f.unreachable
+		var injected *fault
+		if !unreachable {
+			injected = f.takeFault(r.URL.Path)
+		}
+		f.mu.Unlock()
+
+		if unreachable {
+			// Drop the connection like a server that is down
+			if hijacker, ok := w.(http.Hijacker); ok {
+				if conn, _, err := hijacker.Hijack(); err == nil {
+					conn.Close()
+					return
+				}
+			}
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		if latency > 0 {
+			time.Sleep(latency)
+		}
+
+		if f.apiKey != "" && r.Header.Get("X-API-Key") != f.apiKey {
+			w.WriteHeader(http.StatusUnauthorized)
+			writeJSON(w, map[string]string{"type": "error", "msg": "authentication failed"})
+			return
+		}
+
+		switch {
+		case injected == nil:
+			next.ServeHTTP(w, r)
+		case injected.status != 0:
+			w.WriteHeader(injected.status)
+			writeJSON(w, map[string]string{"type": "error", "msg": "injected fault"})
+		default:
+			writeResult(w, "danger", injected.danger)
+		}
+	})
+}
+
+// takeFault returns the fault to apply to a request of path, the caller must hold the lock
+func (f *Fake) takeFault(path string) *fault {
+	for i, candidate := range f.faults {
+		if strings.HasPrefix(path, candidate.path) {
+			candidate.count--
+			if candidate.count <= 0 {
+				f.faults = append(f.faults[:i], f.faults[i+1:]...)
+			}
+			return candidate
+		}
+	}
+	return nil
+}
+
+// SetLatency delays every API response by d
+func (f *Fake) SetLatency(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.latency = d
+}
+
+// SetUnreachable drops the connections of all API requests while set, like a Mailcow that is down
+func (f *Fake) SetUnreachable(unreachable bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.unreachable = unreachable
+}
+
+// FailNext answers the next count requests whose path starts with path with status 500
+func (f *Fake) FailNext(path string, count int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults = append(f.faults, &fault{path: path, status: http.StatusInternalServerError, count: count})
+}
+
+// DangerNext answers the next count requests whose path starts with path with a danger message
+// and status 200, as Mailcow does for rejected changes. Nothing is changed.
+func (f *Fake) DangerNext(path string, count int, message string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults = append(f.faults, &fault{path: path, danger: message, count: count})
+}
+
+// Requests returns the API requests received so far, as "METHOD path"
+func (f *Fake) Requests() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string{}, f.requests...)
+}
+
+// AddDomain adds a mail domain
+func (f *Fake) AddDomain(name string, active bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.domains[strings.ToLower(name)] = active
+}
+
+// AddAliasDomain adds an alias domain mirroring target
+func (f *Fake) AddAliasDomain(name, target string, active bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.aliasDomains[strings.ToLower(name)] = aliasDomain{target: strings.ToLower(target), active: active}
+}
+
+// AddMailbox adds a mailbox, its domain is added as active domain if unknown
+func (f *Fake) AddMailbox(mailbox Mailbox) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	mailbox.Username = strings.ToLower(mailbox.Username)
+	if mailbox.Created.IsZero() {
+		mailbox.Created = time.Now()
+	}
+	if _, ok := f.domains[domainOf(mailbox.Username)]; !ok {
+		f.domains[domainOf(mailbox.Username)] = true
+	}
+	f.mailboxes[mailbox.Username] = mailbox
+}
+
+// AddAlias adds an alias and returns its ID
+func (f *Fake) AddAlias(alias Alias) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	alias.ID = f.nextID
+	f.nextID++
+	if alias.Created.IsZero() {
+		alias.Created = time.Now()
+	}
+	f.aliases = append(f.aliases, alias)
+	return alias.ID
+}
+
+// Aliases returns all aliases
+func (f *Fake) Aliases() []Alias {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Alias{}, f.aliases...)
+}
+
+// Alias returns the alias of an address
+func (f *Fake) Alias(address string) (Alias, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, alias := range f.aliases {
+		if strings.EqualFold(alias.Address, address) {
+			return alias, true
+		}
+	}
+	return Alias{}, false
+}
+
+// TimeLimitedAliases returns the time-limited aliases of a mailbox
+func (f *Fake) TimeLimitedAliases(username string) []TimeLimitedAlias {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]TimeLimitedAlias{}, f.timeLimited[strings.ToLower(username)]...)
+}
+
+// AddDelivery logs the delivery of a mail from sender to a mailbox, sent to address, e.g. an alias
+func (f *Fake) AddDelivery(sender, mailbox, address string, at time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.queueID++
+	queueID := fmt.Sprintf("%X", 0x4A000+f.queueID)
+	f.postfixLog = append(f.postfixLog,
+		logLine{at, "postfix/qmgr", fmt.Sprintf("%s: from=<%s>, size=2048, nrcpt=1 (queue active)", queueID, sender)},
+		logLine{at, "postfix/lmtp", fmt.Sprintf("%s: to=<%s>, orig_to=<%s>, relay=dovecot, delay=0.1, status=sent (250 2.0.0 Saved)", queueID, mailbox, address)},
+	)
+}
+
+// AddRspamdEntry adds an entry to the rspamd history, action "reject" for rejected mail
+func (f *Fake) AddRspamdEntry(entry RspamdEntry) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rspamd = append(f.rspamd, entry)
+}
+
+// domainOf returns the domain of an address
+func domainOf(address string) string {
+	return strings.ToLower(address[strings.LastIndex(address, "@")+1:])
+}
+
+// flag encodes a Mailcow flag as number
+func flag(value bool) int {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeList writes a list response, empty lists are {} as in Mailcow
+func writeList(w http.ResponseWriter, list []map[string]interface{}) {
+	if len(list) == 0 {
+		writeJSON(w, map[string]interface{}{})
+		return
+	}
+	writeJSON(w, list)
+}
+
+// writeResult writes Mailcow's result envelope of changes
+func writeResult(w http.ResponseWriter, resultType string, msg ...interface{}) {
+	writeJSON(w, []map[string]interface{}{{"type": resultType, "log": []string{"fake"}, "msg": msg}})
+}
+
+func (f *Fake) handleMailQueue(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, []interface{}{})
+}
+
+func (f *Fake) handleDomains(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var list []map[string]interface{}
+	for name, active := range f.domains {
+		// Domains carry their flags as strings
+		list = append(list, map[string]interface{}{"domain_name": name, "active": strconv.Itoa(flag(active))})
+	}
+	sortBy(list, "domain_name")
+	writeList(w, list)
+}
+
+func (f *Fake) handleAliasDomains(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var list []map[string]interface{}
+	for name, domain := range f.aliasDomains {
+		list = append(list, map[string]interface{}{"alias_domain": name, "target_domain": domain.target, "active": flag(domain.active)})
+	}
+	sortBy(list, "alias_domain")
+	writeList(w, list)
+}
+
+// mailboxObject encodes a mailbox like Mailcow
+func mailboxObject(mailbox Mailbox) map[string]interface{} {
+	tags := mailbox.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	return map[string]interface{}{
+		"username":   mailbox.Username,
+		"name":       mailbox.Name,
+		"domain":     domainOf(mailbox.Username),
+		"local_part": mailbox.Username[:strings.LastIndex(mailbox.Username, "@")],
+		"active":     flag(mailbox.Active),
+		"active_int": flag(mailbox.Active),
+		"tags":       tags,
+		"created":    mailbox.Created.UTC().Format(mailcowTimeLayout),
+	}
+}
+
+func (f *Fake) handleMailboxes(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var list []map[string]interface{}
+	for _, mailbox := range f.mailboxes {
+		list = append(list, mailboxObject(mailbox))
+	}
+	sortBy(list, "username")
+	writeList(w, list)
+}
+
+func (f *Fake) handleMailbox(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	mailbox, ok := f.mailboxes[strings.ToLower(mux.Vars(r)["username"])]
+	if !ok {
+		// Unknown mailboxes are an empty object
+		writeJSON(w, map[string]interface{}{})
+		return
+	}
+	writeJSON(w, mailboxObject(mailbox))
+}
+
+func (f *Fake) handleAliases(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var list []map[string]interface{}
+	for _, alias := range f.aliases {
+		list = append(list, map[string]interface{}{
+			"id":              alias.ID,
+			"address":         alias.Address,
+			"goto":            alias.Goto,
+			"domain":          domainOf(alias.Address),
+			"active":          flag(alias.Active),
+			"active_int":      flag(alias.Active),
+			"sogo_visible":    flag(alias.SenderAllowed),
+			"private_comment": alias.PrivateComment,
+			"created":         alias.Created.UTC().Format(mailcowTimeLayout),
+		})
+	}
+	writeList(w, list)
+}
+
+func (f *Fake) handleTimeLimitedAliases(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var list []map[string]interface{}
+	for _, alias := range f.timeLimited[strings.ToLower(mux.Vars(r)["username"])] {
+		list = append(list, map[string]interface{}{
+			"address":     alias.Address,
+			"goto":        alias.Goto,
+			"description": alias.Description,
+			"validity":    alias.Validity.Unix(),
+		})
+	}
+	writeList(w, list)
+}
+
+// logCount returns the count path variable
+func logCount(r *http.Request) int {
+	count, _ := strconv.Atoi(mux.Vars(r)["count"])
+	return count
+}
+
+func (f *Fake) handlePostfixLogs(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// Newest first, timestamps as strings
+	var list []map[string]interface{}
+	for i := len(f.postfixLog) - 1; i >= 0 && len(list) < logCount(r); i-- {
+		line := f.postfixLog[i]
+		list = append(list, map[string]interface{}{
+			"time":     strconv.FormatInt(line.time.Unix(), 10),
+			"priority": "info",
+			"program":  line.program,
+			"message":  line.message,
+		})
+	}
+	writeList(w, list)
+}
+
+func (f *Fake) handleRspamdHistory(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// Newest first, timestamps with fractional seconds
+	var list []map[string]interface{}
+	for i := len(f.rspamd) - 1; i >= 0 && len(list) < logCount(r); i-- {
+		entry := f.rspamd[i]
+		list = append(list, map[string]interface{}{
+			"unix_time":   float64(entry.Time.UnixNano()) / float64(time.Second),
+			"action":      entry.Action,
+			"sender_smtp": entry.Sender,
+			"rcpt_smtp":   entry.Recipients,
+		})
+	}
+	writeList(w, list)
+}
+
+// knownDomain reports whether aliases can be created on a domain, the caller must hold the lock
+func (f *Fake) knownDomain(domain string) bool {
+	if _, ok := f.domains[domain]; ok {
+		return true
+	}
+	_, ok := f.aliasDomains[domain]
+	return ok
+}
+
+// addressTaken reports whether an address is an alias or mailbox, the caller must hold the lock
+func (f *Fake) addressTaken(address string) bool {
+	if _, ok := f.mailboxes[strings.ToLower(address)]; ok {
+		return true
+	}
+	for _, alias := range f.aliases {
+		if strings.EqualFold(alias.Address, address) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Fake) handleAddAlias(w http.ResponseWriter, r *http.Request) {
+	var request map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeResult(w, "danger", "invalid_json")
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	address := request["address"]
+	switch {
+	case !strings.Contains(address, "@"):
+		writeResult(w, "danger", "alias_invalid", address)
+	case !f.knownDomain(domainOf(address)):
+		writeResult(w, "danger", "domain_not_found", domainOf(address))
+	case request["goto"] == "":
+		writeResult(w, "danger", "goto_empty")
+	case f.addressTaken(address):
+		writeResult(w, "danger", "is_alias_or_mailbox", address)
+	default:
+		f.aliases = append(f.aliases, Alias{
+			ID:             f.nextID,
+			Address:        address,
+			Goto:           request["goto"],
+			Active:         request["active"] != "0",
+			PrivateComment: request["private_comment"],
+			SenderAllowed:  request["sender_allowed"] == "1",
+			Created:        time.Now(),
+		})
+		f.nextID++
+		writeResult(w, "success", "alias_added", address, f.nextID-1)
+	}
+}
+
+// itemIDs decodes alias IDs, which clients send as numbers or strings
+func itemIDs(items []interface{}) map[int]bool {
+	ids := make(map[int]bool)
+	for _, item := range items {
+		if id, err := strconv.Atoi(fmt.Sprint(item)); err == nil {
+			ids[id] = true
+		}
+	}
+	return ids
+}
+
+func (f *Fake) handleEditAlias(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Items []interface{}     `json:"items"`
+		Attr  map[string]string `json:"attr"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeResult(w, "danger", "invalid_json")
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ids := itemIDs(request.Items)
+	edited := 0
+	for i := range f.aliases {
+		alias := &f.aliases[i]
+		if !ids[alias.ID] {
+			continue
+		}
+		if value, ok := request.Attr["active"]; ok {
+			alias.Active = value == "1"
+		}
+		if value, ok := request.Attr["private_comment"]; ok {
+			alias.PrivateComment = value
+		}
+		if value, ok := request.Attr["goto"]; ok {
+			alias.Goto = value
+		}
+		edited++
+	}
+	if edited == 0 {
+		writeResult(w, "danger", "access_denied")
+		return
+	}
+	writeResult(w, "success", "alias_modified")
+}
+
+func (f *Fake) handleDeleteAlias(w http.ResponseWriter, r *http.Request) {
+	var items []interface{}
+	if err := json.NewDecoder(r.Body).Decode(&items); err != nil {
+		writeResult(w, "danger", "invalid_json")
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ids := itemIDs(items)
+	kept := f.aliases[:0]
+	for _, alias := range f.aliases {
+		if !ids[alias.ID] {
+			kept = append(kept, alias)
+		}
+	}
+	if len(kept) == len(f.aliases) {
+		writeResult(w, "danger", "access_denied")
+		return
+	}
+	f.aliases = kept
+	writeResult(w, "success", "alias_removed")
+}
+
+func (f *Fake) handleAddTimeLimitedAlias(w http.ResponseWriter, r *http.Request) {
+	var request map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeResult(w, "danger", "invalid_json")
+		return
+	}
+	hours, err := strconv.Atoi(request["validity"])
+	if err != nil || hours < 1 {
+		writeResult(w, "danger", "validity_invalid")
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	username := strings.ToLower(request["username"])
+	if _, ok := f.mailboxes[username]; !ok {
+		writeResult(w, "danger", "access_denied")
+		return
+	}
+	domain := strings.ToLower(request["domain"])
+	if domain == "" {
+		domain = domainOf(username)
+	}
+	if !f.knownDomain(domain) {
+		writeResult(w, "danger", "domain_not_found", domain)
+		return
+	}
+
+	random := make([]byte, 6)
+	rand.Read(random)
+	f.timeLimited[username] = append(f.timeLimited[username], TimeLimitedAlias{
+		Address:     hex.EncodeToString(random) + "@" + domain,
+		Goto:        username,
+		Description: request["description"],
+		Validity:    time.Now().Add(time.Duration(hours) * time.Hour),
+	})
+	writeResult(w, "success", "mailbox_modified", username)
+}
+
+// handleControlFault injects faults: POST /fake/fail?path=/api/v1/add/alias&count=1 answers with
+// status 500, POST /fake/danger?path=...&count=1&msg=... with a danger message
+func (f *Fake) handleControlFault(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		http.Error(w, "path required", http.StatusBadRequest)
+		return
+	}
+	count, err := strconv.Atoi(r.URL.Query().Get("count"))
+	if err != nil || count < 1 {
+		count = 1
+	}
+	if strings.HasSuffix(r.URL.Path, "/danger") {
+		message := r.URL.Query().Get("msg")
+		if message == "" {
+			message = "injected_danger"
+		}
+		f.DangerNext(path, count, message)
+	} else {
+		f.FailNext(path, count)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleControlLatency sets the latency: POST /fake/latency?duration=500ms
+func (f *Fake) handleControlLatency(w http.ResponseWriter, r *http.Request) {
+	latency, err := time.ParseDuration(r.URL.Query().Get("duration"))
+	if err != nil {
+		http.Error(w, "invalid duration", http.StatusBadRequest)
+		return
+	}
+	f.SetLatency(latency)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleControlUnreachable drops API connections: POST /fake/unreachable?enabled=true, false to stop
+func (f *Fake) handleControlUnreachable(w http.ResponseWriter, r *http.Request) {
+	enabled, err := strconv.ParseBool(r.URL.Query().Get("enabled"))
+	if err != nil {
+		http.Error(w, "invalid enabled flag", http.StatusBadRequest)
+		return
+	}
+	f.SetUnreachable(enabled)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleControlDelivery logs a delivery: POST /fake/delivery?from=...&to=alias@...&mailbox=...
+func (f *Fake) handleControlDelivery(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("from") == "" || query.Get("to") == "" || query.Get("mailbox") == "" {
+		http.Error(w, "from, to and mailbox required", http.StatusBadRequest)
+		return
+	}
+	f.AddDelivery(query.Get("from"), query.Get("mailbox"), query.Get("to"), time.Now())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleControlRequests lists the API requests received so far
+func (f *Fake) handleControlRequests(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, f.Requests())
+}
+
+// sortBy sorts list entries by a string field for stable responses
+func sortBy(list []map[string]interface{}, field string) {
+	sort.Slice(list, func(i, j int) bool { return fmt.Sprint(list[i][field]) < fmt.Sprint(list[j][field]) })
+}
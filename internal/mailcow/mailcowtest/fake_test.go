@@ -0,0 +1,157 @@
+package mailcowtest
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/mailcow"
+)
+
+// newClient starts a fake with a mailbox and returns a client connected to it
+func newClient(t *testing.T) (*Server, *mailcow.MailcowClient) {
+	t.Helper()
+	server := NewServer("key")
+	t.Cleanup(server.Close)
+	server.AddMailbox(Mailbox{Username: "alice@example.com", Name: "Alice", Active: true, Tags: []string{"staff"}})
+	server.AddAliasDomain("alias.example", "example.com", true)
+
+	client, err := mailcow.NewMailcowClient(server.URL, "key")
+	if err != nil {
+		t.Fatalf("NewMailcowClient: %v", err)
+	}
+	return server, client
+}
+
+func TestClientAgainstFake(t *testing.T) {
+	server, client := newClient(t)
+
+	mailbox, err := client.GetMailbox("Alice@example.com")
+	if err != nil || mailbox.Name != "Alice" || !mailbox.IsActive() || len(mailbox.Tags) != 1 || mailbox.CreatedAt().IsZero() {
+		t.Fatalf("GetMailbox = %+v, %v", mailbox, err)
+	}
+	if _, err := client.GetMailbox("nobody@example.com"); !errors.Is(err, mailcow.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for an unknown mailbox, got %v", err)
+	}
+	if err := client.CheckDomain("alias.example"); err != nil {
+		t.Errorf("CheckDomain(alias.example) = %v", err)
+	}
+	if err := client.CheckDomain("other.org"); !errors.Is(err, mailcow.ErrUnknownDomain) {
+		t.Errorf("expected ErrUnknownDomain, got %v", err)
+	}
+
+	// An empty alias list is {}
+	if aliases, err := client.GetAliases(); err != nil || len(aliases) != 0 {
+		t.Fatalf("GetAliases = %+v, %v", aliases, err)
+	}
+
+	if err := client.CreateAlias("shop@example.com", "alice@example.com", mailcow.AliasOptions{Comment: "shop.example"}); err != nil {
+		t.Fatalf("CreateAlias: %v", err)
+	}
+	if err := client.CreateAlias("shop@example.com", "alice@example.com", mailcow.AliasOptions{}); err == nil {
+		t.Error("CreateAlias accepted a duplicate alias")
+	}
+	if err := client.CreateAlias("shop@other.org", "alice@example.com", mailcow.AliasOptions{}); err == nil {
+		t.Error("CreateAlias accepted an unknown domain")
+	}
+
+	inactive := false
+	if err := client.UpdateAlias("shop@example.com", mailcow.AliasUpdate{Active: &inactive}); err != nil {
+		t.Fatalf("UpdateAlias: %v", err)
+	}
+	if alias, ok := server.Alias("shop@example.com"); !ok || alias.Active || alias.PrivateComment != "shop.example" {
+		t.Errorf("unexpected alias after update: %+v", alias)
+	}
+	if err := client.DeleteAlias("shop@example.com"); err != nil {
+		t.Fatalf("DeleteAlias: %v", err)
+	}
+	if len(server.Aliases()) != 0 {
+		t.Errorf("alias still present after deletion: %+v", server.Aliases())
+	}
+
+	temporary, err := client.CreateTimeLimitedAlias("alice@example.com", "alias.example", "forum", 2*time.Hour)
+	if err != nil {
+		t.Fatalf("CreateTimeLimitedAlias: %v", err)
+	}
+	if until := time.Until(temporary.ExpiresAt()); until < time.Hour || until > 2*time.Hour+time.Minute {
+		t.Errorf("unexpected validity of time-limited alias: %v", temporary.ExpiresAt())
+	}
+}
+
+func TestFakeLogs(t *testing.T) {
+	server, client := newClient(t)
+	at := time.Now().Add(-time.Minute).Truncate(time.Second)
+	server.AddDelivery("shop@example.net", "alice@example.com", "shop@example.com", at)
+	server.AddRspamdEntry(RspamdEntry{Time: at, Action: "reject", Sender: "spam@example.net", Recipients: []string{"shop@example.com"}})
+
+	lines, err := client.GetPostfixLogs(10)
+	if err != nil || len(lines) != 2 {
+		t.Fatalf("GetPostfixLogs = %+v, %v", lines, err)
+	}
+	// Newest first: the delivery follows the queue manager line
+	if !lines[0].IsDelivery() || lines[0].QueueID() != lines[1].QueueID() || lines[1].Sender() != "shop@example.net" || !lines[0].At().Equal(at) {
+		t.Errorf("unexpected log lines: %+v", lines)
+	}
+
+	history, err := client.GetRspamdHistory(10)
+	if err != nil || len(history) != 1 || !history[0].IsRejected() || !history[0].At().Equal(at) {
+		t.Errorf("GetRspamdHistory = %+v, %v", history, err)
+	}
+}
+
+func TestFakeFaults(t *testing.T) {
+	server, client := newClient(t)
+
+	server.FailNext("/api/v1/get/alias", 1)
+	if _, err := client.GetAliases(); err == nil {
+		t.Error("expected the injected failure to be reported")
+	}
+	if _, err := client.GetAliases(); err != nil {
+		t.Errorf("expected the fault to be used up, got %v", err)
+	}
+
+	server.DangerNext("/api/v1/add/alias", 1, "alias_invalid")
+	if err := client.CreateAlias("shop@example.com", "alice@example.com", mailcow.AliasOptions{}); err == nil {
+		t.Error("expected the injected danger message to be reported")
+	}
+	if len(server.Aliases()) != 0 {
+		t.Errorf("rejected alias was created: %+v", server.Aliases())
+	}
+
+	server.SetLatency(50 * time.Millisecond)
+	start := time.Now()
+	if _, err := client.GetAliases(); err != nil || time.Since(start) < 50*time.Millisecond {
+		t.Errorf("expected a delayed response, got %v after %v", err, time.Since(start))
+	}
+
+	// Requests with another API key are rejected
+	wrongKey, err := mailcow.NewMailcowClient(server.URL, "wrong")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wrongKey.CheckAPIConnectivity(); err == nil {
+		t.Error("expected a wrong API key to be rejected")
+	}
+	if requests := server.Requests(); len(requests) < 3 || requests[2] != "POST /api/v1/add/alias" {
+		t.Errorf("unexpected request log: %v", requests)
+	}
+}
+
+func TestFakeControlEndpoints(t *testing.T) {
+	server, client := newClient(t)
+
+	response, err := http.Post(server.URL+"/fake/fail?path=/api/v1/get/mailbox&count=2", "", nil)
+	if err != nil || response.StatusCode != http.StatusNoContent {
+		t.Fatalf("POST /fake/fail = %v, %v", response, err)
+	}
+	response.Body.Close()
+	for i := 0; i < 2; i++ {
+		if _, err := client.GetMailbox("alice@example.com"); err == nil {
+			t.Errorf("expected injected failure %d", i+1)
+		}
+	}
+	if _, err := client.GetMailbox("alice@example.com"); err != nil {
+		t.Errorf("expected the faults to be used up, got %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package mailcow
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mailcowTime decodes Unix timestamps, which Mailcow encodes as numbers or strings
+type mailcowTime time.Time
+
+// UnmarshalJSON implements json.Unmarshaler
+func (t *mailcowTime) UnmarshalJSON(data []byte) error {
+	value := strings.Trim(string(data), `"`)
+	if value == "" || value == "null" {
+		*t = mailcowTime{}
+		return nil
+	}
+	// Some logs carry fractional seconds
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp %q", value)
+	}
+	*t = mailcowTime(time.Unix(0, int64(seconds*float64(time.Second))).UTC())
+	return nil
+}
+
+// TimeLimitedAlias is a Mailcow time-limited alias, which expires on its own
+type TimeLimitedAlias struct {
+	Address  string      `json:"address"`
+	Goto     string      `json:"goto"`
+	Validity mailcowTime `json:"validity"`
+}
+
+// ExpiresAt returns when the alias expires
+func (a *TimeLimitedAlias) ExpiresAt() time.Time {
+	return time.Time(a.Validity)
+}
+
+// GetTimeLimitedAliases returns the time-limited aliases of a mailbox
+func (c *MailcowClient) GetTimeLimitedAliases(username string) ([]TimeLimitedAlias, error) {
+	body, err := c.get("/api/v1/get/time_limited_aliases/" + url.PathEscape(strings.ToLower(username)))
+	if err != nil {
+		return nil, err
+	}
+
+	var aliases []TimeLimitedAlias
+	if err := decodeList(body, &aliases); err != nil {
+		return nil, fmt.Errorf("failed to decode time-limited aliases: %w", err)
+	}
+	return aliases, nil
+}
+
+// CreateTimeLimitedAlias creates a time-limited alias for a mailbox on domain. Mailcow
+// chooses the address itself, so the new alias is found by comparing the alias lists.
+// Calls for the same mailbox must not run concurrently.
+func (c *MailcowClient) CreateTimeLimitedAlias(username, domain, description string, validity time.Duration) (*TimeLimitedAlias, error) {
+	before, err := c.GetTimeLimitedAliases(username)
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]bool, len(before))
+	for _, alias := range before {
+		existing[strings.ToLower(alias.Address)] = true
+	}
+
+	hours := int(math.Max(1, math.Ceil(validity.Hours())))
+	c.logger.Info("Creating time-limited alias on %s for %s, valid for %dh", domain, username, hours)
+	_, err = c.post("/api/v1/add/time_limited_alias", map[string]string{
+		"username":    username,
+		"domain":      domain,
+		"description": description,
+		"validity":    strconv.Itoa(hours),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create time-limited alias: %w", err)
+	}
+
+	after, err := c.GetTimeLimitedAliases(username)
+	if err != nil {
+		return nil, err
+	}
+	for _, alias := range after {
+		if !existing[strings.ToLower(alias.Address)] {
+			c.logger.Info("Created time-limited alias %s, expires %s", alias.Address, alias.ExpiresAt().Format(time.RFC3339))
+			return &alias, nil
+		}
+	}
+	return nil, fmt.Errorf("created time-limited alias not found in Mailcow")
+}
@@ -0,0 +1,218 @@
+package mailu
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/logger"
+)
+
+// ErrNotFound is returned when a requested Mailu object does not exist
+var ErrNotFound = errors.New("not found in Mailu")
+
+// ErrUnavailable is returned when the Mailu API cannot be reached, and without contacting it
+// until CheckAPIConnectivity succeeds again
+var ErrUnavailable = errors.New("Mailu API unavailable")
+
+// Client is a client for the Mailu REST API
+type Client struct {
+	apiURL     string
+	apiToken   string
+	httpClient *http.Client
+	// unavailable is set while the API cannot be reached
+	unavailable atomic.Bool
+	logger      *logger.Logger
+}
+
+// NewClient creates a client for the Mailu instance at apiURL, e.g. https://mail.example.com,
+// authenticating with the API token configured in Mailu's API_TOKEN
+func NewClient(apiURL, apiToken string) (*Client, error) {
+	if apiURL == "" || apiToken == "" {
+		return nil, fmt.Errorf("apiURL and apiToken must be set")
+	}
+
+	return &Client{
+		apiURL:     strings.TrimSuffix(apiURL, "/"),
+		apiToken:   apiToken,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		logger:     logger.WithComponent("Mailu"),
+	}, nil
+}
+
+// Available reports whether the API was reachable at the last contact
+func (c *Client) Available() bool {
+	return !c.unavailable.Load()
+}
+
+// setAvailable records whether the API could be reached and logs changes
+func (c *Client) setAvailable(err error) {
+	wasUnavailable := c.unavailable.Swap(err != nil)
+	if err != nil && !wasUnavailable {
+		c.logger.Warn("Mailu API at %s is unavailable, failing requests until it recovers: %v", c.apiURL, err)
+	} else if err == nil && wasUnavailable {
+		c.logger.Info("Mailu API at %s is available again", c.apiURL)
+	}
+}
+
+// CheckAPIConnectivity lists the domains to verify the API is accessible and records the result.
+// While the API is unreachable other requests fail with ErrUnavailable, so call it periodically
+// to notice recovery.
+func (c *Client) CheckAPIConnectivity() error {
+	err := c.do("GET", "/api/v1/domain", nil, nil)
+	c.setAvailable(err)
+	return err
+}
+
+// Alias is a Mailu alias
+type Alias struct {
+	Email       string   `json:"email"`
+	Destination []string `json:"destination"`
+	Comment     string   `json:"comment"`
+	Wildcard    bool     `json:"wildcard"`
+}
+
+// AliasUpdate lists the attributes of an alias to change, nil fields are kept
+type AliasUpdate struct {
+	Destination []string `json:"destination,omitempty"`
+	Comment     *string  `json:"comment,omitempty"`
+}
+
+// Domain is a Mailu mail domain
+type Domain struct {
+	Name    string `json:"name"`
+	Comment string `json:"comment"`
+}
+
+// GetAliases returns all aliases
+func (c *Client) GetAliases() ([]Alias, error) {
+	var aliases []Alias
+	if err := c.request("GET", "/api/v1/alias", nil, &aliases); err != nil {
+		return nil, err
+	}
+	return aliases, nil
+}
+
+// GetAlias looks up an alias by its address
+func (c *Client) GetAlias(email string) (*Alias, error) {
+	var alias Alias
+	if err := c.request("GET", "/api/v1/alias/"+url.PathEscape(strings.ToLower(email)), nil, &alias); err != nil {
+		return nil, err
+	}
+	return &alias, nil
+}
+
+// CreateAlias creates a new alias
+func (c *Client) CreateAlias(alias Alias) error {
+	c.logger.Info("Creating new Mailu alias: %s -> %s", alias.Email, strings.Join(alias.Destination, ","))
+	return c.request("POST", "/api/v1/alias", alias, nil)
+}
+
+// UpdateAlias changes the attributes of an alias
+func (c *Client) UpdateAlias(email string, update AliasUpdate) error {
+	return c.request("PATCH", "/api/v1/alias/"+url.PathEscape(strings.ToLower(email)), update, nil)
+}
+
+// DeleteAlias deletes an alias
+func (c *Client) DeleteAlias(email string) error {
+	return c.request("DELETE", "/api/v1/alias/"+url.PathEscape(strings.ToLower(email)), nil, nil)
+}
+
+// GetDomain looks up a mail domain by its name
+func (c *Client) GetDomain(name string) (*Domain, error) {
+	var domain Domain
+	if err := c.request("GET", "/api/v1/domain/"+url.PathEscape(strings.ToLower(name)), nil, &domain); err != nil {
+		return nil, err
+	}
+	return &domain, nil
+}
+
+// request executes a request against the Mailu API and decodes the response into result, if given.
+// Unknown objects are answered with status 404 and reported as ErrNotFound. While the API is known
+// to be unreachable it fails fast with ErrUnavailable.
+func (c *Client) request(method, path string, payload, result interface{}) error {
+	if !c.Available() {
+		return fmt.Errorf("%w: %s", ErrUnavailable, c.apiURL)
+	}
+	return c.do(method, path, payload, result)
+}
+
+// do executes a request against the Mailu API, see request
+func (c *Client) do(method, path string, payload, result interface{}) error {
+	requestID := fmt.Sprintf("MAILU-%d", time.Now().UnixNano())
+	log := c.logger.WithRequestID(requestID)
+
+	var requestBody io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			log.Error("Failed to marshal request body: %v", err)
+			return fmt.Errorf("failed to marshal request body: %w", err)
+		}
+		requestBody = bytes.NewReader(data)
+	}
+
+	log.Debug("Preparing HTTP request to: %s %s", method, c.apiURL+path)
+	req, err := http.NewRequest(method, c.apiURL+path, requestBody)
+	if err != nil {
+		log.Error("Failed to create request: %v", err)
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.apiToken)
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	startTime := time.Now()
+	resp, err := c.httpClient.Do(req)
+	requestDuration := time.Since(startTime)
+
+	if err != nil {
+		log.Error("Failed to execute request (took %s): %v", logger.FormatDuration(requestDuration), err)
+		c.setAvailable(err)
+		return fmt.Errorf("failed to execute request: %w: %w", ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	log.Debug("Received response in %s with status code: %d", logger.FormatDuration(requestDuration), resp.StatusCode)
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Error("Failed to read response body: %v", err)
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", ErrNotFound, errorMessage(body))
+	}
+	if resp.StatusCode != http.StatusOK {
+		log.Error("Error response body: %s", string(body))
+		return fmt.Errorf("Mailu rejected the request, status code: %d, response: %s", resp.StatusCode, errorMessage(body))
+	}
+
+	if result != nil {
+		if err := json.Unmarshal(body, result); err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
+	}
+	return nil
+}
+
+// errorMessage returns the message of a Mailu error response, or the body if it has none
+func errorMessage(body []byte) string {
+	var response struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(body, &response); err == nil && response.Message != "" {
+		return response.Message
+	}
+	return strings.TrimSpace(string(body))
+}
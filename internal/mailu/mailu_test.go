@@ -0,0 +1,141 @@
+package mailu
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// recordedRequest is a request received by the test server
+type recordedRequest struct {
+	method, path, authorization, contentType string
+	body                                     string
+}
+
+func TestClientRequests(t *testing.T) {
+	var requests []recordedRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, recordedRequest{r.Method, r.URL.EscapedPath(), r.Header.Get("Authorization"), r.Header.Get("Content-Type"), string(body)})
+
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/api/v1/alias":
+			w.Write([]byte(`[{"email": "shop@example.com", "destination": ["alice@example.com"], "comment": "shop.example", "wildcard": false}]`))
+		case r.Method == "GET" && r.URL.Path == "/api/v1/alias/shop@example.com":
+			w.Write([]byte(`{"email": "shop@example.com", "destination": ["alice@example.com", "team@example.com"]}`))
+		case r.Method == "GET" && r.URL.Path == "/api/v1/domain/example.com":
+			w.Write([]byte(`{"name": "example.com", "comment": "main"}`))
+		case r.Method == "POST" && r.URL.Path == "/api/v1/alias":
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(`{"code": 409, "message": "Duplicate alias"}`))
+		case r.Method == "PATCH" || r.Method == "DELETE":
+			w.Write([]byte(`{"code": 200, "message": "ok"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"code": 404, "message": "Alias not found"}`))
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL+"/", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aliases, err := client.GetAliases()
+	if err != nil || len(aliases) != 1 || aliases[0].Email != "shop@example.com" || aliases[0].Comment != "shop.example" {
+		t.Fatalf("GetAliases = %+v, %v", aliases, err)
+	}
+	alias, err := client.GetAlias("Shop@example.com")
+	if err != nil || len(alias.Destination) != 2 {
+		t.Fatalf("GetAlias = %+v, %v", alias, err)
+	}
+	if _, err := client.GetAlias("nobody@example.com"); !errors.Is(err, ErrNotFound) || !strings.Contains(err.Error(), "Alias not found") {
+		t.Errorf("expected ErrNotFound with the Mailu message, got %v", err)
+	}
+	if domain, err := client.GetDomain("EXAMPLE.com"); err != nil || domain.Name != "example.com" {
+		t.Errorf("GetDomain = %+v, %v", domain, err)
+	}
+
+	err = client.CreateAlias(Alias{Email: "new@example.com", Destination: []string{"alice@example.com"}})
+	if err == nil || errors.Is(err, ErrNotFound) || !strings.Contains(err.Error(), "Duplicate alias") || !strings.Contains(err.Error(), "409") {
+		t.Errorf("expected the rejection to be reported with its message, got %v", err)
+	}
+	comment := "forum"
+	if err := client.UpdateAlias("new@example.com", AliasUpdate{Comment: &comment}); err != nil {
+		t.Errorf("UpdateAlias: %v", err)
+	}
+	if err := client.DeleteAlias("new@example.com"); err != nil {
+		t.Errorf("DeleteAlias: %v", err)
+	}
+
+	for _, request := range requests {
+		if request.authorization != "Bearer token" {
+			t.Errorf("%s %s sent without the API token", request.method, request.path)
+		}
+	}
+	create, update, remove := requests[len(requests)-3], requests[len(requests)-2], requests[len(requests)-1]
+	var created Alias
+	if err := json.Unmarshal([]byte(create.body), &created); err != nil || create.contentType != "application/json" || created.Email != "new@example.com" {
+		t.Errorf("unexpected create request: %+v", create)
+	}
+	// Unset attributes are left out, so Mailu keeps them
+	if update.method != "PATCH" || update.path != "/api/v1/alias/new@example.com" || update.body != `{"comment":"forum"}` {
+		t.Errorf("unexpected update request: %+v", update)
+	}
+	if remove.method != "DELETE" || remove.path != "/api/v1/alias/new@example.com" || remove.body != "" {
+		t.Errorf("unexpected delete request: %+v", remove)
+	}
+}
+
+func TestUnavailableAPIFailsFast(t *testing.T) {
+	var down atomic.Bool
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if down.Load() {
+			// Drop the connection like an unreachable server
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.CheckAPIConnectivity(); err != nil || !client.Available() {
+		t.Fatalf("CheckAPIConnectivity = %v", err)
+	}
+
+	down.Store(true)
+	if _, err := client.GetAliases(); !errors.Is(err, ErrUnavailable) || client.Available() {
+		t.Fatalf("expected ErrUnavailable for a dropped connection, got %v", err)
+	}
+	before := requests.Load()
+	if _, err := client.GetDomain("example.com"); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("expected requests to fail fast, got %v", err)
+	}
+	if requests.Load() != before {
+		t.Error("request reached the server while it was unavailable")
+	}
+	if err := client.CheckAPIConnectivity(); err == nil {
+		t.Error("expected the check to fail while the server is down")
+	}
+
+	down.Store(false)
+	if err := client.CheckAPIConnectivity(); err != nil || !client.Available() {
+		t.Fatalf("expected the API to recover, got %v", err)
+	}
+	if _, err := client.GetAliases(); err != nil {
+		t.Errorf("GetAliases after recovery: %v", err)
+	}
+}
@@ -0,0 +1,266 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/alias"
+)
+
+// Actions of a rule
+const (
+	ActionAllow = "allow"
+	ActionDeny  = "deny"
+)
+
+// Garbage collection actions for unused aliases
+const (
+	GCActionKeep       = "keep"
+	GCActionDeactivate = "deactivate"
+	GCActionDelete     = "delete"
+)
+
+// OwnDomain stands for the own alias domains of the user's mailbox in alias domain lists,
+// which are the mailbox's domain unless mapped to dedicated alias domains
+const OwnDomain = "%d"
+
+// Policy decides which users may create aliases and how
+type Policy struct {
+	// Default action if no rule matches
+	Default string `json:"default"`
+	// Rules are evaluated in order, the first matching rule decides
+	Rules []Rule `json:"rules"`
+	// Groups are evaluated in order, the first matching group grants its settings
+	Groups []Group `json:"groups"`
+
+	// defaultDomains are granted by the default group in addition to the own domain
+	defaultDomains []string
+}
+
+// Match selects mailboxes by address, domain or Mailcow tag.
+// A mailbox matches if any of the listed values match; an empty match selects every mailbox.
+type Match struct {
+	Mailboxes []string `json:"mailboxes,omitempty"`
+	Domains   []string `json:"domains,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+}
+
+// Rule allows or denies alias creation for matching mailboxes
+type Rule struct {
+	Action string `json:"action"`
+	Match
+}
+
+// Group grants alias settings to matching mailboxes
+type Group struct {
+	Name  string `json:"name"`
+	Match Match  `json:"match"`
+	// AliasDomains lists the domains aliases may be created on, "%d" is the mailbox's own domain
+	AliasDomains []string `json:"alias_domains"`
+	// AllowCustomPrefix permits choosing the local part of aliases
+	AllowCustomPrefix bool `json:"allow_custom_prefix"`
+	// Limits overrides the globally configured alias limits
+	Limits *Limits `json:"limits,omitempty"`
+	// SenderAllowed overrides whether owners may send as their aliases
+	SenderAllowed *bool `json:"sender_allowed,omitempty"`
+	// AliasType overrides the type of random aliases, "permanent", "temporary" or "subaddress"
+	AliasType string `json:"alias_type,omitempty"`
+	// AllowAliasTypeChoice lets requests choose the alias type, by default only if AliasType is unset
+	AllowAliasTypeChoice *bool `json:"allow_alias_type_choice,omitempty"`
+	// GCAction overrides what happens to unused aliases, "keep", "deactivate" or "delete"
+	GCAction string `json:"gc_action,omitempty"`
+}
+
+// Limits bounds the alias creations of a mailbox, 0 means unlimited
+type Limits struct {
+	MaxAliases int `json:"max_aliases"`
+	PerHour    int `json:"per_hour"`
+	PerDay     int `json:"per_day"`
+}
+
+// Subject is the mailbox a decision is made for
+type Subject struct {
+	Mailbox string
+	Domain  string
+	Tags    []string
+	// OwnDomains are the domains OwnDomain expands to, Domain if empty
+	OwnDomains []string
+}
+
+// Decision is the result of evaluating the policy for a subject
+type Decision struct {
+	Allowed           bool
+	Reason            string
+	Group             string
+	AliasDomains      []string // resolved, the own domains are already substituted
+	AllowCustomPrefix bool
+	Limits            *Limits // nil if the global limits apply
+	SenderAllowed     *bool   // nil if the global setting applies
+	AliasType         string  // empty if the global setting applies
+	// AllowAliasTypeChoice permits requests to pick another alias type than AliasType
+	AllowAliasTypeChoice bool
+	GCAction             string // empty if the global setting applies
+}
+
+// defaultGroup applies if no group matches. Custom prefixes have to be granted by a group.
+var defaultGroup = Group{
+	Name:         "default",
+	AliasDomains: []string{OwnDomain},
+}
+
+// Default returns the policy used without a policy file: everyone may create random aliases on their
+// own domain and on the given domains, which are those the configured pattern generates aliases on.
+func Default(aliasDomains ...string) *Policy {
+	return &Policy{Default: ActionAllow, defaultDomains: aliasDomains}
+}
+
+// Load reads a policy from a JSON file
+func Load(path string) (*Policy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file: %w", err)
+	}
+
+	var p Policy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("failed to parse policy file: %w", err)
+	}
+
+	if err := p.validate(); err != nil {
+		return nil, fmt.Errorf("invalid policy: %w", err)
+	}
+	return &p, nil
+}
+
+// validate checks actions and normalizes the policy
+func (p *Policy) validate() error {
+	p.Default = strings.ToLower(p.Default)
+	if p.Default == "" {
+		p.Default = ActionAllow
+	}
+	if p.Default != ActionAllow && p.Default != ActionDeny {
+		return fmt.Errorf("default must be %q or %q", ActionAllow, ActionDeny)
+	}
+
+	for i := range p.Rules {
+		p.Rules[i].Action = strings.ToLower(p.Rules[i].Action)
+		if p.Rules[i].Action != ActionAllow && p.Rules[i].Action != ActionDeny {
+			return fmt.Errorf("rule %d: action must be %q or %q", i+1, ActionAllow, ActionDeny)
+		}
+	}
+
+	for i, group := range p.Groups {
+		if group.Name == "" {
+			p.Groups[i].Name = fmt.Sprintf("group-%d", i+1)
+		}
+		if len(group.AliasDomains) == 0 {
+			p.Groups[i].AliasDomains = []string{OwnDomain}
+		}
+		p.Groups[i].AliasType = strings.ToLower(group.AliasType)
+		if t := p.Groups[i].AliasType; t != "" && !alias.ValidType(t) {
+			return fmt.Errorf("group %s: alias_type must be %q, %q or %q", p.Groups[i].Name, alias.TypePermanent, alias.TypeTemporary, alias.TypeSubaddress)
+		}
+		p.Groups[i].GCAction = strings.ToLower(group.GCAction)
+		if a := p.Groups[i].GCAction; a != "" && !ValidGCAction(a) {
+			return fmt.Errorf("group %s: gc_action must be %q, %q or %q", p.Groups[i].Name, GCActionKeep, GCActionDeactivate, GCActionDelete)
+		}
+	}
+	return nil
+}
+
+// ValidGCAction reports whether action is a known garbage collection action
+func ValidGCAction(action string) bool {
+	return action == GCActionKeep || action == GCActionDeactivate || action == GCActionDelete
+}
+
+// matches reports whether the match selects the subject
+func (m *Match) matches(s Subject) bool {
+	if len(m.Mailboxes) == 0 && len(m.Domains) == 0 && len(m.Tags) == 0 {
+		return true
+	}
+
+	for _, mailbox := range m.Mailboxes {
+		if strings.EqualFold(mailbox, s.Mailbox) {
+			return true
+		}
+	}
+	for _, domain := range m.Domains {
+		if domain == "*" || strings.EqualFold(domain, s.Domain) {
+			return true
+		}
+	}
+	for _, tag := range m.Tags {
+		for _, subjectTag := range s.Tags {
+			if strings.EqualFold(tag, subjectTag) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Evaluate decides whether and how the subject may create aliases
+func (p *Policy) Evaluate(s Subject) Decision {
+	decision := Decision{Allowed: p.Default == ActionAllow, Reason: "default policy"}
+
+	for i, rule := range p.Rules {
+		if rule.matches(s) {
+			decision.Allowed = rule.Action == ActionAllow
+			decision.Reason = fmt.Sprintf("rule %d", i+1)
+			break
+		}
+	}
+	if !decision.Allowed {
+		return decision
+	}
+
+	group := defaultGroup
+	if len(p.defaultDomains) > 0 {
+		group.AliasDomains = append(append([]string(nil), defaultGroup.AliasDomains...), p.defaultDomains...)
+	}
+	for _, candidate := range p.Groups {
+		if candidate.Match.matches(s) {
+			group = candidate
+			break
+		}
+	}
+
+	decision.Group = group.Name
+	decision.AllowCustomPrefix = group.AllowCustomPrefix
+	decision.Limits = group.Limits
+	decision.SenderAllowed = group.SenderAllowed
+	decision.AliasType = group.AliasType
+	decision.AllowAliasTypeChoice = group.AliasType == ""
+	if group.AllowAliasTypeChoice != nil {
+		decision.AllowAliasTypeChoice = *group.AllowAliasTypeChoice
+	}
+	decision.GCAction = group.GCAction
+	ownDomains := s.OwnDomains
+	if len(ownDomains) == 0 {
+		ownDomains = []string{s.Domain}
+	}
+	for _, domain := range group.AliasDomains {
+		domains := []string{domain}
+		if domain == OwnDomain {
+			domains = ownDomains
+		}
+		for _, domain := range domains {
+			if !decision.AllowsDomain(domain) {
+				decision.AliasDomains = append(decision.AliasDomains, strings.ToLower(domain))
+			}
+		}
+	}
+	return decision
+}
+
+// AllowsDomain reports whether aliases may be created on the domain
+func (d *Decision) AllowsDomain(domain string) bool {
+	for _, allowed := range d.AliasDomains {
+		if strings.EqualFold(allowed, domain) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEvaluate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	err := os.WriteFile(path, []byte(`{
+		"default": "deny",
+		"rules": [
+			{"action": "deny", "tags": ["no-aliases"]},
+			{"action": "allow", "domains": ["example.com"]},
+			{"action": "allow", "mailboxes": ["guest@other.org"]}
+		],
+		"groups": [
+			{"name": "staff", "match": {"tags": ["staff"]}, "alias_domains": ["%d", "alias.example.com"], "allow_custom_prefix": true},
+			{"name": "everyone", "alias_domains": ["relay.example.net"]}
+		]
+	}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	staff := p.Evaluate(Subject{Mailbox: "alice@example.com", Domain: "example.com", Tags: []string{"Staff"}})
+	if !staff.Allowed || staff.Group != "staff" || !staff.AllowCustomPrefix {
+		t.Errorf("unexpected decision for staff: %+v", staff)
+	}
+	if !staff.AllowsDomain("example.com") || !staff.AllowsDomain("alias.example.com") || staff.AllowsDomain("relay.example.net") {
+		t.Errorf("unexpected alias domains for staff: %v", staff.AliasDomains)
+	}
+
+	user := p.Evaluate(Subject{Mailbox: "bob@example.com", Domain: "example.com"})
+	if !user.Allowed || user.Group != "everyone" || user.AllowCustomPrefix || user.AllowsDomain("example.com") {
+		t.Errorf("unexpected decision for user: %+v", user)
+	}
+
+	if d := p.Evaluate(Subject{Mailbox: "carol@example.com", Domain: "example.com", Tags: []string{"no-aliases", "staff"}}); d.Allowed {
+		t.Error("deny rule did not take precedence")
+	}
+	if d := p.Evaluate(Subject{Mailbox: "guest@other.org", Domain: "other.org"}); !d.Allowed {
+		t.Error("allowed mailbox was denied")
+	}
+	if d := p.Evaluate(Subject{Mailbox: "dave@other.org", Domain: "other.org"}); d.Allowed {
+		t.Error("default deny was not applied")
+	}
+}
+
+func TestDefaultPolicy(t *testing.T) {
+	d := Default().Evaluate(Subject{Mailbox: "alice@example.com", Domain: "example.com"})
+	if !d.Allowed || !d.AllowsDomain("example.com") || d.AllowsDomain("other.org") || d.AllowCustomPrefix {
+		t.Errorf("unexpected default decision: %+v", d)
+	}
+
+	// The domain of a fixed-domain pattern is granted next to the own domain
+	d = Default("relay.example.net").Evaluate(Subject{Mailbox: "alice@example.com", Domain: "example.com"})
+	if d.AliasDomains[0] != "example.com" || !d.AllowsDomain("relay.example.net") || d.AllowsDomain("other.org") {
+		t.Errorf("unexpected default decision with pattern domain: %+v", d)
+	}
+	d = Default().Evaluate(Subject{Mailbox: "alice@example.com", Domain: "example.com"})
+	if d.AllowsDomain("relay.example.net") {
+		t.Error("pattern domain leaked into the shared default group")
+	}
+}
+
+func TestOwnDomainsReplaceMailboxDomain(t *testing.T) {
+	d := Default().Evaluate(Subject{
+		Mailbox:    "alice@example.com",
+		Domain:     "example.com",
+		OwnDomains: []string{"Alias.example.com", "relay.example.net"},
+	})
+	if d.AllowsDomain("example.com") || !d.AllowsDomain("alias.example.com") || !d.AllowsDomain("relay.example.net") {
+		t.Errorf("unexpected alias domains: %v", d.AliasDomains)
+	}
+	if d.AliasDomains[0] != "alias.example.com" {
+		t.Errorf("expected the first mapped domain to be preferred, got %v", d.AliasDomains)
+	}
+}
+
+func TestAliasTypeChoice(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	err := os.WriteFile(path, []byte(`{
+		"default": "allow",
+		"groups": [
+			{"name": "enforced", "match": {"tags": ["enforced"]}, "alias_type": "temporary"},
+			{"name": "fixed", "match": {"tags": ["fixed"]}, "allow_alias_type_choice": false},
+			{"name": "choice", "match": {"tags": ["choice"]}, "alias_type": "temporary", "allow_alias_type_choice": true}
+		]
+	}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	expected := map[string]bool{"enforced": false, "fixed": false, "choice": true, "": true}
+	for tag, want := range expected {
+		d := p.Evaluate(Subject{Mailbox: "alice@example.com", Domain: "example.com", Tags: []string{tag}})
+		if d.AllowAliasTypeChoice != want {
+			t.Errorf("tag %q: expected choice %v, got %+v", tag, want, d)
+		}
+	}
+}
+
+func TestLoadRejectsUnknownAction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	os.WriteFile(path, []byte(`{"rules": [{"action": "maybe"}]}`), 0o600)
+	if _, err := Load(path); err == nil {
+		t.Error("unknown rule action accepted")
+	}
+}
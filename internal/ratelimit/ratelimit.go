@@ -0,0 +1,122 @@
+package ratelimit
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/clientip"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/logger"
+)
+
+// bucket is the token bucket of a single client
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter is a token-bucket rate limiter keyed by client
+type Limiter struct {
+	mu      sync.Mutex
+	rate    float64 // tokens per second
+	burst   float64
+	buckets map[string]*bucket
+	exempt  map[string]bool // paths that are never limited
+	logger  *logger.Logger
+}
+
+// NewLimiter creates a limiter allowing rate requests per second with bursts of up to burst requests
+func NewLimiter(rate float64, burst int) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+		exempt:  make(map[string]bool),
+		logger:  logger.WithComponent("RateLimit"),
+	}
+}
+
+// Allow takes a token from the bucket of key. If none is left it returns
+// false and the time until the next token is available.
+func (l *Limiter) Allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// Cleanup removes buckets that have refilled completely, returning the number removed
+func (l *Limiter) Cleanup(now time.Time) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	removed := 0
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Size returns the number of tracked clients
+func (l *Limiter) Size() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
+
+// Exempt excludes paths from limiting, e.g. health checks of an orchestrator
+func (l *Limiter) Exempt(paths ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, path := range paths {
+		l.exempt[path] = true
+	}
+}
+
+// isExempt reports whether requests to path are never limited
+func (l *Limiter) isExempt(path string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.exempt[path]
+}
+
+// Middleware rejects requests of clients exceeding the rate with 429 Too Many Requests
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.isExempt(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ip := clientip.FromRequest(r)
+		if ok, wait := l.Allow(ip, time.Now()); !ok {
+			l.logger.Warn("Rate limit exceeded by %s", ip)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "Too many requests: rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
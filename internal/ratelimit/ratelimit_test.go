@@ -0,0 +1,60 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	limiter := NewLimiter(2, 3)
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := limiter.Allow("198.51.100.7", now); !ok {
+			t.Fatalf("request %d within burst was rejected", i+1)
+		}
+	}
+
+	ok, wait := limiter.Allow("198.51.100.7", now)
+	if ok || wait != 500*time.Millisecond {
+		t.Fatalf("expected rejection with 500ms wait, got %v %s", ok, wait)
+	}
+	if ok, _ := limiter.Allow("203.0.113.5", now); !ok {
+		t.Error("other clients must have their own bucket")
+	}
+
+	if ok, _ := limiter.Allow("198.51.100.7", now.Add(500*time.Millisecond)); !ok {
+		t.Error("expected a refilled token after 500ms")
+	}
+
+	if removed := limiter.Cleanup(now.Add(10 * time.Second)); removed != 2 || limiter.Size() != 0 {
+		t.Errorf("expected both recovered buckets to be removed, removed %d, %d left", removed, limiter.Size())
+	}
+}
+
+func TestMiddlewareExemptPaths(t *testing.T) {
+	limiter := NewLimiter(1, 1)
+	limiter.Exempt("/healthz")
+	handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	status := func(path string) int {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest("GET", path, nil)
+		request.RemoteAddr = "198.51.100.7:1234"
+		handler.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+	if code := status("/api/user_info"); code != http.StatusOK {
+		t.Fatalf("first request rejected with %d", code)
+	}
+	if code := status("/api/user_info"); code != http.StatusTooManyRequests {
+		t.Errorf("expected 429 beyond the burst, got %d", code)
+	}
+	for i := 0; i < 3; i++ {
+		if code := status("/healthz"); code != http.StatusOK {
+			t.Errorf("exempt path limited with %d", code)
+		}
+	}
+}
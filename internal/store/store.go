@@ -0,0 +1,362 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned for unknown aliases and mailbox IDs
+var ErrNotFound = errors.New("not found in store")
+
+// AliasRecord is the metadata of an alias created by the bridge
+type AliasRecord struct {
+	ID        int        `json:"id"`
+	Address   string     `json:"address"`
+	Owner     string     `json:"owner"`
+	Type      string     `json:"type,omitempty"`
+	Hostname  string     `json:"hostname,omitempty"` // site the alias was created for
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // only set for aliases expiring on their own
+	// LastActivity is the last time mail for the alias was seen in the Mailcow logs
+	LastActivity *time.Time `json:"last_activity,omitempty"`
+	Disabled     bool       `json:"disabled,omitempty"`
+	Mailboxes    []string   `json:"mailboxes,omitempty"` // destinations, the owner if empty
+	NbForward    int        `json:"nb_forward,omitempty"`
+	NbBlock      int        `json:"nb_block,omitempty"`
+	Activities   []Activity `json:"activities,omitempty"` // newest first, at most MaxActivities
+}
+
+// Activity actions
+const (
+	ActivityForward = "forward"
+	ActivityBlock   = "block"
+)
+
+// MaxActivities is the number of activities kept per alias
+const MaxActivities = 100
+
+// Activity is a mail seen for an alias in the Mailcow logs
+type Activity struct {
+	Action    string    `json:"action"`
+	From      string    `json:"from,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// storeData is the persisted content of the store
+type storeData struct {
+	NextAliasID int            `json:"next_alias_id"`
+	Aliases     []*AliasRecord `json:"aliases"`
+	// ActivityCursors are the times of the newest log entries already counted, per log source
+	ActivityCursors map[string]time.Time `json:"activity_cursors,omitempty"`
+	// ActivityObservedSince are the starts of the uninterrupted reading of each log source,
+	// aliases created before have no complete activity
+	ActivityObservedSince map[string]time.Time `json:"activity_observed_since,omitempty"`
+	// Mailcow mailboxes have no numeric IDs, so the store hands them out
+	NextMailboxID int            `json:"next_mailbox_id"`
+	MailboxIDs    map[string]int `json:"mailbox_ids,omitempty"`
+}
+
+// Store keeps bridge metadata, persisted as a JSON file
+type Store struct {
+	path string
+	mu   sync.RWMutex
+	data storeData
+}
+
+// Open loads the store from path. An empty path keeps the store in memory only.
+func Open(path string) (*Store, error) {
+	s := &Store{path: path, data: storeData{NextAliasID: 1, NextMailboxID: 1}}
+	if path == "" {
+		return s, nil
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read store: %w", err)
+	}
+
+	if err := json.Unmarshal(content, &s.data); err != nil {
+		return nil, fmt.Errorf("failed to decode store: %w", err)
+	}
+	if s.data.NextAliasID < 1 {
+		s.data.NextAliasID = 1
+	}
+	if s.data.NextMailboxID < 1 {
+		s.data.NextMailboxID = 1
+	}
+	return s, nil
+}
+
+// IsPersistent reports whether the store is backed by a file
+func (s *Store) IsPersistent() bool {
+	return s.path != ""
+}
+
+// save writes the store atomically, the caller must hold the lock
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	content, err := json.Marshal(&s.data)
+	if err != nil {
+		return fmt.Errorf("failed to encode store: %w", err)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), ".store-*")
+	if err != nil {
+		return fmt.Errorf("failed to create store file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write store: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to replace store: %w", err)
+	}
+	return nil
+}
+
+// AddAlias records a newly created alias and assigns its ID. Nothing is recorded if saving fails.
+func (s *Store) AddAlias(record AliasRecord) (*AliasRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record.ID = s.data.NextAliasID
+	record.Address = strings.ToLower(record.Address)
+	record.Owner = strings.ToLower(record.Owner)
+	if record.CreatedAt.IsZero() {
+		record.CreatedAt = time.Now().UTC()
+	}
+
+	s.data.NextAliasID++
+	s.data.Aliases = append(s.data.Aliases, &record)
+
+	// Keep the memory in line with the file, a record that was not saved must not be handed out later
+	if err := s.save(); err != nil {
+		s.data.Aliases = s.data.Aliases[:len(s.data.Aliases)-1]
+		s.data.NextAliasID--
+		return nil, err
+	}
+
+	stored := record
+	return &stored, nil
+}
+
+// AliasesOf returns copies of all alias records of an owner
+func (s *Store) AliasesOf(owner string) []AliasRecord {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var records []AliasRecord
+	for _, record := range s.data.Aliases {
+		if strings.EqualFold(record.Owner, owner) {
+			records = append(records, *record)
+		}
+	}
+	return records
+}
+
+// Aliases returns copies of all alias records
+func (s *Store) Aliases() []AliasRecord {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	records := make([]AliasRecord, 0, len(s.data.Aliases))
+	for _, record := range s.data.Aliases {
+		records = append(records, *record)
+	}
+	return records
+}
+
+// AliasByID returns a copy of the record with the given ID
+func (s *Store) AliasByID(id int) (*AliasRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, record := range s.data.Aliases {
+		if record.ID == id {
+			stored := *record
+			return &stored, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// AliasByAddress returns a copy of the record of an address
+func (s *Store) AliasByAddress(address string) (*AliasRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, record := s.find(address)
+	if record == nil {
+		return nil, ErrNotFound
+	}
+	stored := *record
+	return &stored, nil
+}
+
+// find returns the record of an address, the caller must hold the lock
+func (s *Store) find(address string) (int, *AliasRecord) {
+	for i, record := range s.data.Aliases {
+		if strings.EqualFold(record.Address, address) {
+			return i, record
+		}
+	}
+	return -1, nil
+}
+
+// UpdateAlias applies update to the record of an address and persists the change
+func (s *Store) UpdateAlias(address string, update func(*AliasRecord)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, record := s.find(address)
+	if record == nil {
+		return ErrNotFound
+	}
+	update(record)
+	return s.save()
+}
+
+// DeleteAlias removes the record of an address
+func (s *Store) DeleteAlias(address string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, _ := s.find(address)
+	if i < 0 {
+		return ErrNotFound
+	}
+	s.data.Aliases = append(s.data.Aliases[:i], s.data.Aliases[i+1:]...)
+	return s.save()
+}
+
+// MailboxID returns the ID of a mailbox address, assigning a new one on first use
+func (s *Store) MailboxID(address string) (int, error) {
+	address = strings.ToLower(address)
+
+	s.mu.RLock()
+	id, ok := s.data.MailboxIDs[address]
+	s.mu.RUnlock()
+	if ok {
+		return id, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id, ok := s.data.MailboxIDs[address]; ok {
+		return id, nil
+	}
+	if s.data.MailboxIDs == nil {
+		s.data.MailboxIDs = make(map[string]int)
+	}
+	id = s.data.NextMailboxID
+	s.data.NextMailboxID++
+	s.data.MailboxIDs[address] = id
+	return id, s.save()
+}
+
+// MailboxAddress returns the mailbox address of an ID
+func (s *Store) MailboxAddress(id int) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for address, candidate := range s.data.MailboxIDs {
+		if candidate == id {
+			return address, nil
+		}
+	}
+	return "", ErrNotFound
+}
+
+// ActivityCursor returns the time of the newest log entry of a source already counted
+func (s *Store) ActivityCursor(source string) time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.ActivityCursors[source]
+}
+
+// ActivityObservedSince returns since when the logs of a source have been read without gaps.
+// It returns false if the source has not been read yet.
+func (s *Store) ActivityObservedSince(source string) (time.Time, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	since, ok := s.data.ActivityObservedSince[source]
+	return since, ok
+}
+
+// RestartActivityObservation records that the logs of a source are read without gaps from at on,
+// after the first read or after log entries may have been missed
+func (s *Store) RestartActivityObservation(source string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.data.ActivityObservedSince == nil {
+		s.data.ActivityObservedSince = make(map[string]time.Time)
+	}
+	s.data.ActivityObservedSince[source] = at.UTC()
+	return s.save()
+}
+
+// AddActivities counts the activities per address, advances the cursor of their source and persists
+// both at once. Activities of unknown addresses are ignored. It returns the number of activities recorded.
+func (s *Store) AddActivities(activities map[string][]Activity, source string, cursor time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	recorded := 0
+	for address, list := range activities {
+		_, record := s.find(address)
+		if record == nil {
+			continue
+		}
+		for _, activity := range list {
+			switch activity.Action {
+			case ActivityForward:
+				record.NbForward++
+			case ActivityBlock:
+				record.NbBlock++
+			}
+			if record.LastActivity == nil || activity.Timestamp.After(*record.LastActivity) {
+				at := activity.Timestamp
+				record.LastActivity = &at
+			}
+			recorded++
+		}
+
+		// Copies handed out earlier share the old slice, so build a new one
+		merged := append(append([]Activity{}, list...), record.Activities...)
+		sort.SliceStable(merged, func(i, j int) bool {
+			return merged[i].Timestamp.After(merged[j].Timestamp)
+		})
+		if len(merged) > MaxActivities {
+			merged = merged[:MaxActivities]
+		}
+		record.Activities = merged
+	}
+
+	if cursor.After(s.data.ActivityCursors[source]) {
+		if s.data.ActivityCursors == nil {
+			s.data.ActivityCursors = make(map[string]time.Time)
+		}
+		s.data.ActivityCursors[source] = cursor
+	}
+	return recorded, s.save()
+}
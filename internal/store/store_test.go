@@ -0,0 +1,123 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStorePersistsAliases(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	now := time.Now().UTC()
+	first, err := s.AddAlias(AliasRecord{Address: "One@example.com", Owner: "Alice@example.com", CreatedAt: now.Add(-2 * time.Hour)})
+	if err != nil {
+		t.Fatalf("AddAlias: %v", err)
+	}
+	second, err := s.AddAlias(AliasRecord{Address: "two@example.com", Owner: "alice@example.com", CreatedAt: now})
+	if err != nil {
+		t.Fatalf("AddAlias: %v", err)
+	}
+	if first.ID != 1 || second.ID != 2 || first.Address != "one@example.com" {
+		t.Errorf("unexpected records: %+v, %+v", first, second)
+	}
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if got := len(reopened.AliasesOf("ALICE@example.com")); got != 2 {
+		t.Errorf("expected 2 aliases after reopening, got %d", got)
+	}
+	if got := reopened.AliasesOf("alice@example.com")[0].CreatedAt; !got.Equal(now.Add(-2 * time.Hour)) {
+		t.Errorf("creation time not persisted, got %s", got)
+	}
+
+	third, err := reopened.AddAlias(AliasRecord{Address: "three@example.com", Owner: "bob@example.com"})
+	if err != nil {
+		t.Fatalf("AddAlias: %v", err)
+	}
+	if third.ID != 3 {
+		t.Errorf("expected IDs to continue after reopening, got %d", third.ID)
+	}
+}
+
+func TestStoreAddAliasSaveFailure(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "data")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	s, err := Open(filepath.Join(dir, "store.json"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	// Saving fails while the directory is gone
+	if err := os.Remove(dir); err != nil {
+		t.Fatal(err)
+	}
+	if record, err := s.AddAlias(AliasRecord{Address: "lost@example.com", Owner: "alice@example.com"}); err == nil {
+		t.Fatalf("expected the save to fail, got %+v", record)
+	}
+	if records := s.Aliases(); len(records) != 0 {
+		t.Errorf("unsaved record kept in memory: %+v", records)
+	}
+
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	record, err := s.AddAlias(AliasRecord{Address: "kept@example.com", Owner: "alice@example.com"})
+	if err != nil {
+		t.Fatalf("AddAlias: %v", err)
+	}
+	if record.ID != 1 {
+		t.Errorf("expected the failed save to release its ID, got %d", record.ID)
+	}
+}
+
+func TestStoreAddActivities(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "store.json"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if _, err := s.AddAlias(AliasRecord{Address: "site@example.com", Owner: "alice@example.com"}); err != nil {
+		t.Fatalf("AddAlias: %v", err)
+	}
+
+	now := time.Now().UTC()
+	recorded, err := s.AddActivities(map[string][]Activity{
+		"site@example.com": {
+			{Action: ActivityForward, From: "shop@example.org", Timestamp: now.Add(-time.Hour)},
+			{Action: ActivityBlock, From: "spam@example.net", Timestamp: now},
+		},
+		"unknown@example.com": {{Action: ActivityForward, Timestamp: now}},
+	}, "default", now)
+	if err != nil {
+		t.Fatalf("AddActivities: %v", err)
+	}
+	if recorded != 2 {
+		t.Errorf("expected 2 recorded activities, got %d", recorded)
+	}
+
+	record, err := s.AliasByID(1)
+	if err != nil {
+		t.Fatalf("AliasByID: %v", err)
+	}
+	if record.NbForward != 1 || record.NbBlock != 1 || record.LastActivity == nil || !record.LastActivity.Equal(now) {
+		t.Errorf("unexpected counters: %+v", record)
+	}
+	if len(record.Activities) != 2 || record.Activities[0].Action != ActivityBlock {
+		t.Errorf("activities not ordered newest first: %+v", record.Activities)
+	}
+	if !s.ActivityCursor("default").Equal(now) || !s.ActivityCursor("other").IsZero() {
+		t.Errorf("cursor not advanced for its source only, got %s", s.ActivityCursor("default"))
+	}
+	if _, err := s.AliasByID(2); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
@@ -1,16 +1,33 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
 	"time"
 
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/activity"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/alias"
 	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/api"
 	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/auth"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/backend"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/clientip"
 	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/config"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/devmode"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/gc"
 	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/logger"
 	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/mailcow"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/mailu"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/policy"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/ratelimit"
+	"git.ruekov.eu/ruakij/simplelogin-mailcow-bridge/internal/store"
 )
 
 // Logger middleware to log all requests
@@ -32,9 +49,10 @@ func requestLogger(next http.Handler) http.Handler {
 		duration := time.Since(start)
 		durationFormatted := logger.FormatDuration(duration)
 
-		// Log the request with appropriate level based on status code
+		// Log the request with appropriate level based on status code. The query is left out,
+		// it carries the signatures of burn links.
 		logMsg := fmt.Sprintf("[%s] %s %s %s - %d %s",
-			r.RemoteAddr, r.Method, r.RequestURI, r.Proto, rw.statusCode, durationFormatted)
+			clientip.FromRequest(r), r.Method, r.URL.Path, r.Proto, rw.statusCode, durationFormatted)
 
 		if rw.statusCode >= 500 {
 			log.Error(logMsg)
@@ -65,7 +83,7 @@ func setupLogging(cfg *config.Config) {
 	logger.Info("Logging initialized with level: %s, colors: %v", cfg.LogLevel, cfg.LogColorize)
 }
 
-// setupCacheCleanup sets up a background goroutine to periodically clean the auth cache
+// setupCacheCleanup sets up a background goroutine to periodically clean the auth cache and lockout state
 func setupCacheCleanup(authModule *auth.AuthModule, interval time.Duration) {
 	ticker := time.NewTicker(interval)
 	log := logger.WithComponent("CacheCleanup")
@@ -79,15 +97,305 @@ func setupCacheCleanup(authModule *auth.AuthModule, interval time.Duration) {
 					log.Info("Auth cache stats - Total: %d, Valid: %d, Cleaned: %d", total, valid, cleaned)
 				}
 			}
+
+			cleaned := authModule.CleanupLockouts()
+			stats := authModule.LockoutStats()
+			if stats.LockedUsers > 0 || stats.LockedClients > 0 || cleaned > 0 {
+				log.Info("Auth lockout stats - Locked users: %d, Locked clients: %d, Failed credentials: %d, Total lockouts: %d, Cleaned: %d",
+					stats.LockedUsers, stats.LockedClients, stats.FailedCreds, stats.TotalLockouts, cleaned)
+			}
 		}
 	}()
 
 	log.Info("Auth cache cleanup initialized with interval: %s", interval)
 }
 
+// newBackend sets up the alias backend of a backend, with a client of its Mailcow API if it has one, and
+// the authentication module of its auth server. Neither server is contacted, see checkBackends. With
+// several backends each keeps its own auth cache snapshot, named after the backend.
+func newBackend(cfg *config.Config, backendCfg config.BackendConfig, multiple bool) (*backend.Backend, error) {
+	b := &backend.Backend{Name: backendCfg.Name, Domains: backendCfg.Domains}
+
+	switch backendCfg.Type {
+	case config.BackendPostfix:
+		logger.WithComponent("Postfix").Info("Using virtual alias map %s for backend %s", backendCfg.VirtualMap, backendCfg.Name)
+		b.Aliases = backend.NewPostfixAliases(backendCfg.VirtualMap, backendCfg.PostmapCommand, backendCfg.AliasDomains)
+	case config.BackendDockerMailserver:
+		logger.WithComponent("Postfix").Info("Using docker-mailserver config directory %s for backend %s", backendCfg.ConfigDir, backendCfg.Name)
+		b.Aliases = backend.NewDockerMailserverAliases(backendCfg.ConfigDir, backendCfg.AliasDomains)
+	case config.BackendMemory:
+		b.Aliases = backend.NewMemoryAliases(backendCfg.AliasDomains)
+	case config.BackendMailu:
+		logger.WithComponent("Mailu").Info("Using Mailu API at %s for backend %s", backendCfg.APIURL, backendCfg.Name)
+		client, err := mailu.NewClient(backendCfg.APIURL, backendCfg.APIKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize Mailu API client: %w", err)
+		}
+		b.Aliases = backend.NewMailuAliases(client)
+	default:
+		// Initialize Mailcow API client
+		mailcowLog := logger.WithComponent("Mailcow")
+		mailcowLog.Info("Initializing Mailcow API client for backend %s with URL: %s", backendCfg.Name, backendCfg.APIURL)
+
+		mailcowClient, err := mailcow.NewMailcowClient(backendCfg.APIURL, backendCfg.APIKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize Mailcow API client: %w", err)
+		}
+		b.Mailcow = mailcowClient
+		b.Aliases = backend.NewMailcowAliases(mailcowClient)
+	}
+
+	// Initialize authentication module
+	authLog := logger.WithComponent("Auth")
+	authLog.Info("Initializing authentication module with method: %s, server: %s", backendCfg.AuthMethod, backendCfg.ServerAddress)
+
+	authModule, err := auth.NewAuthModule(backendCfg.AuthMethod, backendCfg.ServerAddress, cfg.AuthCacheTTL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize authentication module: %w", err)
+	}
+	if strings.EqualFold(backendCfg.AuthMethod, "LDAP") {
+		err := authModule.SetLDAPOptions(auth.LDAPOptions{
+			StartTLS:           cfg.LDAPStartTLS,
+			BindDNTemplate:     cfg.LDAPBindDNTemplate,
+			SearchBindDN:       cfg.LDAPSearchBindDN,
+			SearchBindPassword: cfg.LDAPSearchBindPassword,
+			BaseDN:             cfg.LDAPBaseDN,
+			UserFilter:         cfg.LDAPUserFilter,
+			GroupFilter:        cfg.LDAPGroupFilter,
+			GroupBaseDN:        cfg.LDAPGroupBaseDN,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure LDAP authentication: %w", err)
+		}
+	}
+	authLog.Info("Authentication module initialized successfully")
+
+	authModule.SetCacheLimit(cfg.AuthCacheMaxEntries)
+	if cfg.AuthCacheSecret != "" {
+		authModule.SetCacheSecret(cfg.AuthCacheSecret)
+	}
+	if cfg.AuthCacheSnapshotPath != "" && cfg.AuthCacheTTL > 0 {
+		snapshotPath := backendSnapshotPath(cfg.AuthCacheSnapshotPath, backendCfg.Name, multiple)
+		restored, err := authModule.LoadCacheSnapshot(snapshotPath)
+		if err != nil && !os.IsNotExist(err) {
+			authLog.Warn("Failed to restore auth cache snapshot: %v", err)
+		} else if err == nil {
+			authLog.Info("Restored %d cached authentications from snapshot", restored)
+		}
+		authModule.SetCacheSnapshotPath(snapshotPath)
+		setupCacheSnapshots(authModule, snapshotPath, time.Minute)
+	}
+
+	authModule.SetLockoutPolicy(auth.LockoutPolicy{
+		UserThreshold:   cfg.AuthLockoutThreshold,
+		ClientThreshold: cfg.AuthLockoutClientThreshold,
+		BaseDelay:       time.Duration(cfg.AuthLockoutBaseDelay) * time.Second,
+		MaxDelay:        time.Duration(cfg.AuthLockoutMaxDelay) * time.Second,
+		FailureCacheTTL: time.Duration(cfg.AuthFailureCacheTTL) * time.Second,
+	})
+	authLog.Info("Brute-force protection: user threshold %d, client threshold %d, lockout %ds-%ds",
+		cfg.AuthLockoutThreshold, cfg.AuthLockoutClientThreshold, cfg.AuthLockoutBaseDelay, cfg.AuthLockoutMaxDelay)
+
+	authModule.SetMaxConcurrent(cfg.AuthMaxConcurrent, time.Duration(cfg.AuthQueueTimeout)*time.Second)
+	if cfg.AuthMaxConcurrent > 0 {
+		authLog.Info("Upstream authentications limited to %d concurrent connections", cfg.AuthMaxConcurrent)
+	}
+
+	// Setup cache and lockout cleanup
+	setupCacheCleanup(authModule, 10*time.Second)
+
+	b.Auth = authModule
+	return b, nil
+}
+
+// backendSnapshotPath returns the auth cache snapshot path of a backend, suffixed with its name
+// if there are several backends
+func backendSnapshotPath(path, name string, multiple bool) string {
+	if multiple {
+		return path + "." + name
+	}
+	return path
+}
+
+// validateAliasDomains checks that the domains of the alias domain map routed to a backend are served
+// by its mail server. The entry for all other domains applies to every backend.
+func validateAliasDomains(b *backend.Backend, backends *backend.Set, domainMap map[string][]string) error {
+	checker, ok := b.Aliases.(backend.DomainChecker)
+	if !ok {
+		return nil
+	}
+	for mailboxDomain, targets := range domainMap {
+		if mailboxDomain != "*" {
+			if routed, err := backends.ForDomain(mailboxDomain); err != nil || routed != b {
+				continue
+			}
+			err := checker.CheckDomain(mailboxDomain)
+			if errors.Is(err, backend.ErrUnavailable) {
+				logger.Warn("Cannot validate ALIAS_DOMAIN_MAP while the mail server of backend %s is unavailable", b.Name)
+				return nil
+			}
+			if errors.Is(err, backend.ErrUnknownDomain) {
+				logger.Warn("ALIAS_DOMAIN_MAP maps %s, which is not a domain of backend %s", mailboxDomain, b.Name)
+			}
+		}
+		for _, target := range targets {
+			if target == alias.DomainPlaceholder {
+				continue
+			}
+			err := checker.CheckDomain(target)
+			if errors.Is(err, backend.ErrUnavailable) {
+				logger.Warn("Cannot validate ALIAS_DOMAIN_MAP while the mail server of backend %s is unavailable", b.Name)
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("alias domain of %s: %w", mailboxDomain, err)
+			}
+		}
+	}
+	return nil
+}
+
+// checkAliasPattern warns if the alias generation pattern cannot produce an address on a valid domain
+func checkAliasPattern(aliases backend.AliasBackend, pattern string) {
+	at := strings.LastIndex(pattern, "@")
+	if at < 0 {
+		logger.Warn("ALIAS_GENERATION_PATTERN %q contains no @, generated aliases will be rejected", pattern)
+		return
+	}
+
+	domain := pattern[at+1:]
+	switch {
+	case domain == alias.DomainPlaceholder:
+		// Resolved per mailbox and checked before each alias is created
+	case strings.Contains(domain, alias.DomainPlaceholder) || strings.ContainsAny(domain, "{}"):
+		logger.Warn("ALIAS_GENERATION_PATTERN builds the domain from %q, aliases on unknown domains will be rejected", domain)
+	default:
+		checker, ok := aliases.(backend.DomainChecker)
+		if !ok {
+			return
+		}
+		err := checker.CheckDomain(domain)
+		if errors.Is(err, backend.ErrUnavailable) {
+			logger.Warn("Cannot check the domain of ALIAS_GENERATION_PATTERN while the mail server is unavailable")
+		} else if err != nil {
+			logger.Warn("ALIAS_GENERATION_PATTERN uses a fixed domain that cannot receive aliases: %v", err)
+		}
+	}
+}
+
+// checkBackends contacts the mail and auth servers of all backends. Unreachable servers are
+// recorded by their clients, which fail requests fast until a later check succeeds.
+func checkBackends(backends *backend.Set) (unavailable int) {
+	for _, b := range backends.All() {
+		mailErr, authErr := b.CheckHealth()
+		if mailErr != nil || authErr != nil {
+			unavailable++
+		}
+	}
+	return unavailable
+}
+
+// setupHealthChecks periodically checks the mail and auth servers, so unreachable ones are noticed
+// and recovered ones are used again
+func setupHealthChecks(backends *backend.Set, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	log := logger.WithComponent("Health")
+
+	go func() {
+		for range ticker.C {
+			if unavailable := checkBackends(backends); unavailable > 0 {
+				log.Debug("%d of %d backends are degraded", unavailable, len(backends.All()))
+			}
+		}
+	}()
+
+	log.Info("Health checks initialized with interval: %s", interval)
+}
+
+// setupActivitySync periodically aggregates alias activity from the Mailcow logs
+func setupActivitySync(tracker *activity.Tracker, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	log := logger.WithComponent("Activity")
+
+	go func() {
+		for range ticker.C {
+			recorded, err := tracker.Sync()
+			if err != nil {
+				log.Error("Activity sync failed: %v", err)
+				continue
+			}
+			if recorded > 0 {
+				log.Info("Recorded %d alias activities", recorded)
+			}
+		}
+	}()
+
+	log.Info("Activity sync initialized with interval: %s", interval)
+}
+
+// setupAliasGC periodically collects unused aliases
+func setupAliasGC(collector *gc.Collector, interval time.Duration, dryRun bool) {
+	ticker := time.NewTicker(interval)
+	log := logger.WithComponent("AliasGC")
+
+	go func() {
+		for range ticker.C {
+			report, err := collector.Run(dryRun)
+			if err != nil {
+				log.Error("Alias garbage collection failed: %v", err)
+				continue
+			}
+			for _, candidate := range report.Candidates {
+				log.Info("Unused alias %s of %s created %s: %s (dry run: %v)",
+					candidate.Address, candidate.Owner, candidate.CreatedAt.Format(time.RFC3339), candidate.Action, dryRun)
+			}
+		}
+	}()
+
+	log.Info("Alias garbage collection initialized with interval: %s", interval)
+}
+
+// setupRateLimitCleanup periodically forgets clients whose rate limit has fully recovered
+func setupRateLimitCleanup(limiter *ratelimit.Limiter, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	log := logger.WithComponent("RateLimit")
+
+	go func() {
+		for range ticker.C {
+			cleaned := limiter.Cleanup(time.Now())
+			if cleaned > 0 {
+				log.Debug("Rate limit stats - Tracked clients: %d, Cleaned: %d", limiter.Size(), cleaned)
+			}
+		}
+	}()
+}
+
+// setupCacheSnapshots periodically persists the auth cache to an encrypted snapshot
+func setupCacheSnapshots(authModule *auth.AuthModule, path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	log := logger.WithComponent("CacheSnapshot")
+
+	go func() {
+		for range ticker.C {
+			if _, err := authModule.SaveCacheSnapshot(path); err != nil {
+				log.Error("Failed to save auth cache snapshot: %v", err)
+			}
+		}
+	}()
+
+	log.Info("Auth cache snapshots enabled at %s with interval: %s", path, interval)
+}
+
 func main() {
+	devMode := flag.Bool("dev", false, "run without mail server, with in-memory aliases and the test users of DEV_USERS")
+	flag.Parse()
+
 	// Load configuration first (without logging)
-	cfg, err := config.LoadConfig()
+	loadConfig := config.LoadConfig
+	if *devMode {
+		loadConfig = config.LoadDevConfig
+	}
+	cfg, err := loadConfig()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
 		os.Exit(1)
@@ -98,7 +406,7 @@ func main() {
 	logger.Info("Starting SimpleLogin-Mailcow Bridge service")
 
 	// Log configuration details
-	logger.Info("Configuration loaded successfully. Using port: %d, Auth method: %s", cfg.Port, cfg.MailcowAuthMethod)
+	logger.Info("Configuration loaded successfully. Using port: %d, backends: %d", cfg.Port, len(cfg.Backends))
 
 	// Log cache configuration
 	if cfg.AuthCacheTTL > 0 {
@@ -107,45 +415,166 @@ func main() {
 		logger.Info("Auth caching disabled")
 	}
 
-	// Initialize Mailcow API client
-	mailcowLog := logger.WithComponent("Mailcow")
-	mailcowLog.Info("Initializing Mailcow API client with URL: %s", cfg.MailcowAdminAPIURL)
+	// In dev mode the test users log in against an in-process IMAP server
+	var devIMAP *devmode.IMAPServer
+	if *devMode {
+		devIMAP, err = devmode.StartIMAPServer(cfg.DevUsers)
+		if err != nil {
+			logger.Fatal("Failed to start dev IMAP server: %v", err)
+		}
+		defer devIMAP.Close()
+		cfg.Backends[0].ServerAddress = devIMAP.Addr()
 
-	mailcowClient, err := mailcow.NewMailcowClient(cfg.MailcowAdminAPIURL, cfg.MailcowAdminAPIKey)
+		usernames := make([]string, 0, len(cfg.DevUsers))
+		for username := range cfg.DevUsers {
+			usernames = append(usernames, username)
+		}
+		sort.Strings(usernames)
+		logger.Warn("Running in dev mode, aliases are kept in memory. Test users: %s", strings.Join(usernames, ", "))
+	}
+
+	// Initialize an alias backend and an authentication module per backend
+	var backendList []*backend.Backend
+	for _, backendCfg := range cfg.Backends {
+		b, err := newBackend(cfg, backendCfg, len(cfg.Backends) > 1)
+		if err != nil {
+			logger.Fatal("Failed to initialize backend %s: %v", backendCfg.Name, err)
+		}
+		if devIMAP != nil {
+			b.Auth.SetTLSConfig(devIMAP.ClientTLSConfig())
+		}
+		backendList = append(backendList, b)
+	}
+	backends, err := backend.NewSet(backendList)
 	if err != nil {
-		logger.Fatal("Failed to initialize Mailcow API client: %v", err)
+		logger.Fatal("Invalid backends: %v", err)
+	}
+	if cfg.OAuthClientID != "" && (backends.Default() == nil || backends.Default().Mailcow == nil) {
+		logger.Fatal("MAILCOW_OAUTH_CLIENT_ID requires a Mailcow backend serving the domain \"*\"")
 	}
-	mailcowLog.Info("Mailcow API client initialized successfully")
 
-	// Initialize authentication module
-	authLog := logger.WithComponent("Auth")
-	authLog.Info("Initializing authentication module with method: %s, server: %s", cfg.MailcowAuthMethod, cfg.MailcowServerAddress)
+	// Unreachable servers do not prevent startup, requests needing them fail until they recover
+	if unavailable := checkBackends(backends); unavailable > 0 {
+		logger.Warn("Starting degraded, %d of %d backends have unreachable servers, see /readyz", unavailable, len(backends.All()))
+	}
+	setupHealthChecks(backends, time.Duration(cfg.HealthCheckInterval)*time.Second)
 
-	authModule, err := auth.NewAuthModule(cfg.MailcowAuthMethod, cfg.MailcowServerAddress, cfg.AuthCacheTTL)
-	if err != nil {
-		logger.Fatal("Failed to initialize authentication module: %v", err)
+	// Validate alias domains
+	for _, b := range backends.All() {
+		checkAliasPattern(b.Aliases, cfg.AliasGenerationPattern)
+		if len(cfg.AliasDomainMap) > 0 {
+			if err := validateAliasDomains(b, backends, cfg.AliasDomainMap); err != nil {
+				logger.Fatal("Invalid ALIAS_DOMAIN_MAP for backend %s: %v", b.Name, err)
+			}
+		}
+	}
+	if len(cfg.AliasDomainMap) > 0 {
+		logger.Info("Alias domains mapped for %d mailbox domains", len(cfg.AliasDomainMap))
 	}
-	authLog.Info("Authentication module initialized successfully")
 
-	// Setup cache cleanup if caching is enabled
-	if cfg.AuthCacheTTL > 0 {
-		setupCacheCleanup(authModule, 10*time.Second)
+	// Load authorization policy
+	// Without a policy file, a pattern on a fixed domain grants that domain to everyone
+	var patternDomains []string
+	if domain := alias.PatternDomain(cfg.AliasGenerationPattern); domain != "" {
+		patternDomains = append(patternDomains, domain)
+	}
+	pol := policy.Default(patternDomains...)
+	if cfg.PolicyFile != "" {
+		pol, err = policy.Load(cfg.PolicyFile)
+		if err != nil {
+			logger.Fatal("Failed to load policy: %v", err)
+		}
+		logger.Info("Loaded policy from %s with %d rules and %d groups", cfg.PolicyFile, len(pol.Rules), len(pol.Groups))
+	}
+
+	// Open metadata store
+	st, err := store.Open(cfg.StorePath)
+	if err != nil {
+		logger.Fatal("Failed to open store: %v", err)
+	}
+	if st.IsPersistent() {
+		logger.Info("Using store at %s", cfg.StorePath)
+	} else if cfg.AliasQuotaTotal > 0 || cfg.AliasQuotaHourly > 0 || cfg.AliasQuotaDaily > 0 || cfg.PolicyFile != "" {
+		logger.Warn("STORE_PATH not set, alias limits are kept in memory and reset on restart")
 	}
 
 	// Initialize API
 	apiLog := logger.WithComponent("API")
 	apiLog.Info("Initializing API endpoints")
 
-	apiHandler := api.NewAPI(cfg, mailcowClient, authModule)
+	apiHandler := api.NewAPI(cfg, backends, pol, st)
+
+	// Aggregate alias activity from the Mailcow logs
+	tracker := activity.NewTracker(backends, st, cfg.ActivityLogLines)
+	if cfg.ActivitySyncInterval > 0 {
+		setupActivitySync(tracker, time.Duration(cfg.ActivitySyncInterval)*time.Minute)
+	}
+
+	// Garbage-collect unused aliases
+	if cfg.AliasGCAfterDays > 0 {
+		collector := gc.NewCollector(backends, st, pol, tracker, gc.Options{
+			MaxAge: time.Duration(cfg.AliasGCAfterDays) * 24 * time.Hour,
+			Action: cfg.AliasGCAction,
+		})
+		apiHandler.SetCollector(collector)
+		setupAliasGC(collector, time.Duration(cfg.AliasGCInterval)*time.Hour, cfg.AliasGCDryRun)
+		if !st.IsPersistent() {
+			logger.Warn("STORE_PATH not set, aliases created before a restart are not garbage-collected")
+		}
+	}
 	apiLog.Info("API initialized successfully")
 
-	// Add request logging middleware
-	handler := requestLogger(apiHandler.Router())
+	// Add rate limiting and request logging middleware, both acting on the real client IP
+	resolver, err := clientip.NewResolver(cfg.TrustedProxies, cfg.TrustedProxyHeader)
+	if err != nil {
+		logger.Fatal("Invalid TRUSTED_PROXIES: %v", err)
+	}
+	if len(cfg.TrustedProxies) > 0 {
+		logger.Info("Trusting %s headers from %s", cfg.TrustedProxyHeader, strings.Join(cfg.TrustedProxies, ", "))
+	}
+
+	handler := apiHandler.Router()
+	if cfg.RateLimitRPS > 0 {
+		limiter := ratelimit.NewLimiter(cfg.RateLimitRPS, cfg.RateLimitBurst)
+		limiter.Exempt("/healthz", "/readyz")
+		setupRateLimitCleanup(limiter, time.Minute)
+		handler = limiter.Middleware(handler)
+		logger.Info("Rate limiting clients to %g requests per second, bursts of %d", cfg.RateLimitRPS, cfg.RateLimitBurst)
+	}
+	handler = resolver.Middleware(requestLogger(handler))
 
 	// Start server
-	serverAddr := fmt.Sprintf(":%d", cfg.Port)
-	logger.Info("Server starting and listening on port %d...", cfg.Port)
-	if err := http.ListenAndServe(serverAddr, handler); err != nil {
-		logger.Fatal("Server failed to start: %v", err)
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", cfg.Port),
+		Handler: handler,
+	}
+	go func() {
+		logger.Info("Server starting and listening on port %d...", cfg.Port)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Fatal("Server failed to start: %v", err)
+		}
+	}()
+
+	// Wait for a shutdown signal
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+	logger.Info("Received %s, shutting down", sig)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		logger.Error("Server shutdown failed: %v", err)
+	}
+
+	if cfg.AuthCacheSnapshotPath != "" && cfg.AuthCacheTTL > 0 {
+		for _, b := range backends.All() {
+			saved, err := b.Auth.SaveCacheSnapshot(backendSnapshotPath(cfg.AuthCacheSnapshotPath, b.Name, len(backends.All()) > 1))
+			if err != nil {
+				logger.Error("Failed to save auth cache snapshot of backend %s: %v", b.Name, err)
+			} else {
+				logger.Info("Saved %d cached authentications of backend %s to snapshot", saved, b.Name)
+			}
+		}
 	}
 }